@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	_ "time/tzdata" // the scratch image has no zoneinfo for graph timezones
 
 	"github.com/home-operations/kromgo/internal/config"
 	"github.com/home-operations/kromgo/internal/kromgo"
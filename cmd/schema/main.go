@@ -7,13 +7,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 
 	"github.com/home-operations/kromgo/internal/config"
 	"github.com/invopop/jsonschema"
 )
 
 func main() {
-	schema := jsonschema.Reflect(&config.KromgoConfig{})
+	r := jsonschema.Reflector{Mapper: mapType}
+	schema := r.Reflect(&config.KromgoConfig{})
 	data, err := json.MarshalIndent(schema, "", "  ")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "error generating schema:", err)
@@ -21,3 +23,16 @@ func main() {
 	}
 	fmt.Println(string(data))
 }
+
+// mapType gives the schema of a config type whose YAML form its Go type doesn't
+// describe, or nil to reflect it as usual.
+func mapType(t reflect.Type) *jsonschema.Schema {
+	if t == reflect.TypeFor[config.Legend]() {
+		// A legend is a YAML bool, or "table".
+		return &jsonschema.Schema{OneOf: []*jsonschema.Schema{
+			{Type: "boolean"},
+			{Type: "string", Enum: []any{config.LegendTable}},
+		}}
+	}
+	return nil
+}
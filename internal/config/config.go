@@ -3,9 +3,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"regexp"
+	"slices"
+	"time"
 
 	"go.yaml.in/yaml/v4"
 )
@@ -21,8 +25,25 @@ type KromgoConfig struct {
 	Gallery    Gallery  `yaml:"gallery,omitempty" json:"gallery,omitempty"`
 	Cache      Cache    `yaml:"cache,omitempty" json:"cache,omitempty"`
 	Defaults   Defaults `yaml:"defaults,omitempty" json:"defaults,omitempty"`
-	Badges     []Badge  `yaml:"badges,omitempty" json:"badges,omitempty"`
-	Graphs     []Graph  `yaml:"graphs,omitempty" json:"graphs,omitempty"`
+	// Themes are user-defined graph themes, keyed by the name theme: and ?theme=
+	// select them by. A name may not shadow a built-in theme.
+	Themes map[string]Theme `yaml:"themes,omitempty" json:"themes,omitempty"`
+	Badges []Badge          `yaml:"badges,omitempty" json:"badges,omitempty"`
+	Graphs []Graph          `yaml:"graphs,omitempty" json:"graphs,omitempty"`
+}
+
+// Theme is a user-defined graph theme. Colors are names or hex, as elsewhere.
+type Theme struct {
+	// Dark marks a dark theme, which the library's dark defaults fill in for.
+	Dark bool `yaml:"dark,omitempty" json:"dark,omitempty"`
+	// Background, Text, Axis, and SplitLine color the canvas, labels and title, axis
+	// lines, and grid lines.
+	Background string `yaml:"background" json:"background"`
+	Text       string `yaml:"text" json:"text"`
+	Axis       string `yaml:"axis" json:"axis"`
+	SplitLine  string `yaml:"splitLine" json:"splitLine"`
+	// Series is the series color rotation; past its end the colors repeat, shaded.
+	Series []string `yaml:"series" json:"series"`
 }
 
 // Cache configures the Cache-Control headers kromgo sends with badge and graph
@@ -71,6 +92,12 @@ type BadgeDefaults struct {
 	Style string `yaml:"style,omitempty" json:"style,omitempty"`
 	// LabelColor is the default left-segment (label) color — a name or hex. Empty = grey (#555).
 	LabelColor string `yaml:"labelColor,omitempty" json:"labelColor,omitempty"`
+	// LabelColorDark is the default dark-mode label color — see Badge.LabelColorDark.
+	LabelColorDark string `yaml:"labelColorDark,omitempty" json:"labelColorDark,omitempty"`
+	// OnError is the default failure handling for badges — see Badge.OnError.
+	OnError BadgeFallback `yaml:"onError,omitempty" json:"onError,omitempty"`
+	// OnNoData is the default empty-result handling for badges — see Badge.OnNoData.
+	OnNoData BadgeFallback `yaml:"onNoData,omitempty" json:"onNoData,omitempty"`
 	// Gallery is the default gallery visibility for badges.
 	Gallery GallerySettings `yaml:"gallery,omitempty" json:"gallery,omitempty"`
 }
@@ -83,12 +110,22 @@ type GraphDefaults struct {
 	Width int `yaml:"width,omitempty" json:"width,omitempty"`
 	// Height is the image height in pixels (defaults to 200).
 	Height int `yaml:"height,omitempty" json:"height,omitempty"`
-	// Legend toggles the series legend (defaults to true).
-	Legend *bool `yaml:"legend,omitempty" json:"legend,omitempty"`
+	// Legend shows the series legend: true (the default), false, or table — see Graph.Legend.
+	Legend Legend `yaml:"legend,omitempty" json:"legend,omitempty"`
+	// LegendColumns are the default legend table columns — see Graph.LegendColumns.
+	LegendColumns []string `yaml:"legendColumns,omitempty" json:"legendColumns,omitempty"`
 	// Fill draws a translucent area beneath graph lines (defaults to false).
 	Fill *bool `yaml:"fill,omitempty" json:"fill,omitempty"`
+	// Tooltips adds hover tooltips to graph SVGs (defaults to false) — see Graph.Tooltips.
+	Tooltips *bool `yaml:"tooltips,omitempty" json:"tooltips,omitempty"`
+	// AlignTicks puts graph SVGs' x-axis labels on round times (defaults to false) — see
+	// Graph.AlignTicks.
+	AlignTicks *bool `yaml:"alignTicks,omitempty" json:"alignTicks,omitempty"`
 	// Theme selects the color theme (e.g. "dark", "grafana", "catppuccin-mocha", "dracula").
 	Theme string `yaml:"theme,omitempty" json:"theme,omitempty"`
+	// ThemeDark is the theme graph SVGs switch to when the viewer prefers a dark color
+	// scheme. Empty (the default) keeps theme in both.
+	ThemeDark string `yaml:"themeDark,omitempty" json:"themeDark,omitempty"`
 	// Font selects the text font: dejavu-sans (default), dejavu-sans-bold, comic-neue, or comic-neue-bold.
 	Font string `yaml:"font,omitempty" json:"font,omitempty"`
 	// ValueExpr is the default y-axis label formatter (a CEL expression over `result`)
@@ -99,6 +136,16 @@ type GraphDefaults struct {
 	YMax *float64 `yaml:"yMax,omitempty" json:"yMax,omitempty"`
 	// MarkLine is the default set of mark-line types for graphs — see Graph.MarkLine.
 	MarkLine []string `yaml:"markLine,omitempty" json:"markLine,omitempty"`
+	// MarkLineMatch is the default series selector for mark lines — see Graph.MarkLineMatch.
+	MarkLineMatch map[string]string `yaml:"markLineMatch,omitempty" json:"markLineMatch,omitempty"`
+	// Timezone is the default x-axis time zone for graphs — see Graph.Timezone.
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	// TimeFormats are the default x-axis label layouts for graphs — see Graph.TimeFormats.
+	TimeFormats TimeFormats `yaml:"timeFormats,omitempty" json:"timeFormats,omitempty"`
+	// Mode is the default graph mode — see Graph.Mode.
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Sparkline holds the default sparkline-mode settings for graphs.
+	Sparkline Sparkline `yaml:"sparkline,omitempty" json:"sparkline,omitempty"`
 	// Gallery is the default gallery visibility for graphs.
 	Gallery GallerySettings `yaml:"gallery,omitempty" json:"gallery,omitempty"`
 }
@@ -109,26 +156,56 @@ type Badge struct {
 	ID string `yaml:"id" json:"id"`
 	// Title is the display label (defaults to ID).
 	Title string `yaml:"title,omitempty" json:"title,omitempty"`
+	// TitleExpr is a CEL expression for the label, over the same variables as
+	// valueExpr, e.g. `labels.instance`. Empty, or an error or "" at runtime, keeps title.
+	TitleExpr string `yaml:"titleExpr,omitempty" json:"titleExpr,omitempty"`
 	// Query is the PromQL expression to run.
 	Query string `yaml:"query" json:"query"`
 	// Type selects how the value is computed: "instant" (default) or "range" (reduce a window).
 	Type string `yaml:"type,omitempty" json:"type,omitempty"`
 	// Range configures the windowed range query when Type is "range".
 	Range *RangeQuery `yaml:"range,omitempty" json:"range,omitempty"`
+	// Reduce collapses a matrix returned by an instant query (a range selector like
+	// up[5m] or a subquery) to one value per series: last (default), first, avg, min,
+	// max, sum. A type: range badge uses range.reduce instead.
+	Reduce string `yaml:"reduce,omitempty" json:"reduce,omitempty"`
+	// MaxAge marks the badge stale when its sample is older than this (e.g. "10m"):
+	// the badge then shows "stale" in grey instead of the value. Empty disables the check.
+	// It needs type: range, as an instant query's sample carries the evaluation time.
+	// The age is measured from the window's end, before range.offset.
+	MaxAge string `yaml:"maxAge,omitempty" json:"maxAge,omitempty"`
 	// ValueExpr is a CEL expression producing the displayed string. It receives `result`
-	// (the sample value, double) and `labels` (map). Defaults to string(result).
+	// (the sample value, double), `labels` (map), and `timestamp`/`now` (Unix seconds of
+	// the sample and of the query, doubles). Defaults to string(result).
 	ValueExpr string `yaml:"valueExpr,omitempty" json:"valueExpr,omitempty"`
 	// ColorExpr is a CEL expression producing the color name or hex. Empty means no color.
 	ColorExpr string `yaml:"colorExpr,omitempty" json:"colorExpr,omitempty"`
 	// LabelColor sets the left-segment (label) background color: a color name or hex.
-	// Unlike color it is a fixed value, not a CEL expression. Empty falls back to
-	// defaults.badge.labelColor, then grey (#555).
+	// Unlike color it is a fixed value (labelColorExpr computes one). Empty falls back
+	// to defaults.badge.labelColor, then grey (#555).
 	LabelColor string `yaml:"labelColor,omitempty" json:"labelColor,omitempty"`
+	// LabelColorExpr is a CEL expression for the label color (a name or hex), over the
+	// same variables as valueExpr. An error or "" at runtime keeps labelColor.
+	LabelColorExpr string `yaml:"labelColorExpr,omitempty" json:"labelColorExpr,omitempty"`
+	// LabelColorDark is the label color an SVG badge switches to when the viewer
+	// prefers a dark color scheme. Empty falls back to defaults.badge.labelColorDark,
+	// then keeps labelColor in both.
+	LabelColorDark string `yaml:"labelColorDark,omitempty" json:"labelColorDark,omitempty"`
+	// ColorExprDark is a CEL expression for the message color an SVG badge switches to
+	// under a dark color scheme, over the same variables as valueExpr. Empty, or an
+	// error or "" at runtime, keeps the message's color in both.
+	ColorExprDark string `yaml:"colorExprDark,omitempty" json:"colorExprDark,omitempty"`
 	// Style overrides defaults.badge.style for this badge.
 	Style string `yaml:"style,omitempty" json:"style,omitempty"`
 	// Icon renders an icon on the SVG badge, written as "<set>:<name>": a Material Design
 	// Icon (e.g. "mdi:server-outline") or a Simple Icons brand logo (e.g. "si:kubernetes").
 	Icon string `yaml:"icon,omitempty" json:"icon,omitempty"`
+	// OnError replaces the error badge shown when the query or an expression fails,
+	// field by field over defaults.badge.onError.
+	OnError BadgeFallback `yaml:"onError,omitempty" json:"onError,omitempty"`
+	// OnNoData replaces the "no data" badge shown when the query returns no samples,
+	// field by field over defaults.badge.onNoData.
+	OnNoData BadgeFallback `yaml:"onNoData,omitempty" json:"onNoData,omitempty"`
 	// Gallery holds this badge's gallery settings (e.g. hidden), overriding defaults.badge.gallery.
 	Gallery GallerySettings `yaml:"gallery,omitempty" json:"gallery,omitempty"`
 }
@@ -139,20 +216,46 @@ type Graph struct {
 	ID string `yaml:"id" json:"id"`
 	// Title is the display label (defaults to ID).
 	Title string `yaml:"title,omitempty" json:"title,omitempty"`
+	// TitleExpr is a CEL expression for the title, over the first series' latest finite
+	// sample: `result`, `labels`, and `timestamp`/`now`, e.g.
+	// `"CPU — " + string(int(result)) + "%"`. Empty, no sample, or an error or "" at
+	// runtime keeps title.
+	TitleExpr string `yaml:"titleExpr,omitempty" json:"titleExpr,omitempty"`
 	// Query is the PromQL expression to run as a range query.
-	Query string `yaml:"query" json:"query"`
+	Query string `yaml:"query,omitempty" json:"query,omitempty"`
+	// Queries replaces query with several named range queries plotted together (e.g.
+	// requests and errors), run concurrently. Set query or queries, not both.
+	Queries []GraphQuery `yaml:"queries,omitempty" json:"queries,omitempty"`
 	// MaxDuration overrides defaults.graph.maxDuration for this graph.
 	MaxDuration string `yaml:"maxDuration,omitempty" json:"maxDuration,omitempty"`
 	// Width overrides defaults.graph.width for this graph.
 	Width int `yaml:"width,omitempty" json:"width,omitempty"`
 	// Height overrides defaults.graph.height for this graph.
 	Height int `yaml:"height,omitempty" json:"height,omitempty"`
-	// Legend overrides defaults.graph.legend for this graph.
-	Legend *bool `yaml:"legend,omitempty" json:"legend,omitempty"`
+	// Legend overrides defaults.graph.legend for this graph: true shows the series
+	// legend, false hides it, and table draws a table beneath the chart instead, a row
+	// per series with its color and values.
+	Legend Legend `yaml:"legend,omitempty" json:"legend,omitempty"`
+	// LegendColumns are the values a legend table shows for each series, in order: any
+	// of last, min, max, and avg over the window. Defaults to defaults.graph.legendColumns,
+	// then all four.
+	LegendColumns []string `yaml:"legendColumns,omitempty" json:"legendColumns,omitempty"`
 	// Fill draws a translucent area beneath the line, overriding defaults.graph.fill.
 	Fill *bool `yaml:"fill,omitempty" json:"fill,omitempty"`
+	// Tooltips marks each point of a line or stacked-area SVG with a hover tooltip of
+	// its series, time, and value, overriding defaults.graph.tooltips. Off by default:
+	// it adds an element per point to the SVG.
+	Tooltips *bool `yaml:"tooltips,omitempty" json:"tooltips,omitempty"`
+	// AlignTicks places a line, stacked-area, or bar SVG's x-axis labels on round time
+	// boundaries (each hour, midnight, ...), overriding defaults.graph.alignTicks. Off
+	// by default: it lays the chart out twice to find where to draw them. A PNG keeps
+	// the library's labels.
+	AlignTicks *bool `yaml:"alignTicks,omitempty" json:"alignTicks,omitempty"`
 	// Theme overrides defaults.graph.theme for this graph.
 	Theme string `yaml:"theme,omitempty" json:"theme,omitempty"`
+	// ThemeDark overrides defaults.graph.themeDark for this graph: the theme its SVG
+	// switches to under a dark color scheme.
+	ThemeDark string `yaml:"themeDark,omitempty" json:"themeDark,omitempty"`
 	// Font overrides defaults.graph.font for this graph.
 	Font string `yaml:"font,omitempty" json:"font,omitempty"`
 	// ValueExpr is a CEL expression that formats the y-axis tick labels: it receives
@@ -162,19 +265,199 @@ type Graph struct {
 	// (an axis tick has no labels). Empty uses the chart's default numeric formatting.
 	// Overrides defaults.graph.valueExpr.
 	ValueExpr string `yaml:"valueExpr,omitempty" json:"valueExpr,omitempty"`
+	// LegendExpr is a CEL expression naming each series in the legend and the JSON
+	// output: it receives the series' `labels` and returns the name, e.g.
+	// `labels.instance`. Empty joins the label values with commas.
+	LegendExpr string `yaml:"legendExpr,omitempty" json:"legendExpr,omitempty"`
 	// YMin and YMax pin the y-axis range (e.g. 0 and 100 for a percentage) instead of
 	// auto-fitting to the data. Either may be set alone. Override defaults.graph.yMin/yMax.
 	YMin *float64 `yaml:"yMin,omitempty" json:"yMin,omitempty"`
 	YMax *float64 `yaml:"yMax,omitempty" json:"yMax,omitempty"`
 	// MarkLine draws dashed reference lines at computed values: any of "average", "min",
-	// "max", "median". The chart library renders them for the first series only, and
-	// only these dynamic types are supported (no static threshold). Overrides
+	// "max", "median". Each series gets its own, in its color (a stacked-area chart gets
+	// one set for the stacked total); use thresholds for lines at fixed values. Overrides
 	// defaults.graph.markLine.
 	MarkLine []string `yaml:"markLine,omitempty" json:"markLine,omitempty"`
+	// MarkLineMatch limits mark lines to the series whose labels equal every given value
+	// (e.g. {instance: node-1}). Empty draws them on every series. Overrides
+	// defaults.graph.markLineMatch.
+	MarkLineMatch map[string]string `yaml:"markLineMatch,omitempty" json:"markLineMatch,omitempty"`
+	// SeriesColors pins the colors (a shields.io name or hex) of the series a label
+	// matcher selects, e.g. {"instance=node-1": "#40a02b"}. A matcher is
+	// comma-separated label=value pairs; when several match a series, the one with the
+	// most pairs wins. Other series take a theme color chosen by their labels, so a
+	// series keeps its color however Prometheus orders the results.
+	SeriesColors map[string]string `yaml:"seriesColors,omitempty" json:"seriesColors,omitempty"`
+	// Chart selects the chart type: line (default), stacked-area, or bar over time, or
+	// horizontal-bar, pie, or donut of each series' latest value, one category per
+	// series (e.g. sum by (namespace) (...)), or heatmap of a histogram's bucket
+	// counters (classic _bucket series or a native histogram) per step.
+	Chart string `yaml:"chart,omitempty" json:"chart,omitempty"`
+	// NullMode draws the points a series is missing (no sample at a step, or a NaN/Inf
+	// one) as a "gap" (default), "connect"s the line straight across them, or draws
+	// them as "zero". Bar charts treat connect as gap.
+	NullMode string `yaml:"nullMode,omitempty" json:"nullMode,omitempty"`
+	// Downsample thins a window with more points than the chart is pixels wide to
+	// about one point per pixel: "lttb" (Largest-Triangle-Three-Buckets, keeping the
+	// line's shape) or "minmax" (each pixel's lowest and highest point). Empty draws
+	// every point. JSON is downsampled only on request (?downsample=<points>).
+	Downsample string `yaml:"downsample,omitempty" json:"downsample,omitempty"`
+	// Timezone is the IANA time zone (e.g. "Europe/Berlin") the x-axis times are shown
+	// in; ?tz= overrides it per request. Defaults to UTC. Overrides
+	// defaults.graph.timezone.
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	// TimeFormats sets the x-axis label layouts. Each field overrides its
+	// defaults.graph.timeFormats counterpart.
+	TimeFormats TimeFormats `yaml:"timeFormats,omitempty" json:"timeFormats,omitempty"`
+	// Mode is "chart" (default), the full chart, or "sparkline": a tiny, transparent
+	// line of each series with no title, axes, legend, or padding, sized by sparkline
+	// for embedding beside text. ?mode= overrides it per request; a heatmap is always
+	// a chart. Overrides defaults.graph.mode.
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Sparkline holds this graph's sparkline-mode settings, overriding
+	// defaults.graph.sparkline.
+	Sparkline Sparkline `yaml:"sparkline,omitempty" json:"sparkline,omitempty"`
+	// Thresholds draw dashed lines at fixed values (e.g. warn at 80, critical at 95),
+	// each optionally shading a band above or below it. They apply to SVG line,
+	// stacked-area, and bar charts (a PNG has none), and widen an unpinned y-axis to
+	// keep them in view.
+	Thresholds []Threshold `yaml:"thresholds,omitempty" json:"thresholds,omitempty"`
+	// Annotations mark events (deploys, reboots, alert firings) on SVG line,
+	// stacked-area, and bar charts as vertical lines, each from its own range query
+	// run over the graph's window. The JSON output lists them too.
+	Annotations []Annotation `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+	// Compare overlays the graph's queries run this far back (e.g. "7d") on SVG line,
+	// stacked-area, and bar charts and on sparklines: each series' past as a dashed,
+	// lighter line named for the period ("last week"). ?compare= overrides it per
+	// request; "0" turns it off. The JSON and CSV outputs include the shifted series
+	// too; the Prometheus output, like a query_range response, leaves them out.
+	Compare string `yaml:"compare,omitempty" json:"compare,omitempty"`
+	// Quantiles are the series plotted for a native-histogram query, each labelled
+	// quantile="<q>" (0 to 1). Defaults to [0.5, 0.9, 0.99]. Float series are unaffected.
+	Quantiles []float64 `yaml:"quantiles,omitempty" json:"quantiles,omitempty"`
+	// Series filters, ranks, and trims the query's series before they're drawn or
+	// returned as JSON, so the maxGraphSeries cap keeps the ones that matter.
+	Series *SeriesTransform `yaml:"series,omitempty" json:"series,omitempty"`
 	// Gallery holds this graph's gallery settings (e.g. hidden), overriding defaults.graph.gallery.
 	Gallery GallerySettings `yaml:"gallery,omitempty" json:"gallery,omitempty"`
 }
 
+// Sparkline is a graph's sparkline block. The same shape is used per graph and as
+// the default under defaults.graph.
+type Sparkline struct {
+	// Width and Height are the sparkline's size in pixels (defaults to 100×20); ?width=
+	// and ?height= override them in sparkline mode.
+	Width  int `yaml:"width,omitempty" json:"width,omitempty"`
+	Height int `yaml:"height,omitempty" json:"height,omitempty"`
+	// Last marks each series' latest point with a dot. Defaults to false.
+	Last *bool `yaml:"last,omitempty" json:"last,omitempty"`
+	// MinMax marks each series' lowest and highest points with dots. Defaults to false.
+	MinMax *bool `yaml:"minMax,omitempty" json:"minMax,omitempty"`
+}
+
+// TimeFormats are Go time layouts (e.g. "15:04", "Jan 2") for a graph's x-axis
+// labels. The labels sit on round minutes, hours, days, weeks (Mondays), months, or
+// years — whichever spacing fits the window and width — and each uses the layout of
+// the largest boundary it falls on, so midnight on an hourly axis shows the date.
+// Empty fields keep the defaults: 15:04 for minutes and hours, 01/02 for days and
+// weeks, Jan for months, and 2006 for years.
+type TimeFormats struct {
+	Minute string `yaml:"minute,omitempty" json:"minute,omitempty"`
+	Hour   string `yaml:"hour,omitempty" json:"hour,omitempty"`
+	Day    string `yaml:"day,omitempty" json:"day,omitempty"`
+	Week   string `yaml:"week,omitempty" json:"week,omitempty"`
+	Month  string `yaml:"month,omitempty" json:"month,omitempty"`
+	Year   string `yaml:"year,omitempty" json:"year,omitempty"`
+}
+
+// GraphQuery is one named query of a multi-query graph.
+type GraphQuery struct {
+	// Name labels the query's series in the legend and tags them in JSON. Required and
+	// unique within the graph.
+	Name string `yaml:"name" json:"name"`
+	// Query is the PromQL expression to run as a range query. Required.
+	Query string `yaml:"query" json:"query"`
+	// ValueExpr formats this query's series' values — mark lines, legend table, and
+	// tooltips — and the tick labels of the y-axis it is plotted against (the first
+	// query on an axis with one wins). Defaults to the graph's valueExpr.
+	ValueExpr string `yaml:"valueExpr,omitempty" json:"valueExpr,omitempty"`
+	// Axis plots the query against the "left" (default) or "right" y-axis, for a second
+	// unit such as fan RPM beside temperature. Only the line, stacked-area, and bar
+	// charts draw a right axis.
+	Axis string `yaml:"axis,omitempty" json:"axis,omitempty"`
+}
+
+// SeriesTransform is a graph's series pipeline, applied in field order: filter, sort,
+// limit, then other.
+type SeriesTransform struct {
+	// Filter is a CEL predicate keeping the series it's true for. It receives the
+	// series' `labels` and `result`, its value reduced by sort (default last); a series
+	// with no finite samples, or an evaluation error, is dropped.
+	Filter string `yaml:"filter,omitempty" json:"filter,omitempty"`
+	// Sort orders the series by a reducer over each one's samples: last, first, avg,
+	// min, max, or sum. Empty keeps the query's order.
+	Sort string `yaml:"sort,omitempty" json:"sort,omitempty"`
+	// Order is "desc" (default, largest first) or "asc".
+	Order string `yaml:"order,omitempty" json:"order,omitempty"`
+	// Limit keeps the first N series after sorting (the top-K); 0 keeps every one.
+	Limit int `yaml:"limit,omitempty" json:"limit,omitempty"`
+	// Other names a series summing, per timestamp, the ones Limit drops (e.g.
+	// "other"); a multi-query graph gets one per query. Empty drops them. Requires
+	// limit.
+	Other string `yaml:"other,omitempty" json:"other,omitempty"`
+}
+
+// Threshold is a static reference line on a graph.
+type Threshold struct {
+	// Value is where the line is drawn, in the query's units. Required.
+	Value *float64 `yaml:"value" json:"value"`
+	// Color is the line, label, and band color: a name or hex. Defaults to red.
+	Color string `yaml:"color,omitempty" json:"color,omitempty"`
+	// Label is text drawn just above the line at the left of the plot. Empty draws none.
+	Label string `yaml:"label,omitempty" json:"label,omitempty"`
+	// Band shades the area "above" or "below" the line, up to the next threshold in that
+	// direction or the edge of the plot. Empty draws the line only.
+	Band string `yaml:"band,omitempty" json:"band,omitempty"`
+}
+
+// Annotation is a range query whose samples mark events on a graph. Each run of
+// consecutive samples in a series is one event, marked where it starts, so
+// `changes(kube_deployment_status_observed_generation[1m]) > 0` marks each deploy
+// once however many steps it spans.
+type Annotation struct {
+	// Query is the PromQL range query; every sample it returns is part of an event. Required.
+	Query string `yaml:"query" json:"query"`
+	// TextExpr is a CEL expression for the marker text: it receives the series'
+	// `labels` and the event's first value as `result`, e.g.
+	// `"deploy " + labels.deployment`. Empty joins the label values with commas.
+	TextExpr string `yaml:"textExpr,omitempty" json:"textExpr,omitempty"`
+	// Color is the marker and text color: a name or hex. Defaults to gray.
+	Color string `yaml:"color,omitempty" json:"color,omitempty"`
+}
+
+// BadgeFallback is a badge's onError or onNoData block: what it shows when its query
+// fails or returns no samples. The same shape is used per badge and as the default
+// under defaults.badge.
+type BadgeFallback struct {
+	// Value is a stand-in result rendered through the badge's valueExpr and colorExpr
+	// as if Prometheus had returned it (e.g. 0 when an absent series means "none").
+	// When set, message, color, and status are unused.
+	Value *float64 `yaml:"value,omitempty" json:"value,omitempty"`
+	// Message is the displayed text. Defaults to "no data" for onNoData and to the
+	// failure reason (e.g. "Query Error") for onError.
+	Message string `yaml:"message,omitempty" json:"message,omitempty"`
+	// Color is the message color, a name or hex. Defaults to none for onNoData and to
+	// grey for onError.
+	Color string `yaml:"color,omitempty" json:"color,omitempty"`
+	// Status is the HTTP status for format=shields and format=json. Defaults to 200 for
+	// onNoData and 500 for onError. SVG badges are always served with 200 so an <img>
+	// renders them.
+	Status int `yaml:"status,omitempty" json:"status,omitempty"`
+	// HideReason shows "unavailable" instead of an internal failure reason such as
+	// "Query Error" when message is unset. onError only; defaults to false.
+	HideReason *bool `yaml:"hideReason,omitempty" json:"hideReason,omitempty"`
+}
+
 // RangeQuery configures a windowed range query (Badge.Type == "range"). The window
 // is end = now - offset, start = end - last; each series is reduced to one value.
 type RangeQuery struct {
@@ -205,6 +488,90 @@ const (
 	StylePlastic    = "plastic"
 )
 
+// Graph chart types.
+const (
+	ChartLine          = "line"
+	ChartStackedArea   = "stacked-area"
+	ChartBar           = "bar"
+	ChartHorizontalBar = "horizontal-bar"
+	ChartPie           = "pie"
+	ChartDonut         = "donut"
+	ChartHeatmap       = "heatmap"
+)
+
+// Graph null modes.
+const (
+	NullGap     = "gap"
+	NullConnect = "connect"
+	NullZero    = "zero"
+)
+
+// Graph query y-axes.
+const (
+	AxisLeft  = "left"
+	AxisRight = "right"
+)
+
+// Series sort orders.
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Threshold band directions.
+const (
+	BandAbove = "above"
+	BandBelow = "below"
+)
+
+// ValidChart is the set of supported graph chart types.
+var ValidChart = map[string]bool{
+	ChartLine: true, ChartStackedArea: true, ChartBar: true,
+	ChartHorizontalBar: true, ChartPie: true, ChartDonut: true, ChartHeatmap: true,
+}
+
+// Legend is a graph's legend setting, written as a YAML bool or "table".
+type Legend string
+
+// Graph legend settings.
+const (
+	LegendShow  Legend = "true"
+	LegendHide  Legend = "false"
+	LegendTable Legend = "table"
+)
+
+// ValidLegend is the set of supported graph legend settings.
+var ValidLegend = map[Legend]bool{LegendShow: true, LegendHide: true, LegendTable: true}
+
+// Legend table columns.
+const (
+	ColumnLast = "last"
+	ColumnMin  = "min"
+	ColumnMax  = "max"
+	ColumnAvg  = "avg"
+)
+
+// ValidLegendColumn is the set of supported legend table columns.
+var ValidLegendColumn = map[string]bool{ColumnLast: true, ColumnMin: true, ColumnMax: true, ColumnAvg: true}
+
+// Graph modes.
+const (
+	ModeChart     = "chart"
+	ModeSparkline = "sparkline"
+)
+
+// ValidMode is the set of supported graph modes.
+var ValidMode = map[string]bool{ModeChart: true, ModeSparkline: true}
+
+// Graph downsampling algorithms.
+const (
+	DownsampleLTTB   = "lttb"
+	DownsampleMinMax = "minmax"
+)
+
+// ValidNullMode is the set of supported graph null modes.
+var ValidNullMode = map[string]bool{NullGap: true, NullConnect: true, NullZero: true}
+
 // ValidReduce is the set of supported range-query reducers.
 var ValidReduce = map[string]bool{
 	ReduceLast: true, ReduceFirst: true, ReduceAvg: true,
@@ -272,15 +639,71 @@ func (c KromgoConfig) validate() error {
 			return fmt.Errorf("defaults.graph.maxDuration: %w", err)
 		}
 	}
+	if s := c.Defaults.Graph.Timezone; s != "" {
+		if _, err := time.LoadLocation(s); err != nil {
+			return fmt.Errorf("defaults.graph.timezone: %w", err)
+		}
+	}
+	if s := c.Defaults.Graph.Mode; s != "" && !ValidMode[s] {
+		return fmt.Errorf("defaults.graph.mode: unknown mode %q (want chart or sparkline)", s)
+	}
+	if err := validateLegend(c.Defaults.Graph.Legend, c.Defaults.Graph.LegendColumns); err != nil {
+		return fmt.Errorf("defaults.graph.%w", err)
+	}
 	if s := c.Defaults.Badge.Style; s != "" && !ValidStyle[s] {
 		return fmt.Errorf("defaults.badge.style: unknown style %q", s)
 	}
+	if err := c.Defaults.Badge.OnError.validate("onError"); err != nil {
+		return fmt.Errorf("defaults.badge.%w", err)
+	}
+	if err := c.Defaults.Badge.OnNoData.validate("onNoData"); err != nil {
+		return fmt.Errorf("defaults.badge.%w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Themes)) {
+		if err := c.Themes[name].validate(); err != nil {
+			return fmt.Errorf("theme %q: %w", name, err)
+		}
+	}
 	if err := validateEndpoints(c.Badges, "badge"); err != nil {
 		return err
 	}
 	return validateEndpoints(c.Graphs, "graph")
 }
 
+// validateLegend checks a legend setting and its table columns.
+func validateLegend(legend Legend, columns []string) error {
+	if legend != "" && !ValidLegend[legend] {
+		return fmt.Errorf("legend: unknown setting %q (want true, false, or table)", legend)
+	}
+	seen := make(map[string]bool, len(columns))
+	for i, c := range columns {
+		if !ValidLegendColumn[c] {
+			return fmt.Errorf("legendColumns[%d]: unknown column %q (want last, min, max, or avg)", i, c)
+		}
+		if seen[c] {
+			return fmt.Errorf("legendColumns[%d]: duplicate column %q", i, c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// validate checks that a theme sets every color. Whether each names a color is
+// checked with the renderer's palette, when the theme is built.
+func (t Theme) validate() error {
+	for _, f := range []struct{ field, color string }{
+		{"background", t.Background}, {"text", t.Text}, {"axis", t.Axis}, {"splitLine", t.SplitLine},
+	} {
+		if f.color == "" {
+			return fmt.Errorf("%s is required", f.field)
+		}
+	}
+	if len(t.Series) == 0 {
+		return fmt.Errorf("series: at least one color is required")
+	}
+	return nil
+}
+
 // endpoint is the shape validateEndpoints needs from a badge or graph.
 type endpoint interface {
 	id() string
@@ -318,7 +741,7 @@ func validateID(kind, id string) error {
 	return nil
 }
 
-// validate checks a badge's id, query, style, and range-query block.
+// validate checks a badge's id, query, style, maxAge, fallbacks, and range-query block.
 func (b Badge) validate() error {
 	if b.ID == "" || b.Query == "" {
 		return fmt.Errorf("badge %q: id and query are required", b.ID)
@@ -329,15 +752,36 @@ func (b Badge) validate() error {
 	if b.Style != "" && !ValidStyle[b.Style] {
 		return fmt.Errorf("badge %q: unknown style %q", b.ID, b.Style)
 	}
+	if b.MaxAge != "" {
+		if _, err := ParseDuration(b.MaxAge); err != nil {
+			return fmt.Errorf("badge %q maxAge: %w", b.ID, err)
+		}
+		if b.Type != TypeRange {
+			return fmt.Errorf("badge %q: maxAge needs type: range (an instant query is stamped with its evaluation time; "+
+				"use time() - timestamp(...) in the query instead)", b.ID)
+		}
+	}
+	if err := b.OnError.validate("onError"); err != nil {
+		return fmt.Errorf("badge %q %w", b.ID, err)
+	}
+	if err := b.OnNoData.validate("onNoData"); err != nil {
+		return fmt.Errorf("badge %q %w", b.ID, err)
+	}
 	switch b.Type {
 	case "", TypeInstant:
 		if b.Range != nil {
 			return fmt.Errorf("badge %q: range block is only valid with type: range", b.ID)
 		}
+		if b.Reduce != "" && !ValidReduce[b.Reduce] {
+			return fmt.Errorf("badge %q reduce: unknown reducer %q", b.ID, b.Reduce)
+		}
 	case TypeRange:
 		if b.Range == nil || b.Range.Last == "" {
 			return fmt.Errorf("badge %q: type range requires range.last", b.ID)
 		}
+		if b.Reduce != "" {
+			return fmt.Errorf("badge %q: reduce is for instant badges; use range.reduce with type: range", b.ID)
+		}
 		for name, val := range map[string]string{"last": b.Range.Last, "offset": b.Range.Offset, "step": b.Range.Step} {
 			if val == "" {
 				continue
@@ -355,18 +799,124 @@ func (b Badge) validate() error {
 	return nil
 }
 
-// validate checks a graph's id, query, and maxDuration.
+// validate checks an onError/onNoData block; field names it in errors.
+func (f BadgeFallback) validate(field string) error {
+	if f.Status != 0 && (f.Status < 200 || f.Status > 599) {
+		return fmt.Errorf("%s.status: %d is not an HTTP status (200-599)", field, f.Status)
+	}
+	if f.HideReason != nil && field != "onError" {
+		return fmt.Errorf("%s.hideReason: only valid in onError", field)
+	}
+	return nil
+}
+
+// validate checks a graph's id, queries, maxDuration, chart type, mode, null mode,
+// downsampling, timezone, thresholds, annotations, compare offset, seriesColors
+// matchers, quantiles, and series pipeline.
 func (g Graph) validate() error {
-	if g.ID == "" || g.Query == "" {
-		return fmt.Errorf("graph %q: id and query are required", g.ID)
+	if g.ID == "" || (g.Query == "" && len(g.Queries) == 0) {
+		return fmt.Errorf("graph %q: id and query (or queries) are required", g.ID)
 	}
 	if err := validateID("graph", g.ID); err != nil {
 		return err
 	}
+	if g.Query != "" && len(g.Queries) > 0 {
+		return fmt.Errorf("graph %q: set query or queries, not both", g.ID)
+	}
+	names := make(map[string]bool, len(g.Queries))
+	for i, q := range g.Queries {
+		if q.Name == "" || q.Query == "" {
+			return fmt.Errorf("graph %q queries[%d]: name and query are required", g.ID, i)
+		}
+		if names[q.Name] {
+			return fmt.Errorf("graph %q queries[%d]: duplicate name %q", g.ID, i, q.Name)
+		}
+		names[q.Name] = true
+		if q.Axis != "" && q.Axis != AxisLeft && q.Axis != AxisRight {
+			return fmt.Errorf("graph %q queries[%d]: unknown axis %q (want left or right)", g.ID, i, q.Axis)
+		}
+	}
 	if g.MaxDuration != "" {
 		if _, err := ParseDuration(g.MaxDuration); err != nil {
 			return fmt.Errorf("graph %q maxDuration: %w", g.ID, err)
 		}
 	}
+	if g.Chart != "" && !ValidChart[g.Chart] {
+		return fmt.Errorf("graph %q: unknown chart %q (want line, stacked-area, bar, horizontal-bar, pie, donut, or heatmap)", g.ID, g.Chart)
+	}
+	if g.Chart == ChartHeatmap && (len(g.Queries) > 0 || g.Series != nil) {
+		return fmt.Errorf("graph %q: a heatmap draws one histogram query, without queries or series", g.ID)
+	}
+	if g.Mode != "" && !ValidMode[g.Mode] {
+		return fmt.Errorf("graph %q: unknown mode %q (want chart or sparkline)", g.ID, g.Mode)
+	}
+	if err := validateLegend(g.Legend, g.LegendColumns); err != nil {
+		return fmt.Errorf("graph %q %w", g.ID, err)
+	}
+	if g.NullMode != "" && !ValidNullMode[g.NullMode] {
+		return fmt.Errorf("graph %q: unknown nullMode %q (want gap, connect, or zero)", g.ID, g.NullMode)
+	}
+	if g.Downsample != "" && g.Downsample != DownsampleLTTB && g.Downsample != DownsampleMinMax {
+		return fmt.Errorf("graph %q: unknown downsample %q (want lttb or minmax)", g.ID, g.Downsample)
+	}
+	if g.Timezone != "" {
+		if _, err := time.LoadLocation(g.Timezone); err != nil {
+			return fmt.Errorf("graph %q timezone: %w", g.ID, err)
+		}
+	}
+	for i, t := range g.Thresholds {
+		if t.Value == nil {
+			return fmt.Errorf("graph %q thresholds[%d]: value is required", g.ID, i)
+		}
+		if t.Band != "" && t.Band != BandAbove && t.Band != BandBelow {
+			return fmt.Errorf("graph %q thresholds[%d]: unknown band %q (want above or below)", g.ID, i, t.Band)
+		}
+	}
+	for i, a := range g.Annotations {
+		if a.Query == "" {
+			return fmt.Errorf("graph %q annotations[%d]: query is required", g.ID, i)
+		}
+	}
+	if g.Compare != "" {
+		d, err := ParseDuration(g.Compare)
+		if err != nil {
+			return fmt.Errorf("graph %q compare: %w", g.ID, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("graph %q compare: must be a positive duration", g.ID)
+		}
+	}
+	for _, m := range slices.Sorted(maps.Keys(g.SeriesColors)) {
+		if _, err := ParseLabelMatcher(m); err != nil {
+			return fmt.Errorf("graph %q seriesColors: %w", g.ID, err)
+		}
+	}
+	for _, q := range g.Quantiles {
+		if !(q >= 0 && q <= 1) {
+			return fmt.Errorf("graph %q quantiles: %v is outside 0 to 1", g.ID, q)
+		}
+	}
+	if g.Series != nil {
+		if err := g.Series.validate(); err != nil {
+			return fmt.Errorf("graph %q series: %w", g.ID, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a series pipeline's reducer, order, limit, and other bucket.
+func (s SeriesTransform) validate() error {
+	if s.Sort != "" && !ValidReduce[s.Sort] {
+		return fmt.Errorf("sort: unknown reducer %q", s.Sort)
+	}
+	if s.Order != "" && s.Order != OrderAsc && s.Order != OrderDesc {
+		return fmt.Errorf("order: unknown order %q (want asc or desc)", s.Order)
+	}
+	if s.Limit < 0 {
+		return fmt.Errorf("limit: %d is negative", s.Limit)
+	}
+	if s.Other != "" && s.Limit == 0 {
+		return errors.New("other requires limit")
+	}
 	return nil
 }
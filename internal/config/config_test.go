@@ -88,6 +88,18 @@ func TestLoad_InvalidDuration(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestLoad_InvalidMaxAge(t *testing.T) {
+	t.Parallel()
+	_, err := Load(writeConfig(t, "badges:\n  - id: cpu\n    type: range\n    query: q\n    maxAge: soon\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "maxAge")
+
+	// An instant query's sample carries the evaluation time, so it is never stale.
+	_, err = Load(writeConfig(t, "badges:\n  - id: cpu\n    query: q\n    maxAge: 10m\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "type: range")
+}
+
 func TestLoad_DuplicateID(t *testing.T) {
 	t.Parallel()
 	_, err := Load(writeConfig(t, "badges:\n  - id: cpu\n    query: q\n  - id: cpu\n    query: q\n"))
@@ -101,6 +113,254 @@ func TestLoad_RangeBadgeRequiresLast(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestLoad_BadgeReduce(t *testing.T) {
+	t.Parallel()
+	_, err := Load(writeConfig(t, "badges:\n  - id: cpu\n    query: up[5m]\n    reduce: max\n"))
+	require.NoError(t, err)
+
+	_, err = Load(writeConfig(t, "badges:\n  - id: cpu\n    query: up[5m]\n    reduce: median\n"))
+	require.Error(t, err, "unknown reducer")
+
+	_, err = Load(writeConfig(t, "badges:\n  - id: cpu\n    query: q\n    type: range\n    reduce: max\n    range:\n      last: 1h\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "range.reduce")
+}
+
+func TestLoad_BadgeFallbacks(t *testing.T) {
+	t.Parallel()
+	_, err := Load(writeConfig(t, "defaults:\n  badge:\n    onError:\n      hideReason: true\nbadges:\n  - id: cpu\n    query: q\n    onNoData:\n      value: 0\n      status: 404\n"))
+	require.NoError(t, err)
+
+	cases := map[string]string{
+		"status out of range":         "badges:\n  - id: cpu\n    query: q\n    onError:\n      status: 42\n",
+		"hideReason in onNoData":      "badges:\n  - id: cpu\n    query: q\n    onNoData:\n      hideReason: true\n",
+		"default status out of range": "defaults:\n  badge:\n    onNoData:\n      status: 700\n",
+	}
+	for name, yml := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Load(writeConfig(t, yml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_GraphChart(t *testing.T) {
+	t.Parallel()
+	_, err := Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    chart: donut\n"))
+	require.NoError(t, err)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    chart: radar\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown chart")
+}
+
+func TestLoad_GraphNullMode(t *testing.T) {
+	t.Parallel()
+	_, err := Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    nullMode: connect\n"))
+	require.NoError(t, err)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    nullMode: skip\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown nullMode")
+}
+
+func TestLoad_GraphHeatmap(t *testing.T) {
+	t.Parallel()
+	_, err := Load(writeConfig(t, "graphs:\n  - id: h\n    query: q\n    chart: heatmap\n"))
+	require.NoError(t, err)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: h\n    chart: heatmap\n    queries:\n      - {name: a, query: q}\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a heatmap draws one histogram query")
+}
+
+func TestLoad_GraphDownsample(t *testing.T) {
+	t.Parallel()
+	_, err := Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    downsample: minmax\n"))
+	require.NoError(t, err)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    downsample: average\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown downsample")
+}
+
+func TestLoad_GraphTimezone(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "defaults:\n  graph:\n    timezone: Europe/Berlin\n    timeFormats:\n      day: Jan 2\ngraphs:\n  - id: ns\n    query: q\n    timezone: America/New_York\n    timeFormats:\n      hour: 3pm\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "America/New_York", cfg.Graphs[0].Timezone)
+	assert.Equal(t, TimeFormats{Hour: "3pm"}, cfg.Graphs[0].TimeFormats)
+	assert.Equal(t, "Jan 2", cfg.Defaults.Graph.TimeFormats.Day)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    timezone: Mars/Olympus\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `graph "ns" timezone`)
+
+	_, err = Load(writeConfig(t, "defaults:\n  graph:\n    timezone: nowhere\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "defaults.graph.timezone")
+}
+
+func TestLoad_GraphQuantiles(t *testing.T) {
+	t.Parallel()
+	_, err := Load(writeConfig(t, "graphs:\n  - id: lat\n    query: h\n    quantiles: [0, 0.5, 1]\n"))
+	require.NoError(t, err)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: lat\n    query: h\n    quantiles: [99]\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "quantiles")
+}
+
+func TestLoad_GraphThresholds(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    thresholds:\n      - value: 80\n        color: orange\n        label: warn\n      - value: 95\n        band: above\n"))
+	require.NoError(t, err)
+	require.Len(t, cfg.Graphs[0].Thresholds, 2)
+	assert.InDelta(t, 95, *cfg.Graphs[0].Thresholds[1].Value, 0)
+
+	for _, tc := range []struct{ yaml, want string }{
+		{"      - label: warn\n", "value is required"},
+		{"      - value: 1\n        band: sideways\n", "unknown band"},
+	} {
+		_, err := Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    thresholds:\n"+tc.yaml))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+}
+
+func TestLoad_GraphMode(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "defaults:\n  graph:\n    mode: sparkline\n    sparkline:\n      width: 120\ngraphs:\n  - id: cpu\n    query: q\n    mode: chart\n    sparkline:\n      minMax: true\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ModeChart, cfg.Graphs[0].Mode)
+	require.NotNil(t, cfg.Graphs[0].Sparkline.MinMax)
+	assert.True(t, *cfg.Graphs[0].Sparkline.MinMax)
+	assert.Equal(t, 120, cfg.Defaults.Graph.Sparkline.Width)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    mode: tiny\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown mode "tiny"`)
+
+	_, err = Load(writeConfig(t, "defaults:\n  graph:\n    mode: tiny\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "defaults.graph.mode")
+}
+
+func TestLoad_GraphAnnotations(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    annotations:\n      - query: changes(gen[1m]) > 0\n        textExpr: labels.deployment\n        color: blue\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Annotation{{Query: "changes(gen[1m]) > 0", TextExpr: "labels.deployment", Color: "blue"}}, cfg.Graphs[0].Annotations)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    annotations:\n      - color: blue\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `graph "cpu" annotations[0]: query is required`)
+}
+
+func TestLoad_GraphCompare(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    compare: 7d\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "7d", cfg.Graphs[0].Compare)
+
+	for _, tc := range []struct{ compare, want string }{
+		{"soon", `graph "cpu" compare: invalid duration`},
+		{"0", `graph "cpu" compare: must be a positive duration`},
+	} {
+		_, err := Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    compare: "+tc.compare+"\n"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+}
+
+func TestLoad_GraphLegend(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "defaults:\n  graph:\n    legend: false\ngraphs:\n  - id: cpu\n    query: q\n    legend: table\n    legendColumns: [last, avg]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, LegendHide, cfg.Defaults.Graph.Legend)
+	assert.Equal(t, LegendTable, cfg.Graphs[0].Legend)
+	assert.Equal(t, []string{ColumnLast, ColumnAvg}, cfg.Graphs[0].LegendColumns)
+
+	for _, tc := range []struct{ yaml, want string }{
+		{"    legend: list\n", `graph "cpu" legend: unknown setting "list"`},
+		{"    legendColumns: [last, p99]\n", `graph "cpu" legendColumns[1]: unknown column "p99"`},
+		{"    legendColumns: [max, max]\n", `graph "cpu" legendColumns[1]: duplicate column "max"`},
+	} {
+		_, err := Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n"+tc.yaml))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+	_, err = Load(writeConfig(t, "defaults:\n  graph:\n    legend: tabel\n"))
+	assert.ErrorContains(t, err, `defaults.graph.legend: unknown setting "tabel"`)
+}
+
+func TestLoad_Themes(t *testing.T) {
+	t.Parallel()
+	const theme = "themes:\n  paper:\n    background: \"#fdf6e3\"\n    text: \"#586e75\"\n    axis: \"#93a1a1\"\n    splitLine: \"#eee8d5\"\n"
+	cfg, err := Load(writeConfig(t, theme+"    series: [blue, \"#dc322f\"]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Theme{Background: "#fdf6e3", Text: "#586e75", Axis: "#93a1a1", SplitLine: "#eee8d5", Series: []string{"blue", "#dc322f"}}, cfg.Themes["paper"])
+
+	for _, tc := range []struct{ yaml, want string }{
+		{theme, `theme "paper": series: at least one color is required`},
+		{"themes:\n  paper:\n    background: white\n    series: [blue]\n", `theme "paper": text is required`},
+	} {
+		_, err := Load(writeConfig(t, tc.yaml))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+}
+
+func TestLoad_GraphQueries(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "graphs:\n  - id: api\n    queries:\n      - name: requests\n        query: rate(a[5m])\n      - name: errors\n        query: rate(b[5m])\n        axis: right\n"))
+	require.NoError(t, err)
+	require.Len(t, cfg.Graphs[0].Queries, 2)
+	assert.Equal(t, AxisRight, cfg.Graphs[0].Queries[1].Axis)
+
+	for _, tc := range []struct{ yaml, want string }{
+		{"    query: q\n    queries:\n      - name: a\n        query: q\n", "not both"},
+		{"    queries:\n      - name: a\n", "name and query are required"},
+		{"    queries:\n      - name: a\n        query: q\n      - name: a\n        query: r\n", "duplicate name"},
+		{"    queries:\n      - name: a\n        query: q\n        axis: top\n", "unknown axis"},
+	} {
+		_, err := Load(writeConfig(t, "graphs:\n  - id: api\n"+tc.yaml))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+}
+
+func TestLoad_GraphSeriesColors(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    seriesColors:\n      instance=node-1: green\n      'job=\"api\", code=500': '#ff0000'\n"))
+	require.NoError(t, err)
+	assert.Len(t, cfg.Graphs[0].SeriesColors, 2)
+
+	_, err = Load(writeConfig(t, "graphs:\n  - id: cpu\n    query: q\n    seriesColors:\n      node-1: green\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "seriesColors")
+}
+
+func TestLoad_GraphSeries(t *testing.T) {
+	t.Parallel()
+	cfg, err := Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    series:\n      filter: result > 0.0\n      sort: max\n      limit: 5\n      other: other\n"))
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Graphs[0].Series)
+	assert.Equal(t, 5, cfg.Graphs[0].Series.Limit)
+
+	for _, tc := range []struct{ yaml, want string }{
+		{"      sort: p99\n", "unknown reducer"},
+		{"      order: up\n", "unknown order"},
+		{"      limit: -1\n", "negative"},
+		{"      other: other\n", "requires limit"},
+	} {
+		_, err := Load(writeConfig(t, "graphs:\n  - id: ns\n    query: q\n    series:\n"+tc.yaml))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+}
+
 func TestLoad_InvalidID(t *testing.T) {
 	t.Parallel()
 	// ids are URL path segments and gallery Markdown; reject unsafe characters.
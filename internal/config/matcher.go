@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseLabelMatcher parses a series selector of comma-separated label=value pairs,
+// e.g. `instance=node-1` or `job="api", code="500"`. A value may be double-quoted;
+// a series matches when every label equals its value.
+func ParseLabelMatcher(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for pair := range strings.SplitSeq(s, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("matcher %q: want label=value", s)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if _, dup := out[name]; dup {
+			return nil, fmt.Errorf("matcher %q: label %q repeated", s, name)
+		}
+		out[name] = value
+	}
+	return out, nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabelMatcher(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"bare", "instance=node-1", map[string]string{"instance": "node-1"}, false},
+		{"quoted", `job="api"`, map[string]string{"job": "api"}, false},
+		{"several", `job="api", code=500`, map[string]string{"job": "api", "code": "500"}, false},
+		{"empty value", "pod=", map[string]string{"pod": ""}, false},
+		{"no equals", "instance", nil, true},
+		{"no name", "=x", nil, true},
+		{"repeated", "a=1,a=2", nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseLabelMatcher(tc.in)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
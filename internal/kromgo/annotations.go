@@ -0,0 +1,194 @@
+package kromgo
+
+import (
+	"cmp"
+	"html"
+	"math"
+	"slices"
+	"time"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/google/cel-go/cel"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// Annotations mark events from a graph's second set of range queries: each run of
+// consecutive samples in a returned series is one event. kromgo draws them over the
+// finished chart as dashed vertical lines at the events' starts, labelled at the top
+// of the plot, and lists them in the JSON output.
+
+const (
+	// defaultAnnotationColor is used when an annotation sets no color.
+	defaultAnnotationColor = "gray"
+	annotationFontSize     = 10
+	// annotationLabelSpacing is the least room, in pixels, between two markers' labels;
+	// a label that would crowd the one before it is dropped (its line still draws).
+	annotationLabelSpacing = 4
+)
+
+// annotation is a config.Annotation with its text expression and color resolved once
+// at startup.
+type annotation struct {
+	query string
+	text  cel.Program // nil joins the series' label values
+	color charts.Color
+}
+
+// resolveAnnotations compiles a graph's annotations.
+func resolveAnnotations(as []config.Annotation, id string, env *cel.Env) ([]annotation, error) {
+	out := make([]annotation, 0, len(as))
+	for _, a := range as {
+		ra := annotation{
+			query: a.Query,
+			color: charts.ColorFromHex(colorNameToHex(cmp.Or(a.Color, defaultAnnotationColor))),
+		}
+		if a.TextExpr != "" {
+			prog, err := compileStringExpr(env, id, "annotation text", a.TextExpr)
+			if err != nil {
+				return nil, err
+			}
+			ra.text = prog
+		}
+		out = append(out, ra)
+	}
+	return out, nil
+}
+
+// annotationEvent is one event: a run of consecutive samples in an annotation
+// query's series.
+type annotationEvent struct {
+	start, end model.Time
+	text       string
+	labels     map[string]string
+	color      charts.Color
+}
+
+// HistoryAnnotation is one annotation event in a graph's JSON.
+type HistoryAnnotation struct {
+	T      int64             `json:"t"`   // the event's first sample
+	End    int64             `json:"end"` // its last sample; T for a single-sample event
+	Text   string            `json:"text"`
+	Labels map[string]string `json:"labels"`
+}
+
+// events splits an annotation query's matrix into events. Samples more than one and
+// a half steps apart belong to different events; with no step, each sample is its own.
+func (a annotation) events(matrix model.Matrix, step time.Duration) []annotationEvent {
+	maxGap := model.Duration(step * 3 / 2)
+	var out []annotationEvent
+	for _, stream := range matrix {
+		labels := seriesLabels(stream.Metric)
+		var ev *annotationEvent
+		for _, pt := range stream.Values {
+			if f := float64(pt.Value); math.IsNaN(f) || math.IsInf(f, 0) {
+				continue
+			}
+			if ev != nil && model.Duration(pt.Timestamp.Sub(ev.end)) <= maxGap {
+				ev.end = pt.Timestamp
+				continue
+			}
+			out = append(out, annotationEvent{
+				start:  pt.Timestamp,
+				end:    pt.Timestamp,
+				text:   a.eventText(stream.Metric, labels, pt),
+				labels: labels,
+				color:  a.color,
+			})
+			ev = &out[len(out)-1]
+		}
+	}
+	return out
+}
+
+// eventText evaluates the annotation's text expression for an event's first sample,
+// falling back to the series' label values when there is none or it fails.
+func (a annotation) eventText(metric model.Metric, labels map[string]string, first model.SamplePair) string {
+	if a.text != nil {
+		s, err := evalStringExpr(a.text, exprVars{
+			result:    float64(first.Value),
+			labels:    labels,
+			timestamp: first.Timestamp.Time(),
+			now:       time.Now(),
+		})
+		if err == nil {
+			return s
+		}
+	}
+	return chartParams{}.seriesLabel(metric)
+}
+
+// sortEvents orders events by start time, keeping the annotations' order for ties.
+func sortEvents(events []annotationEvent) {
+	slices.SortStableFunc(events, func(a, b annotationEvent) int { return cmp.Compare(a.start, b.start) })
+}
+
+// annotationsResponse converts events to their JSON form, or nil when there are none.
+func annotationsResponse(events []annotationEvent) []HistoryAnnotation {
+	if len(events) == 0 {
+		return nil
+	}
+	out := make([]HistoryAnnotation, len(events))
+	for i, e := range events {
+		out[i] = HistoryAnnotation{
+			T:      int64(e.start) / 1000,
+			End:    int64(e.end) / 1000,
+			Text:   e.text,
+			Labels: e.labels,
+		}
+	}
+	return out
+}
+
+// annotationMarker is an event placed on the chart: at is its fraction of the plot's
+// width, like a timeTick.
+type annotationMarker struct {
+	at    float64
+	text  string
+	color charts.Color
+}
+
+// annotationMarkers places the params' events that start within the grid.
+func (p chartParams) annotationMarkers(grid []model.Time) []annotationMarker {
+	if len(grid) < 2 {
+		return nil
+	}
+	var out []annotationMarker
+	for _, e := range p.annotations {
+		if e.start < grid[0] || e.start > grid[len(grid)-1] {
+			continue
+		}
+		out = append(out, annotationMarker{at: p.plotFraction(grid, e.start.Time()), text: e.text, color: e.color})
+	}
+	return out
+}
+
+// drawAnnotations paints the params' markers onto a rendered chart: a dashed line
+// from the top of the plot to the x-axis, and the event's text beside its top.
+func drawAnnotations(painter *charts.Painter, plot plotArea, p chartParams) {
+	prevEnd := math.MinInt
+	for _, m := range p.markers {
+		x := plot.left + int(math.Round(m.at*float64(plot.right-plot.left)))
+		painter.DashedLineStroke([]charts.Point{{X: x, Y: plot.axisTop}, {X: x, Y: plot.axis}},
+			m.color, 1, []float64{4, 3})
+		if m.text == "" {
+			continue
+		}
+		style := charts.FontStyle{Font: p.font, FontSize: annotationFontSize, FontColor: m.color}
+		box := painter.MeasureText(m.text, 0, style)
+		// Beside the line, or before it where the label would run off the plot.
+		left := x + 3
+		if left+box.Width() > plot.right {
+			left = x - 3 - box.Width()
+		}
+		if left < prevEnd+annotationLabelSpacing {
+			continue
+		}
+		text := m.text
+		if p.format != formatPNG {
+			text = html.EscapeString(text) // the library writes SVG text unescaped
+		}
+		painter.Text(text, left, plot.axisTop+box.Height(), 0, style)
+		prevEnd = left + box.Width()
+	}
+}
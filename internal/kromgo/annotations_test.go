@@ -0,0 +1,144 @@
+package kromgo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// annotationStream is a series with a sample of value 1 at each of the given minutes
+// past start.
+func annotationStream(metric model.Metric, start time.Time, minutes ...int) *model.SampleStream {
+	s := &model.SampleStream{Metric: metric}
+	for _, m := range minutes {
+		s.Values = append(s.Values, model.SamplePair{
+			Timestamp: model.TimeFromUnixNano(start.Add(time.Duration(m) * time.Minute).UnixNano()),
+			Value:     1,
+		})
+	}
+	return s
+}
+
+func TestResolveAnnotations(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+
+	as, err := resolveAnnotations([]config.Annotation{{Query: "a"}, {Query: "b", TextExpr: "labels.app", Color: "blue"}}, "g", env)
+	require.NoError(t, err)
+	require.Len(t, as, 2)
+	assert.Nil(t, as[0].text)
+	assert.Equal(t, charts.ColorFromHex(colorNameToHex(defaultAnnotationColor)), as[0].color)
+	assert.NotNil(t, as[1].text)
+	assert.Equal(t, charts.ColorFromHex(colorNameToHex("blue")), as[1].color)
+
+	_, err = resolveAnnotations([]config.Annotation{{Query: "a", TextExpr: "result"}}, "g", env)
+	require.Error(t, err, "the text must be a string")
+}
+
+func TestAnnotationEvents(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+	as, err := resolveAnnotations([]config.Annotation{{Query: "q", TextExpr: `"deploy " + labels.app`}, {Query: "q"}}, "g", env)
+	require.NoError(t, err)
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	matrix := model.Matrix{
+		annotationStream(model.Metric{"__name__": "changes", "app": "api"}, start, 1, 2, 3, 10),
+		annotationStream(model.Metric{"app": "web", "env": "prod"}, start, 5),
+	}
+
+	events := as[0].events(matrix, time.Minute)
+	require.Len(t, events, 3, "a gap of more than a step and a half starts a new event")
+	assert.Equal(t, model.TimeFromUnixNano(start.Add(time.Minute).UnixNano()), events[0].start)
+	assert.Equal(t, model.TimeFromUnixNano(start.Add(3*time.Minute).UnixNano()), events[0].end)
+	assert.Equal(t, "deploy api", events[0].text)
+	assert.Equal(t, map[string]string{"__name__": "changes", "app": "api"}, events[0].labels)
+	assert.Equal(t, events[1].start, events[1].end, "a single sample")
+	assert.Equal(t, "deploy web", events[2].text)
+
+	assert.Len(t, as[0].events(matrix, 0), 5, "with no step every sample is an event")
+
+	events = as[1].events(matrix, time.Minute)
+	assert.Equal(t, "api", events[0].text, "no textExpr joins the label values")
+	assert.Equal(t, "web, prod", events[2].text)
+
+	sortEvents(events)
+	assert.Equal(t, []string{"api", "web, prod", "api"}, []string{events[0].text, events[1].text, events[2].text})
+	resp := annotationsResponse(events)
+	require.Len(t, resp, 3)
+	assert.Equal(t, HistoryAnnotation{
+		T:      start.Add(time.Minute).Unix(),
+		End:    start.Add(3 * time.Minute).Unix(),
+		Text:   "api",
+		Labels: map[string]string{"__name__": "changes", "app": "api"},
+	}, resp[0])
+	assert.Nil(t, annotationsResponse(nil))
+}
+
+func TestAnnotationMarkers(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	grid := stepGrid(start, time.Minute, 11)
+	at := func(m int) model.Time {
+		return model.TimeFromUnixNano(start.Add(time.Duration(m) * time.Minute).UnixNano())
+	}
+	p := chartParams{annotations: []annotationEvent{
+		{start: at(-1), text: "before"},
+		{start: at(5), text: "mid"},
+		{start: at(10), text: "end"},
+		{start: at(11), text: "after"},
+	}}
+
+	markers := p.annotationMarkers(grid)
+	require.Len(t, markers, 2, "events outside the window are left off")
+	assert.InDelta(t, 0.5, markers[0].at, 1e-9)
+	assert.Equal(t, "mid", markers[0].text)
+	assert.InDelta(t, 1, markers[1].at, 1e-9)
+
+	p.chart = config.ChartBar
+	markers = p.annotationMarkers(grid)
+	assert.InDelta(t, 5.5/11, markers[0].at, 1e-9, "bars are centered in their slots")
+
+	assert.Nil(t, p.annotationMarkers(grid[:1]))
+}
+
+func TestRenderChart_Annotations(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 10, 18, 9, 50, 0, 0, time.UTC)
+	matrix := model.Matrix{{Metric: model.Metric{"job": "api"}}}
+	for i := range 61 {
+		matrix[0].Values = append(matrix[0].Values, model.SamplePair{
+			Timestamp: model.TimeFromUnixNano(start.Add(time.Duration(i) * time.Minute).UnixNano()),
+			Value:     model.SampleValue(i % 7),
+		})
+	}
+	red := charts.ColorFromHex(colorNameToHex("red"))
+	p := chartParams{
+		width: 600, height: 200, format: formatSVG,
+		start: start, end: start.Add(time.Hour), step: time.Minute,
+		annotations: []annotationEvent{{
+			start: model.TimeFromUnixNano(start.Add(20 * time.Minute).UnixNano()),
+			text:  "deploy <api>",
+			color: red,
+		}},
+	}
+	for _, chart := range []string{config.ChartLine, config.ChartBar} {
+		p.chart = chart
+		svg, err := renderChart(matrix, p)
+		require.NoError(t, err)
+		assert.Contains(t, string(svg), ">deploy &lt;api&gt;</text>", "%s: the text is escaped", chart)
+		assert.Contains(t, string(svg), fmt.Sprintf("stroke:rgb(%d,%d,%d)", red.R, red.G, red.B), "%s: the marker line", chart)
+	}
+
+	p.chart = config.ChartPie
+	svg, err := renderChart(matrix, p)
+	require.NoError(t, err)
+	assert.NotContains(t, string(svg), "deploy", "a pie has no time axis")
+}
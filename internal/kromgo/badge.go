@@ -105,16 +105,19 @@ func (b *badgeRenderer) glyphPath(s string, originX, baseline float64) string {
 
 // badgeSpec is the fully-resolved input to render: the style, an optional left icon
 // (24x24 SVG path data), the left label and right message text, their background
-// colors (name or hex; labelColor "" = the default grey), and the badge id, which
-// namespaces the SVG's element ids so inlined badges don't collide.
+// colors (name or hex; labelColor "" = the default grey), their dark-mode colors
+// ("" = unchanged), and the badge id, which namespaces the SVG's element ids and
+// classes so inlined badges don't collide.
 type badgeSpec struct {
-	style      string
-	iconPath   string
-	label      string
-	message    string
-	color      string
-	labelColor string
-	id         string
+	style          string
+	iconPath       string
+	label          string
+	message        string
+	color          string
+	colorDark      string
+	labelColor     string
+	labelColorDark string
+	id             string
 }
 
 // render produces an SVG badge from a fully-resolved spec.
@@ -170,6 +173,19 @@ func (b *badgeRenderer) render(spec badgeSpec) []byte {
 	// url(#…) refs resolve to the first's gradient/clip.
 	gradID := "g-" + xmlIDSafe(spec.id)
 	clipID := "r-" + xmlIDSafe(spec.id)
+	// A dark color repaints its segment — the background, text, and an icon sharing
+	// it — when the viewer prefers a dark color scheme. The classes are namespaced
+	// like the ids, as a stylesheet applies document-wide too.
+	var dark strings.Builder
+	darkClass, msgClass := "", ""
+	if spec.labelColorDark != "" && labelSeg > 0 {
+		darkClass = "d-" + xmlIDSafe(spec.id)
+		writeDarkRules(&dark, darkClass, spec.labelColorDark)
+	}
+	if spec.colorDark != "" {
+		msgClass = "dm-" + xmlIDSafe(spec.id)
+		writeDarkRules(&dark, msgClass, colorNameToHex(spec.colorDark))
+	}
 
 	var s strings.Builder
 	// role="img" + aria-label make the badge a single labelled image for assistive
@@ -177,6 +193,9 @@ func (b *badgeRenderer) render(spec badgeSpec) []byte {
 	// hover tooltip.
 	fmt.Fprintf(&s, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s">`, total, h, total, h, alt)
 	fmt.Fprintf(&s, `<title>%s</title>`, alt)
+	if dark.Len() > 0 {
+		fmt.Fprintf(&s, `<style>@media (prefers-color-scheme: dark){%s}</style>`, dark.String())
+	}
 	if gradStops != "" {
 		fmt.Fprintf(&s, `<linearGradient id="%s" x2="0" y2="100%%">%s</linearGradient>`, gradID, gradStops)
 	}
@@ -185,9 +204,9 @@ func (b *badgeRenderer) render(spec badgeSpec) []byte {
 	// element — and any stray glyph ink can never escape the badge bounds.
 	fmt.Fprintf(&s, `<g clip-path="url(#%s)">`, clipID)
 	if labelSeg > 0 {
-		fmt.Fprintf(&s, `<rect width="%d" height="%d" fill="%s"/>`, labelSeg, h, labelHex)
+		fmt.Fprintf(&s, `<rect width="%d" height="%d" fill="%s"%s/>`, labelSeg, h, labelHex, classAttr(darkClass, ""))
 	}
-	fmt.Fprintf(&s, `<rect x="%d" width="%d" height="%d" fill="%s"/>`, labelSeg, msgSeg, h, msgHex)
+	fmt.Fprintf(&s, `<rect x="%d" width="%d" height="%d" fill="%s"%s/>`, labelSeg, msgSeg, h, msgHex, classAttr(msgClass, ""))
 	if gradStops != "" {
 		fmt.Fprintf(&s, `<rect width="%d" height="%d" fill="url(#%s)"/>`, total, h, gradID)
 	}
@@ -195,14 +214,14 @@ func (b *badgeRenderer) render(spec badgeSpec) []byte {
 		// iconPath is static, trusted registry data (not user input). It takes a fill
 		// legible on whichever segment it sits on: the label segment, or the message
 		// segment when there's no label.
-		iconBg := labelHex
+		iconBg, iconClass := labelHex, classAttr(darkClass, "-t")
 		if iconOnMessage {
-			iconBg = msgHex
+			iconBg, iconClass = msgHex, classAttr(msgClass, "-t")
 		}
 		iconColor, _ := colorsForBackground(iconBg)
 		scale := float64(iconSize) / 24.0
-		fmt.Fprintf(&s, `<g transform="translate(%d %d) scale(%.4f)"><path fill="%s" d="%s"/></g>`,
-			iconX, (h-iconSize)/2, scale, iconColor, spec.iconPath)
+		fmt.Fprintf(&s, `<g transform="translate(%d %d) scale(%.4f)"><path fill="%s"%s d="%s"/></g>`,
+			iconX, (h-iconSize)/2, scale, iconColor, iconClass, spec.iconPath)
 	}
 
 	// Text as vector paths from the font: exact widths, no system-font/textLength
@@ -210,20 +229,23 @@ func (b *badgeRenderer) render(spec badgeSpec) []byte {
 	// segment's text + drop shadow take a color legible on that segment's background.
 	bl := float64(baseline)
 	if hasLabel {
-		b.writeText(&s, spec.label, float64(labelLeft), bl, labelHex)
+		b.writeText(&s, spec.label, float64(labelLeft), bl, labelHex, darkClass)
 	}
-	b.writeText(&s, spec.message, float64(msgLeft), bl, msgHex)
+	b.writeText(&s, spec.message, float64(msgLeft), bl, msgHex, msgClass)
 	s.WriteString(`</g></svg>`)
 	return []byte(s.String())
 }
 
 // renderError draws a self-describing error badge — the id as label and a short
-// reason as message — colored red for client errors (4xx) and grey for server or
-// upstream errors (5xx), so an <img> shows the failure instead of a broken image.
-func (b *badgeRenderer) renderError(id, reason string, code int) []byte {
-	color := "lightgrey"
-	if code < 500 {
-		color = "red"
+// reason as message — so an <img> shows the failure instead of a broken image. An
+// empty color means red for client errors (4xx) and grey for server or upstream
+// errors (5xx).
+func (b *badgeRenderer) renderError(id, reason, color string, code int) []byte {
+	if color == "" {
+		color = "lightgrey"
+		if code < 500 {
+			color = "red"
+		}
 	}
 	return b.render(badgeSpec{style: config.StyleFlat, label: id, message: reason, color: color, id: id})
 }
@@ -244,15 +266,31 @@ func xmlIDSafe(s string) string {
 
 // writeText draws s as glyph paths at (originX, baseline) on a background of bgHex:
 // a 1px drop shadow beneath a fill, both colored for legibility on that background.
-// Nothing is written for empty text.
-func (b *badgeRenderer) writeText(s *strings.Builder, text string, originX, baseline float64, bgHex string) {
+// A non-empty darkClass classes them for their segment's dark-mode colors. Nothing is
+// written for empty text.
+func (b *badgeRenderer) writeText(s *strings.Builder, text string, originX, baseline float64, bgHex, darkClass string) {
 	d := b.glyphPath(text, originX, baseline)
 	if d == "" {
 		return
 	}
 	textColor, shadowColor := colorsForBackground(bgHex)
-	fmt.Fprintf(s, `<path transform="translate(0 1)" fill="%s" fill-opacity=".3" d="%s"/>`, shadowColor, d)
-	fmt.Fprintf(s, `<path fill="%s" d="%s"/>`, textColor, d)
+	fmt.Fprintf(s, `<path transform="translate(0 1)" fill="%s" fill-opacity=".3"%s d="%s"/>`, shadowColor, classAttr(darkClass, "-s"), d)
+	fmt.Fprintf(s, `<path fill="%s"%s d="%s"/>`, textColor, classAttr(darkClass, "-t"), d)
+}
+
+// writeDarkRules writes the dark-scheme rules for a segment's class: its background
+// in hex, and its text (class-t) and shadow (class-s) colored for contrast on it.
+func writeDarkRules(s *strings.Builder, class, hex string) {
+	text, shadow := colorsForBackground(hex)
+	fmt.Fprintf(s, `.%[1]s{fill:%[2]s}.%[1]s-t{fill:%[3]s}.%[1]s-s{fill:%[4]s}`, class, hex, text, shadow)
+}
+
+// classAttr is a class attribute naming class+suffix, or nothing for an empty class.
+func classAttr(class, suffix string) string {
+	if class == "" {
+		return ""
+	}
+	return ` class="` + class + suffix + `"`
 }
 
 // styleAppearance returns the corner radius and linear-gradient stops for a badge
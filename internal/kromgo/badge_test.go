@@ -116,11 +116,11 @@ func TestRenderBadge_ConfigTable(t *testing.T) {
 			t.Parallel()
 			rb, err := resolveBadge(tc.badge, config.Defaults{}, env)
 			require.NoError(t, err)
-			msg, err := evalStringExpr(rb.valueProg, tc.value, nil)
+			msg, err := evalStringExpr(rb.valueProg, exprVars{result: tc.value})
 			require.NoError(t, err)
 			var color string
 			if rb.colorProg != nil {
-				color, err = evalStringExpr(rb.colorProg, tc.value, nil)
+				color, err = evalStringExpr(rb.colorProg, exprVars{result: tc.value})
 				require.NoError(t, err)
 			}
 			label := rb.Title
@@ -194,6 +194,50 @@ func TestBadgeRender_LabelColor(t *testing.T) {
 	assert.NotContains(t, def, `<path fill="#333"`, "no dark text on an all-dark badge")
 }
 
+func TestBadgeRender_LabelColorDark(t *testing.T) {
+	t.Parallel()
+	r, err := newBadgeRenderer(config.BadgeDefaults{})
+	require.NoError(t, err)
+	icon, err := resolveIcon("mdi:server-outline")
+	require.NoError(t, err)
+
+	// A dark label color repaints the label rect, text, shadow, and icon under a dark
+	// color scheme, via classes namespaced by the badge id; the message is untouched.
+	out := string(r.render(badgeSpec{
+		style: config.StyleFlat, iconPath: icon, label: "build", message: "passing",
+		color: "blue", labelColor: "#e0e0e0", labelColorDark: "#24292f", id: "my.badge",
+	}))
+	assert.Contains(t, out, `<style>@media (prefers-color-scheme: dark){.d-my-badge{fill:#24292f}.d-my-badge-t{fill:#fff}.d-my-badge-s{fill:#010101}}</style>`)
+	assert.Contains(t, out, `fill="#e0e0e0" class="d-my-badge"`, "label rect keeps its light color by default")
+	assert.Equal(t, 2, strings.Count(out, `class="d-my-badge-t"`), "label text and icon")
+	assert.Equal(t, 1, strings.Count(out, `class="d-my-badge-s"`), "label shadow")
+	assert.Contains(t, out, `<path fill="#fff" d=`, "message text has no class")
+
+	// A dark message color repaints the message rect, text, and shadow alongside the
+	// label, and an icon riding on the message.
+	out = string(r.render(badgeSpec{
+		style: config.StyleFlat, label: "build", message: "passing", color: "blue",
+		colorDark: "#e0e0e0", labelColorDark: "#24292f", id: "ci",
+	}))
+	assert.Contains(t, out, `<style>@media (prefers-color-scheme: dark){.d-ci{fill:#24292f}.d-ci-t{fill:#fff}.d-ci-s{fill:#010101}.dm-ci{fill:#e0e0e0}.dm-ci-t{fill:#333}.dm-ci-s{fill:#ccc}}</style>`)
+	assert.Contains(t, out, `fill="#007ec6" class="dm-ci"`, "message rect keeps its light color by default")
+	assert.Equal(t, 1, strings.Count(out, `class="dm-ci-t"`), "message text")
+	assert.Equal(t, 1, strings.Count(out, `class="dm-ci-s"`), "message shadow")
+	out = string(r.render(badgeSpec{style: config.StyleFlat, iconPath: icon, message: "passing", colorDark: "yellow", id: "ci"}))
+	assert.NotContains(t, out, ".d-ci{", "no label segment to repaint")
+	assert.Equal(t, 2, strings.Count(out, `class="dm-ci-t"`), "message text and icon")
+
+	// Without a label segment there's nothing to repaint.
+	for _, spec := range []badgeSpec{
+		{style: config.StyleFlat, label: "build", message: "passing", id: "x"},
+		{style: config.StyleFlat, iconPath: icon, message: "passing", labelColorDark: "#24292f", id: "x"},
+	} {
+		out := string(r.render(spec))
+		assert.NotContains(t, out, "<style>")
+		assert.NotContains(t, out, "class=")
+	}
+}
+
 func TestBadgeRender_UniqueIDs(t *testing.T) {
 	t.Parallel()
 	r, err := newBadgeRenderer(config.BadgeDefaults{})
@@ -237,11 +281,11 @@ func TestBadgeRenderError(t *testing.T) {
 	require.NoError(t, err)
 
 	// 4xx → red ("your request is wrong"); 5xx → grey ("couldn't get an answer").
-	client := string(r.renderError("cpu", "Not Found", 404))
+	client := string(r.renderError("cpu", "Not Found", "", 404))
 	assert.Contains(t, client, "#e05d44", "client errors are red")
 	assert.Contains(t, client, `aria-label="cpu: Not Found"`)
 
-	server := string(r.renderError("cpu", "Query Error", 500))
+	server := string(r.renderError("cpu", "Query Error", "", 500))
 	assert.Contains(t, server, "#9f9f9f", "server/upstream errors are grey")
 	assert.Contains(t, server, `aria-label="cpu: Query Error"`)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"html"
+	"log/slog"
 	"math"
 	"net/http"
 	"slices"
@@ -13,6 +14,8 @@ import (
 
 	charts "github.com/go-analyze/charts"
 	"github.com/golang/freetype/truetype"
+	"github.com/google/cel-go/cel"
+	"github.com/home-operations/kromgo/internal/config"
 	"github.com/prometheus/common/model"
 )
 
@@ -32,38 +35,116 @@ type chartParams struct {
 	legend bool
 	theme  string
 	title  string         // chart title, rendered top-left
+	log    *slog.Logger   // warns of overlays a chart drops; nil logs nothing
 	font   *truetype.Font // nil uses the chart library's default font
 	format string         // "svg" (default) or "png"
+	chart  string         // chart type (config.Chart*); "" draws a line chart
+	mode   string         // config.Mode*; "" draws the full chart
 	fill   bool           // draw a translucent area beneath the line(s)
-	yMin   *float64       // pin the y-axis minimum; nil auto-fits
-	yMax   *float64       // pin the y-axis maximum; nil auto-fits
+	// sparkline sizes and marks the image in sparkline mode.
+	sparkline sparklineParams
+	// nullMode draws the points a series is missing (config.Null*); "" leaves gaps.
+	nullMode string
+	// downsample thins rows longer than the chart is wide (config.Downsample*); ""
+	// draws every point.
+	downsample string
+	// location is the time zone x-axis times are shown in (the graph's timezone, or
+	// ?tz=); nil is UTC.
+	location *time.Location
+	// timeLayouts are the x-axis label layouts per boundary (the graph's timeFormats).
+	timeLayouts timeLayouts
+	// alignTicks places an SVG's x-axis labels on round time boundaries (the graph's
+	// alignTicks, or ?alignticks=). xTicks are those labels, which kromgo draws itself
+	// (set by renderChart); empty leaves them to the library.
+	alignTicks bool
+	xTicks     []timeTick
+	// annotations are the events from the graph's annotation queries (set per
+	// request), and markers those placed on the chart (set by renderChart).
+	annotations []annotationEvent
+	markers     []annotationMarker
+	// compare is how far back the compare queries reach (the graph's compare, or
+	// ?compare=); 0 runs none. overlay is their series prepared for drawing (set by
+	// renderChart).
+	compare time.Duration
+	overlay compareOverlay
+	// start, end, and step are the request's range-query grid, which every series is
+	// aligned onto. A zero step aligns on the union of the series' timestamps.
+	start, end time.Time
+	step       time.Duration
+	yMin       *float64 // pin the y-axis minimum; nil auto-fits
+	yMax       *float64 // pin the y-axis maximum; nil auto-fits
+	// yLabels fixes the y-axis label count (set with a threshold range); 0 lets the
+	// library choose.
+	yLabels int
 	// markLines lists dashed reference lines to draw (average/min/max/median),
-	// validated at resolve time. Rendered on the first series only.
+	// validated at resolve time. Rendered per series, or for the stacked total.
 	markLines []string
+	// markMatch selects the series that get mark lines by exact label values; empty
+	// selects every series.
+	markMatch map[string]string
+	// thresholds are static lines (and bands) kromgo draws over the chart, sorted by
+	// value. Drawn on the line, stacked-area, and bar types only.
+	thresholds []threshold
 	// valueFormatter renders y-axis tick values to strings (from the graph's
 	// valueExpr). nil uses the chart library's default numeric formatting.
 	valueFormatter func(float64) string
+	// rightQueries names the queries plotted against a right-hand y-axis, formatted by
+	// rightValueFormatter. Empty draws the left axis only.
+	rightQueries        map[string]bool
+	rightValueFormatter func(float64) string
+	// queryFormatters are the formatters of the queries that set their own valueExpr,
+	// by name: their series' mark lines, legend table, and tooltips use them.
+	queryFormatters map[string]func(float64) string
+	// seriesColors pins the colors of matching series (the graph's seriesColors);
+	// the rest are hashed onto the theme's palette — see seriesPalette.
+	seriesColors []seriesColor
+	// legendExpr names each series from its labels (the graph's legendExpr). nil joins
+	// the label values.
+	legendExpr cel.Program
+	// legendTable draws the legend as a table of legendColumns beneath the chart (with
+	// legend false). See legendRows.
+	legendTable   bool
+	legendColumns []string
+	// tooltips marks each point of an SVG line or stacked-area chart with a hover
+	// tooltip. See chartTooltips.
+	tooltips bool
+	// themeDark is the theme an SVG also carries, for a dark color scheme; "" draws
+	// theme alone. See renderSchemes.
+	themeDark string
+	// themes are the config's themes, which theme (or ?theme=) may name.
+	themes themeSet
 }
 
 // withOverrides returns the graph's default params with request query parameters
-// applied on top (width/height/legend/theme/format).
+// applied on top (mode/width/height/legend/fill/tooltips/alignticks/ymin/ymax/theme/
+// themedark/chart/nullmode/tz/compare/format).
 func (p chartParams) withOverrides(r *http.Request) chartParams {
 	q := r.URL.Query()
+	if s := q.Get("mode"); config.ValidMode[s] {
+		p.mode = s
+	}
+	// The size is the image the request renders: a sparkline has its own.
+	width, height := &p.width, &p.height
+	if p.mode == config.ModeSparkline {
+		width, height = &p.sparkline.width, &p.sparkline.height
+	}
 	if s := q.Get("width"); s != "" {
 		if v, err := strconv.Atoi(s); err == nil && v > 0 {
-			p.width = min(v, maxChartDimension)
+			*width = min(v, maxChartDimension)
 		}
 	}
 	if s := q.Get("height"); s != "" {
 		if v, err := strconv.Atoi(s); err == nil && v > 0 {
-			p.height = min(v, maxChartDimension)
+			*height = min(v, maxChartDimension)
 		}
 	}
-	switch q.Get("legend") {
-	case "false":
-		p.legend = false
-	case "true":
-		p.legend = true
+	switch config.Legend(q.Get("legend")) {
+	case config.LegendHide:
+		p.legend, p.legendTable = false, false
+	case config.LegendShow:
+		p.legend, p.legendTable = true, false
+	case config.LegendTable:
+		p.legend, p.legendTable = false, true
 	}
 	switch q.Get("fill") {
 	case "false":
@@ -71,6 +152,18 @@ func (p chartParams) withOverrides(r *http.Request) chartParams {
 	case "true":
 		p.fill = true
 	}
+	switch q.Get("tooltips") {
+	case "false":
+		p.tooltips = false
+	case "true":
+		p.tooltips = true
+	}
+	switch q.Get("alignticks") {
+	case "false":
+		p.alignTicks = false
+	case "true":
+		p.alignTicks = true
+	}
 	if s := q.Get("ymin"); s != "" {
 		if v, err := strconv.ParseFloat(s, 64); err == nil {
 			p.yMin = &v
@@ -84,6 +177,25 @@ func (p chartParams) withOverrides(r *http.Request) chartParams {
 	if s := q.Get("theme"); s != "" {
 		p.theme = s // unknown names fall back to the default in chartTheme
 	}
+	if s := q.Get("themedark"); s != "" {
+		p.themeDark = s
+	}
+	if s := q.Get("chart"); config.ValidChart[s] {
+		p.chart = s
+	}
+	if s := q.Get("nullmode"); config.ValidNullMode[s] {
+		p.nullMode = s
+	}
+	if s := q.Get("tz"); s != "" {
+		if loc, err := time.LoadLocation(s); err == nil {
+			p.location = loc
+		}
+	}
+	if s := q.Get("compare"); s != "" {
+		if d, err := config.ParseDuration(s); err == nil && d >= 0 {
+			p.compare = d // 0 turns a graph's compare off
+		}
+	}
 	if q.Get("format") == formatPNG {
 		p.format = formatPNG
 	}
@@ -98,138 +210,605 @@ func (p chartParams) contentType() string {
 	return mimeSVG
 }
 
-// seriesLabel returns a display label for a series by joining its non-__name__ label values.
-func seriesLabel(stream *model.SampleStream) string {
-	keys := make([]string, 0, len(stream.Metric))
-	for k := range stream.Metric {
-		if k != "__name__" {
-			keys = append(keys, string(k))
+// seriesLabel returns a series' display name: the graph's legendExpr evaluated over
+// its labels or, without one, its label values (skipping __name__) joined by commas.
+// Either is led by its query's name on a multi-query graph: "errors (500, GET)", and
+// a compare series is followed by its period: "api (last week)". A legendExpr that
+// fails at runtime falls back to the joined values. The series.other bucket is named
+// by its config, after its query: "errors (other)".
+func (p chartParams) seriesLabel(metric model.Metric) string {
+	if name, ok := metric[otherLabel]; ok {
+		return parenthesize(string(metric[queryLabel]), string(name))
+	}
+	label, ok := "", false
+	if p.legendExpr != nil {
+		s, err := evalStringExpr(p.legendExpr, exprVars{labels: seriesLabels(metric), now: time.Now()})
+		label, ok = s, err == nil
+	}
+	if !ok {
+		keys := make([]string, 0, len(metric))
+		for k := range metric {
+			if k != model.MetricNameLabel && k != queryLabel && k != compareLabel {
+				keys = append(keys, string(k))
+			}
+		}
+		slices.Sort(keys)
+		vals := make([]string, 0, len(keys))
+		for _, k := range keys {
+			vals = append(vals, string(metric[model.LabelName(k)]))
 		}
+		label = strings.Join(vals, ", ")
 	}
-	if len(keys) == 0 {
-		return ""
+	if name := string(metric[queryLabel]); name != "" {
+		label = parenthesize(name, label)
 	}
-	slices.Sort(keys)
-	vals := make([]string, 0, len(keys))
-	for _, k := range keys {
-		vals = append(vals, string(stream.Metric[model.LabelName(k)]))
+	if period := string(metric[compareLabel]); period != "" {
+		label = parenthesize(label, period)
 	}
-	return strings.Join(vals, ", ")
+	return label
 }
 
-// renderChart draws the matrix as a themed line chart and returns the encoded image
-// (SVG or PNG). Non-finite samples (NaN/Inf) become gaps.
+// parenthesize is "name (detail)", or whichever of the two is set when one is empty.
+func parenthesize(name, detail string) string {
+	switch {
+	case name == "":
+		return detail
+	case detail == "":
+		return name
+	default:
+		return name + " (" + detail + ")"
+	}
+}
+
+// seriesLabels is a series' labels as expressions and the JSON see them: without the
+// internal queryLabel, otherLabel, and compareLabel tags.
+func seriesLabels(metric model.Metric) map[string]string {
+	labels := labelMap(metric)
+	delete(labels, string(queryLabel))
+	delete(labels, string(otherLabel))
+	delete(labels, string(compareLabel))
+	return labels
+}
+
+// rightAxis reports whether a series is plotted against the right-hand y-axis.
+func (p chartParams) rightAxis(metric model.Metric) bool {
+	return p.rightQueries[string(metric[queryLabel])]
+}
+
+// axisFormatter is the tick formatter for a series' y-axis.
+func (p chartParams) axisFormatter(metric model.Metric) func(float64) string {
+	if p.rightAxis(metric) {
+		return p.rightValueFormatter
+	}
+	return p.valueFormatter
+}
+
+// seriesFormatter formats a series' own values (mark lines, the legend table,
+// tooltips): through its query's valueExpr, or else its axis'.
+func (p chartParams) seriesFormatter(metric model.Metric) func(float64) string {
+	if format, ok := p.queryFormatters[string(metric[queryLabel])]; ok {
+		return format
+	}
+	return p.axisFormatter(metric)
+}
+
+// renderChart draws the matrix as a themed chart of type p.chart and returns the
+// encoded image (SVG or PNG). Time-series types (line, stacked-area, bar) plot every
+// sample, with non-finite samples (NaN/Inf) as gaps; categorical types
+// (horizontal-bar, pie, donut) plot each series' latest value, one category per series.
+// Compare series (tagged compareLabel), thresholds, and annotations are drawn over
+// an SVG of the time-series types, and x-axis labels on round time boundaries and
+// tooltips over an SVG line or stacked-area chart (labels over an SVG bar chart too).
+// A matrix without a finite current sample renders as a "No data" chart.
 func renderChart(matrix model.Matrix, p chartParams) ([]byte, error) {
-	values := make([][]float64, len(matrix))
-	labels := make([]string, len(matrix))
-	haveLabels := false
-	var xAxis []string
+	matrix, previous := splitCompare(matrix)
+	if !hasSamples(matrix) {
+		return renderNoData(p)
+	}
+	rows := p.legendRows(matrix)
+	var data timeRows
+	if p.plotsTime() {
+		data = p.chartRows(matrix)
+		if p.format != formatPNG {
+			// What kromgo draws itself goes in the plot an SVG probe measures, whose
+			// text a PNG lays out a few pixels differently: a PNG is the library's
+			// chart alone.
+			if p.alignTicks {
+				p.xTicks = p.timeTicks(data.grid)
+			}
+			p.markers = p.annotationMarkers(data.grid)
+			p.overlay = p.compareOverlay(matrix, previous, true)
+		}
+	}
+	thresholds := p.drawsThresholds(matrix)
+	overlay := len(p.overlay.lines) > 0
+	tooltips := p.drawsTooltips(matrix)
+	var plot plotArea
+	var err error
+	switch {
+	case thresholds || overlay:
+		var pinned chartParams
+		if pinned, plot, err = p.thresholdLayout(matrix, data); err == nil {
+			p = pinned
+		}
+	case len(p.xTicks) > 0 || len(p.markers) > 0 || tooltips:
+		plot, err = p.timeLayout(matrix, data)
+	}
+	if err != nil {
+		// No plot to draw in: nothing on the left axis to measure by, or a probe the
+		// chart library laid out unrecognizably. Draw the library's chart alone rather
+		// than fail the request.
+		if p.log != nil {
+			p.log.Warn("chart overlays dropped", "error", err)
+		}
+		thresholds, overlay, tooltips = false, false, false
+		p.xTicks, p.markers = nil, nil
+	}
+	// Font is set on the painter (the non-deprecated default-font hook). resolveGraphFont
+	// always returns a face (DejaVu Sans by default), so p.font is never nil here. A
+	// legend table lengthens the image; the chart is drawn in the top p.height of it.
+	painter := charts.NewPainter(charts.PainterOptions{
+		OutputFormat: p.format, // "svg" or "png"
+		Width:        p.width,
+		Height:       p.height + legendTableHeight(len(rows)),
+		Font:         p.font,
+	})
+	chart := painter.Child(charts.PainterBoxOption(charts.NewBox(0, 0, p.width, p.height)))
+	switch p.chart {
+	case config.ChartBar:
+		err = chart.BarChart(barChartOption(matrix, data, p))
+	case config.ChartHorizontalBar:
+		err = chart.BarChart(horizontalBarChartOption(matrix, p))
+	case config.ChartPie:
+		err = chart.PieChart(pieChartOption(matrix, p))
+	case config.ChartDonut:
+		err = chart.DoughnutChart(donutChartOption(matrix, p))
+	default: // ChartLine, ChartStackedArea
+		err = chart.LineChart(lineChartOption(matrix, data, p))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if overlay {
+		drawCompare(chart, plot, p, data.grid)
+	}
+	if thresholds {
+		drawThresholds(chart, plot, p)
+	}
+	if len(p.markers) > 0 {
+		drawAnnotations(chart, plot, p)
+	}
+	if len(p.xTicks) > 0 {
+		drawTimeTicks(chart, plot, p)
+	}
+	if len(rows) > 0 {
+		drawLegendTable(painter, p, rows, p.height)
+	}
+	out, err := p.encode(painter)
+	if err != nil || !tooltips {
+		return out, err
+	}
+	return withTooltips(out, p.chartTooltips(matrix, data, plot)), nil
+}
 
+// encode returns the painter's image. The chart library emits SVG with only a
+// viewBox; add explicit width/height so <img> embeds (and inline use) render at the
+// painter's pixel size rather than the browser's 300x150 default.
+func (p chartParams) encode(painter *charts.Painter) ([]byte, error) {
+	out, err := painter.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if p.format != formatPNG {
+		dims := fmt.Sprintf(`<svg width="%d" height="%d" `, painter.Width(), painter.Height())
+		out = bytes.Replace(out, []byte("<svg "), []byte(dims), 1)
+	}
+	return out, nil
+}
+
+// noDataText is the message a graph with no samples in its window shows.
+const noDataText = "No data"
+
+// hasSamples reports whether any series in the matrix has a finite sample.
+func hasSamples(matrix model.Matrix) bool {
+	return slices.ContainsFunc(matrix, func(s *model.SampleStream) bool {
+		return slices.ContainsFunc(s.Values, func(pt model.SamplePair) bool {
+			f := float64(pt.Value)
+			return !math.IsNaN(f) && !math.IsInf(f, 0)
+		})
+	})
+}
+
+// renderNoData draws an empty chart in the params' theme: the background, the title
+// top-left, and noDataText centered.
+func renderNoData(p chartParams) ([]byte, error) {
+	theme := chartTheme(p.theme, p.themes)
+	painter := charts.NewPainter(charts.PainterOptions{
+		OutputFormat: p.format,
+		Width:        p.width,
+		Height:       p.height,
+		Font:         p.font,
+	})
+	bg := theme.GetBackgroundColor()
+	painter.FilledRect(0, 0, p.width, p.height, bg, bg, 0)
+	title := p.title
+	if p.format != formatPNG {
+		title = html.EscapeString(title) // the library writes SVG text unescaped
+	}
+	if title != "" {
+		style := charts.FontStyle{Font: p.font, FontSize: 14, FontColor: theme.GetTitleTextColor()}
+		box := painter.MeasureText(title, 0, style)
+		painter.Text(title, 20, 20+box.Height(), 0, style)
+	}
+	style := charts.FontStyle{Font: p.font, FontSize: 12, FontColor: theme.GetLegendTextColor()}
+	box := painter.MeasureText(noDataText, 0, style)
+	painter.Text(noDataText, (p.width-box.Width())/2, (p.height+box.Height())/2, 0, style)
+	return p.encode(painter)
+}
+
+// timeRows are a matrix's chart rows, their legend labels, and the time grid, as
+// timeSeries extracts them: once per render, for the probe, the chart, and its
+// tooltips alike.
+type timeRows struct {
+	values    [][]float64
+	names     []string
+	haveNames bool
+	grid      []model.Time
+}
+
+// chartRows is the matrix's timeSeries as timeRows.
+func (p chartParams) chartRows(matrix model.Matrix) timeRows {
+	var r timeRows
+	r.values, r.names, r.haveNames, r.grid = p.timeSeries(matrix)
+	return r
+}
+
+// timeSeries extracts the matrix as chart rows aligned on the params' time grid
+// (downsampled to the chart's width when the graph asks), their escaped legend
+// labels, and the grid. A point a series has no finite sample for is the
+// library's null value (a gap) unless nullMode fills it. haveLabels is false when no
+// series carries a label.
+func (p chartParams) timeSeries(matrix model.Matrix) (values [][]float64, labels []string, haveLabels bool, grid []model.Time) {
+	grid = p.timeGrid(matrix)
+	position := gridPosition(grid, p.step)
+	values = make([][]float64, len(matrix))
+	labels = make([]string, len(matrix))
 	for i, stream := range matrix {
-		row := make([]float64, len(stream.Values))
-		for j, pt := range stream.Values {
+		row := make([]float64, len(grid))
+		for j := range row {
+			row[j] = math.NaN()
+		}
+		for _, pt := range stream.Values {
 			v := float64(pt.Value)
-			if math.IsNaN(v) || math.IsInf(v, 0) {
-				v = charts.GetNullValue() // rendered as a gap
+			if j, ok := position(pt.Timestamp); ok && !math.IsInf(v, 0) {
+				row[j] = v
 			}
-			row[j] = v
 		}
 		values[i] = row
-		if label := seriesLabel(stream); label != "" {
+		if label := p.seriesLabel(stream.Metric); label != "" {
 			// Escape: the charting library writes legend labels into SVG <text>
 			// without escaping, so a metric label value could inject markup/script.
 			labels[i] = html.EscapeString(label)
 			haveLabels = true
 		}
-		if xAxis == nil {
-			xAxis = timeAxisLabels(stream.Values)
+	}
+	if p.downsample != "" {
+		grid, values = downsampleRows(p.downsample, grid, values, p.width)
+	}
+	null := charts.GetNullValue()
+	for _, row := range values {
+		for j, v := range row {
+			if math.IsNaN(v) {
+				row[j] = null
+			}
 		}
+		p.fillNulls(row)
 	}
+	return values, labels, haveLabels, grid
+}
 
-	opt := charts.NewLineChartOptionWithData(values)
-	opt.Theme = chartTheme(p.theme)
-	opt.XAxis.Labels = xAxis
-	// One label per sample collides; cap the count by width so the library samples
-	// an evenly-spaced, non-overlapping subset.
-	if n := len(xAxis); n > 0 {
-		opt.XAxis.LabelCount = min(max(p.width/110, 2), n)
+// timeGrid is the x-axis every series is aligned onto: the range query's evaluation
+// times from start to end by step or, without a step, every timestamp any series
+// (float or histogram) has.
+func (p chartParams) timeGrid(matrix model.Matrix) []model.Time {
+	if p.step > 0 && !p.end.Before(p.start) {
+		first, stepMs := model.TimeFromUnixNano(p.start.UnixNano()), p.step.Milliseconds()
+		n := int(p.end.Sub(p.start)/p.step) + 1
+		grid := make([]model.Time, n)
+		for i := range grid {
+			grid[i] = first.Add(time.Duration(int64(i)*stepMs) * time.Millisecond)
+		}
+		return grid
 	}
-	if p.title != "" {
-		opt.Title = charts.TitleOption{Text: p.title, Offset: charts.OffsetLeft}
+	var grid []model.Time
+	for _, stream := range matrix {
+		for _, pt := range stream.Values {
+			grid = append(grid, pt.Timestamp)
+		}
+		for _, pt := range stream.Histograms {
+			grid = append(grid, pt.Timestamp)
+		}
 	}
-	if p.legend && haveLabels {
-		opt.Legend.SeriesNames = labels
-	} else {
-		hide := false
-		opt.Legend.Show = &hide
+	slices.Sort(grid)
+	return slices.Compact(grid)
+}
+
+// gridPosition returns a lookup from a sample's timestamp to its index on the grid.
+// On a stepped grid a timestamp snaps to the nearest step, absorbing the millisecond
+// rounding of the query's start.
+func gridPosition(grid []model.Time, step time.Duration) func(model.Time) (int, bool) {
+	if len(grid) == 0 {
+		return func(model.Time) (int, bool) { return 0, false }
 	}
-	// Fill the area beneath the line(s). The library's default fill alpha (200/255) is
-	// heavy when kromgo's per-series lines overlap, so use a lighter, translucent value.
-	if p.fill {
-		fill := true
-		opt.FillArea = &fill
-		opt.FillOpacity = fillOpacity
+	if step > 0 {
+		stepMs := float64(step.Milliseconds())
+		return func(ts model.Time) (int, bool) {
+			j := int(math.Round(float64(ts-grid[0]) / stepMs))
+			return j, j >= 0 && j < len(grid)
+		}
+	}
+	index := make(map[model.Time]int, len(grid))
+	for i, ts := range grid {
+		index[ts] = i
 	}
+	return func(ts model.Time) (int, bool) {
+		j, ok := index[ts]
+		return j, ok
+	}
+}
+
+// fillNulls applies the null mode to a row: zero replaces each null with 0, and
+// connect (on the line types) interpolates across nulls between two values. Leading
+// and trailing nulls stay gaps either way under connect.
+func (p chartParams) fillNulls(row []float64) []float64 {
+	null := charts.GetNullValue()
+	switch {
+	case p.nullMode == config.NullZero:
+		for j, v := range row {
+			if v == null {
+				row[j] = 0
+			}
+		}
+	case p.nullMode == config.NullConnect && p.chart != config.ChartBar:
+		prev := -1
+		for j, v := range row {
+			if v == null {
+				continue
+			}
+			if prev >= 0 {
+				for k := prev + 1; k < j; k++ {
+					row[k] = row[prev] + (v-row[prev])*float64(k-prev)/float64(j-prev)
+				}
+			}
+			prev = j
+		}
+	}
+	return row
+}
+
+// categories reduces each series to its latest finite value for the categorical
+// chart types, named by its (escaped) labels and sorted by name so the layout is
+// stable across requests, with each one's metric for coloring. Series with no finite
+// samples are dropped.
+func (p chartParams) categories(matrix model.Matrix) (values []float64, names []string, metrics []model.Metric) {
+	vector := reduceMatrix(matrix, config.ReduceLast)
+	labels := make(map[*model.Sample]string, len(vector))
+	for _, sample := range vector {
+		labels[sample] = p.seriesLabel(sample.Metric)
+	}
+	slices.SortStableFunc(vector, func(a, b *model.Sample) int {
+		return strings.Compare(labels[a], labels[b])
+	})
+	for _, sample := range vector {
+		values = append(values, float64(sample.Value))
+		names = append(names, html.EscapeString(labels[sample]))
+		metrics = append(metrics, sample.Metric)
+	}
+	return values, names, metrics
+}
+
+// streamMetrics is the matrix's series' label sets, in order.
+func streamMetrics(matrix model.Matrix) []model.Metric {
+	metrics := make([]model.Metric, len(matrix))
+	for i, stream := range matrix {
+		metrics[i] = stream.Metric
+	}
+	return metrics
+}
+
+// chartTitle is the chart's top-left title option. The title is escaped for SVG: a
+// titleExpr can build it from label values.
+func (p chartParams) chartTitle() charts.TitleOption {
+	if p.title == "" {
+		return charts.TitleOption{}
+	}
+	return charts.TitleOption{Text: p.svgText(p.title), Offset: charts.OffsetLeft}
+}
+
+// chartLegend shows names in the legend when enabled and there is something to name,
+// followed by the compare lines'.
+func (p chartParams) chartLegend(names []string, haveNames bool) charts.LegendOption {
+	if len(p.overlay.lines) > 0 {
+		names, haveNames = append(slices.Clip(names), p.overlay.names()...), true
+	}
+	if p.legend && haveNames {
+		return charts.LegendOption{SeriesNames: names}
+	}
+	return charts.LegendOption{Show: charts.Ptr(false)}
+}
+
+// valueAxes are the line and bar types' y-axes: the left one, plus a right one when
+// any query is plotted against it.
+func (p chartParams) valueAxes() []charts.YAxisOption {
+	if len(p.rightQueries) == 0 {
+		return []charts.YAxisOption{p.valueAxis()}
+	}
+	// The right axis always auto-fits: yMin/yMax and thresholds are in the left axis' units.
+	right := chartParams{valueFormatter: p.rightValueFormatter}.valueAxis()
+	right.Position = charts.PositionRight
+	return []charts.YAxisOption{p.valueAxis(), right}
+}
+
+// valueAxis is the numeric (left) axis shared by the line and bar types: pinned bounds,
+// round ticks, and the graph's valueExpr formatting.
+func (p chartParams) valueAxis() charts.YAxisOption {
 	// Ask the chart library for round y-axis tick values (e.g. 25/30/35/40/45 rather
 	// than dividing the range evenly into 25/29.39/33.78/…). Without this the ticks
 	// land on arbitrary floats, which a valueExpr like `string(result)` then prints at
 	// full precision ("46.9405%"). A graph's valueExpr (if any) then formats those
 	// values — integers or humanized units in place of the default 2-decimal numbers.
 	niceIntervals := true
-	yAxis := charts.YAxisOption{PreferNiceIntervals: &niceIntervals, Min: p.yMin, Max: p.yMax}
+	axis := charts.YAxisOption{PreferNiceIntervals: &niceIntervals, Min: p.yMin, Max: p.yMax, LabelCount: p.yLabels}
 	if p.valueFormatter != nil {
-		yAxis.ValueFormatter = p.valueFormatter
+		axis.ValueFormatter = p.valueFormatter
 	}
-	opt.YAxis = []charts.YAxisOption{yAxis}
+	return axis
+}
 
-	// Dashed reference lines (average/min/max/median). The chart library renders mark
-	// lines for the first series only; reuse the y-axis formatter so the mark values
-	// match the axis labels.
-	if len(p.markLines) > 0 && len(opt.SeriesList) > 0 {
-		markLine := charts.NewMarkLine(p.markLines...)
-		markLine.ValueFormatter = p.valueFormatter
-		opt.SeriesList[0].MarkLine = markLine
+// markLine is the dashed reference-line config for a series, or the zero value when
+// none is configured or markMatch doesn't select the series' labels. It reuses the
+// y-axis formatter so the mark values match the axis labels, unless the series'
+// query formats its values itself.
+func (p chartParams) markLine(metric model.Metric) charts.SeriesMarkLine {
+	if len(p.markLines) == 0 {
+		return charts.SeriesMarkLine{}
+	}
+	if !matchesLabels(metric, p.markMatch) {
+		return charts.SeriesMarkLine{}
 	}
+	markLine := charts.NewMarkLine(p.markLines...)
+	markLine.ValueFormatter = p.seriesFormatter(metric)
+	return markLine
+}
 
-	// Font is set on the painter (the non-deprecated default-font hook). resolveGraphFont
-	// always returns a face (DejaVu Sans by default), so p.font is never nil here.
-	painter := charts.NewPainter(charts.PainterOptions{
-		OutputFormat: p.format, // "svg" or "png"
-		Width:        p.width,
-		Height:       p.height,
-		Font:         p.font,
-	})
-	if err := painter.LineChart(opt); err != nil {
-		return nil, err
+// matchesLabels reports whether every label in match has its value in metric (an
+// empty match selects every series).
+func matchesLabels(metric model.Metric, match map[string]string) bool {
+	for k, v := range match {
+		if string(metric[model.LabelName(k)]) != v {
+			return false
+		}
 	}
-	out, err := painter.Bytes()
-	if err != nil {
-		return nil, err
+	return true
+}
+
+// totalMarkLine is the mark-line config for a stacked chart: "global" marks, which
+// the library computes over the sum of all series and draws from the last one.
+func (p chartParams) totalMarkLine() charts.SeriesMarkLine {
+	if len(p.markLines) == 0 {
+		return charts.SeriesMarkLine{}
 	}
-	if p.format != formatPNG {
-		// The chart library emits SVG with only a viewBox; add explicit width/height
-		// so <img> embeds (and inline use) render at the requested pixel size rather
-		// than the browser's 300x150 default.
-		dims := fmt.Sprintf(`<svg width="%d" height="%d" `, p.width, p.height)
-		out = bytes.Replace(out, []byte("<svg "), []byte(dims), 1)
+	markLine := charts.SeriesMarkLine{ValueFormatter: p.valueFormatter}
+	markLine.AddGlobalLines(p.markLines...)
+	return markLine
+}
+
+// sliceLabel labels a pie or donut slice with its name and, under a valueExpr, its
+// formatted value; otherwise the library's default "name: percent" is kept.
+func (p chartParams) sliceLabel() charts.SeriesLabel {
+	if p.valueFormatter == nil {
+		return charts.SeriesLabel{}
 	}
-	return out, nil
+	return charts.SeriesLabel{LabelFormatter: func(_ int, name string, val float64) (string, *charts.LabelStyle) {
+		return name + ": " + p.valueFormatter(val), nil
+	}}
 }
 
-// timeAxisLabels formats one x-axis label per sample; the chart library samples
-// them to avoid crowding. The format adapts to the window span.
-func timeAxisLabels(values []model.SamplePair) []string {
-	if len(values) == 0 {
-		return nil
+// lineChartOption builds a line chart, or for stacked-area one whose series are
+// layered into a cumulative filled total.
+func lineChartOption(matrix model.Matrix, data timeRows, p chartParams) charts.LineChartOption {
+	opt := charts.NewLineChartOptionWithData(data.values)
+	opt.Theme = p.withCompareColors(p.seriesPalette(streamMetrics(matrix)), len(matrix))
+	opt.XAxis = p.timeAxis(data.grid)
+	opt.Title = p.chartTitle()
+	opt.Legend = p.chartLegend(data.names, data.haveNames)
+	// Fill the area beneath the line(s). The library's default fill alpha (200/255) is
+	// heavy when kromgo's per-series lines overlap, so use a lighter, translucent value.
+	if p.fill {
+		opt.FillArea = charts.Ptr(true)
+		opt.FillOpacity = fillOpacity
 	}
-	span := values[len(values)-1].Timestamp.Time().Sub(values[0].Timestamp.Time())
-	layout := "15:04"
-	if span >= 24*time.Hour {
-		layout = "01/02"
+	if p.chart == config.ChartStackedArea {
+		opt.StackSeries = charts.Ptr(true) // forces a fill; keep it translucent too
+		opt.FillOpacity = fillOpacity
 	}
-	labels := make([]string, len(values))
-	for i, pt := range values {
-		labels[i] = pt.Timestamp.Time().Format(layout)
+	opt.YAxis = p.valueAxes()
+	for i := range opt.SeriesList {
+		if p.rightAxis(matrix[i].Metric) {
+			opt.SeriesList[i].YAxisIndex = 1
+		}
 	}
-	return labels
+	if p.chart == config.ChartStackedArea {
+		// The library draws a stacked chart's per-series marks for its first series
+		// only, so mark the total the stack adds up to instead.
+		if n := len(opt.SeriesList); n > 0 {
+			opt.SeriesList[n-1].MarkLine = p.totalMarkLine()
+		}
+		return opt
+	}
+	for i := range opt.SeriesList {
+		opt.SeriesList[i].MarkLine = p.markLine(matrix[i].Metric)
+	}
+	return opt
+}
+
+// barChartOption builds a vertical bar chart over time, one bar group per sample.
+func barChartOption(matrix model.Matrix, data timeRows, p chartParams) charts.BarChartOption {
+	opt := charts.NewBarChartOptionWithData(data.values)
+	opt.Theme = p.withCompareColors(p.seriesPalette(streamMetrics(matrix)), len(matrix))
+	opt.CategoryAxis = p.timeAxis(data.grid)
+	opt.Title = p.chartTitle()
+	opt.Legend = p.chartLegend(data.names, data.haveNames)
+	opt.ValueAxis = p.valueAxes()
+	for i := range opt.SeriesList {
+		if p.rightAxis(matrix[i].Metric) {
+			opt.SeriesList[i].YAxisIndex = 1
+		}
+		opt.SeriesList[i].MarkLine = p.markLine(matrix[i].Metric)
+	}
+	return opt
+}
+
+// horizontalBarChartOption builds one horizontal bar per series (e.g. usage per
+// volume), labelled on the category axis, so the legend is redundant and hidden.
+func horizontalBarChartOption(matrix model.Matrix, p chartParams) charts.BarChartOption {
+	values, names, _ := p.categories(matrix)
+	// The library draws the first category at the bottom; reverse so names read
+	// top to bottom.
+	slices.Reverse(values)
+	slices.Reverse(names)
+	opt := charts.NewBarChartOptionWithData([][]float64{values})
+	opt.Horizontal = true
+	opt.Theme = chartTheme(p.theme, p.themes)
+	opt.CategoryAxis = charts.CategoryAxisOption{Labels: names}
+	opt.Title = p.chartTitle()
+	opt.Legend = charts.LegendOption{Show: charts.Ptr(false)}
+	opt.ValueAxis = []charts.ValueAxisOption{p.valueAxis()}
+	return opt
+}
+
+// pieChartOption builds one slice per series.
+func pieChartOption(matrix model.Matrix, p chartParams) charts.PieChartOption {
+	values, names, metrics := p.categories(matrix)
+	opt := charts.NewPieChartOptionWithData(nil)
+	opt.SeriesList = charts.NewSeriesListPie(values, charts.PieSeriesOption{Names: names, Label: p.sliceLabel()})
+	opt.Theme = p.seriesPalette(metrics)
+	opt.Title = p.chartTitle()
+	opt.Legend = p.chartLegend(names, len(names) > 0)
+	return opt
+}
+
+// donutChartOption builds one ring segment per series, with the total in the center.
+func donutChartOption(matrix model.Matrix, p chartParams) charts.DoughnutChartOption {
+	values, names, metrics := p.categories(matrix)
+	opt := charts.NewDoughnutChartOptionWithData(nil)
+	opt.SeriesList = charts.NewSeriesListDoughnut(values, charts.DoughnutSeriesOption{Names: names, Label: p.sliceLabel()})
+	opt.Theme = p.seriesPalette(metrics)
+	opt.Title = p.chartTitle()
+	opt.Legend = p.chartLegend(names, len(names) > 0)
+	opt.CenterValues = "sum"
+	if p.valueFormatter != nil {
+		opt.ValueFormatter = p.valueFormatter
+	}
+	return opt
 }
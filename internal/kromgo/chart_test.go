@@ -1,12 +1,17 @@
 package kromgo
 
 import (
+	"bytes"
 	"fmt"
+	"log/slog"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	charts "github.com/go-analyze/charts"
 	"github.com/home-operations/kromgo/internal/config"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
@@ -65,6 +70,30 @@ func TestRenderChart_ValueFormatter(t *testing.T) {
 	assert.NotContains(t, string(plain), "pods")
 }
 
+func TestRenderChart_RightAxis(t *testing.T) {
+	t.Parallel()
+	data := makeMatrix([][]float64{{40, 45, 50}, {900, 1200, 1500}})
+	data[0].Metric[queryLabel] = "temp"
+	data[1].Metric[queryLabel] = "fan"
+	thresholds := resolveThresholds([]config.Threshold{{Value: new(80.0), Label: "hot"}})
+	for _, chart := range []string{config.ChartLine, config.ChartBar} {
+		t.Run(chart, func(t *testing.T) {
+			t.Parallel()
+			svg, err := renderChart(data, chartParams{width: 600, height: 200, format: formatSVG, chart: chart,
+				thresholds:          thresholds,
+				valueFormatter:      func(f float64) string { return fmt.Sprintf("%d°C", int(f)) },
+				rightQueries:        map[string]bool{"fan": true},
+				rightValueFormatter: func(f float64) string { return fmt.Sprintf("%drpm", int(f)) },
+			})
+			require.NoError(t, err)
+			out := string(svg)
+			assert.Contains(t, out, "°C</text>", "left axis in the graph's units")
+			assert.Contains(t, out, "rpm</text>", "right axis in fan's units")
+			assert.Contains(t, out, ">hot</text>", "thresholds follow the left axis")
+		})
+	}
+}
+
 func TestRenderChart_NiceYAxisTicks(t *testing.T) {
 	t.Parallel()
 	// Over a non-round data range (e.g. [25.3, 46.94]) the y-axis ticks must still be
@@ -106,7 +135,7 @@ func TestRenderGraph_ConfigTable(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			rg, err := resolveGraph(config.Graph{ID: "g", Query: "q", ValueExpr: tc.valueExpr}, config.Defaults{}, env)
+			rg, err := resolveGraph(config.Graph{ID: "g", Query: "q", ValueExpr: tc.valueExpr}, config.Defaults{}, nil, env)
 			require.NoError(t, err)
 			svg, err := renderChart(makeMatrix(tc.data), rg.defaults)
 			require.NoError(t, err)
@@ -161,6 +190,74 @@ func TestRenderChart_MarkLine(t *testing.T) {
 	assert.NotContains(t, string(plain), "stroke-dasharray", "no mark line without markLines")
 }
 
+func TestRenderChart_MarkLinePerSeries(t *testing.T) {
+	t.Parallel()
+	data := makeMatrix([][]float64{{10, 20, 30}, {40, 50, 60}})
+	cases := []struct {
+		name  string
+		chart string
+		match map[string]string
+		want  int // dashed mark lines drawn
+	}{
+		{"every series", config.ChartLine, nil, 2},
+		{"every bar series", config.ChartBar, nil, 2},
+		{"matched series", config.ChartLine, map[string]string{"series": "s1"}, 1},
+		{"no match", config.ChartLine, map[string]string{"series": "s9"}, 0},
+		{"stacked total", config.ChartStackedArea, nil, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			svg, err := renderChart(data, chartParams{width: 400, height: 150, format: formatSVG,
+				chart: tc.chart, markLines: []string{"average"}, markMatch: tc.match})
+			require.NoError(t, err)
+			// Each mark line is a dashed line plus a dashed arrowhead.
+			assert.Equal(t, 2*tc.want, strings.Count(string(svg), "stroke-dasharray"))
+		})
+	}
+}
+
+func TestRenderChart_Types(t *testing.T) {
+	t.Parallel()
+	data := [][]float64{{10, 25, 15, 40, 30}, {5, 6, 7, 8, 9}, {1, 2, 3, 4, 50}}
+	pods := func(f float64) string { return fmt.Sprintf("%dpods", int(f)) }
+	cases := []struct {
+		chart string
+		want  []string // substrings that must appear in the rendered SVG
+	}{
+		{config.ChartLine, []string{">s0</text>", "pods</text>"}},
+		{config.ChartStackedArea, []string{"fill:rgba", "pods</text>"}},
+		{config.ChartBar, []string{">s2</text>", "pods</text>"}},
+		// Categorical types plot each series' latest value: 30, 9, and 50.
+		{config.ChartHorizontalBar, []string{">s0</text>", ">s2</text>", "pods</text>"}},
+		{config.ChartPie, []string{">s0: 30pods</text>", ">s2: 50pods</text>"}},
+		{config.ChartDonut, []string{">s1: 9pods</text>", ">89pods</text>"}}, // center sum
+	}
+	for _, tc := range cases {
+		t.Run(tc.chart, func(t *testing.T) {
+			t.Parallel()
+			svg, err := renderChart(makeMatrix(data), chartParams{
+				width: 600, height: 300, legend: true, format: formatSVG, chart: tc.chart, valueFormatter: pods,
+			})
+			require.NoError(t, err)
+			for _, w := range tc.want {
+				assert.Contains(t, string(svg), w)
+			}
+		})
+	}
+}
+
+func TestCategories(t *testing.T) {
+	t.Parallel()
+	matrix := makeMatrix([][]float64{{1, 2}, {3, math.NaN()}, {math.NaN()}})
+	matrix[0].Metric = model.Metric{"ns": "<b>"}
+	values, names, metrics := chartParams{}.categories(matrix)
+	// Latest finite value per series, sorted by name and escaped; the all-NaN series is dropped.
+	assert.Equal(t, []float64{2, 3}, values)
+	assert.Equal(t, []string{"&lt;b&gt;", "s1"}, names)
+	assert.Equal(t, []model.Metric{{"ns": "<b>"}, {"series": "s1"}}, metrics)
+}
+
 func TestRenderChart_PNG(t *testing.T) {
 	t.Parallel()
 	png, err := renderChart(makeMatrix([][]float64{{10, 25, 15, 40, 30}}),
@@ -171,6 +268,36 @@ func TestRenderChart_PNG(t *testing.T) {
 	assert.Equal(t, []byte{0x89, 'P', 'N', 'G'}, png[:4])
 }
 
+func TestRenderChart_PNGOverlays(t *testing.T) {
+	t.Parallel()
+	matrix := makeMatrix([][]float64{{10, 25, 15, 40, 30}})
+	plain, err := renderChart(matrix, chartParams{width: 400, height: 150, format: formatPNG})
+	require.NoError(t, err)
+	// They're placed by an SVG probe, which a PNG's text would be laid out off from.
+	drawn, err := renderChart(matrix, chartParams{width: 400, height: 150, format: formatPNG,
+		thresholds:  []threshold{{value: 20, color: charts.ColorRed, label: "warn"}},
+		annotations: []annotationEvent{{start: model.Time(60 * 1000), text: "deploy", color: charts.ColorRed}},
+		alignTicks:  true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, plain, drawn, "a PNG is the library's chart alone")
+}
+
+func TestRenderChart_LayoutFailureWarns(t *testing.T) {
+	t.Parallel()
+	matrix := makeMatrix([][]float64{{10, 25, 15}})
+	matrix[0].Metric = model.Metric{queryLabel: "fan"}
+	var buf bytes.Buffer
+	svg, err := renderChart(matrix, chartParams{width: 400, height: 150, format: formatSVG,
+		rightQueries: map[string]bool{"fan": true},
+		annotations:  []annotationEvent{{start: model.Time(60 * 1000), text: "deploy", color: charts.ColorRed}},
+		log:          slog.New(slog.NewTextHandler(&buf, nil)),
+	})
+	require.NoError(t, err, "a chart with nothing on the left axis to place markers by still renders")
+	assert.NotContains(t, string(svg), ">deploy</text>")
+	assert.Contains(t, buf.String(), "chart overlays dropped")
+}
+
 func TestRenderChart_NaNAndInfBecomeGaps(t *testing.T) {
 	t.Parallel()
 	// Non-finite samples must not produce a broken image or literal NaN/Inf text.
@@ -184,6 +311,76 @@ func TestRenderChart_NaNAndInfBecomeGaps(t *testing.T) {
 	assert.NotContains(t, string(svg), "Inf")
 }
 
+func TestTimeSeries_AlignsOnGrid(t *testing.T) {
+	t.Parallel()
+	null := charts.GetNullValue()
+	start := time.Unix(600, 0)
+	at := func(min int, v float64) model.SamplePair {
+		return model.SamplePair{Timestamp: model.TimeFromUnix(start.Unix() + int64(min*60)), Value: model.SampleValue(v)}
+	}
+	// s0 is missing the second step; s1 starts late, and its NaN is a gap too.
+	matrix := model.Matrix{
+		{Metric: model.Metric{"series": "s0"}, Values: []model.SamplePair{at(0, 1), at(2, 3), at(3, 4)}},
+		{Metric: model.Metric{"series": "s1"}, Values: []model.SamplePair{at(2, 5), at(3, math.NaN())}},
+	}
+	p := chartParams{start: start, end: start.Add(3 * time.Minute), step: time.Minute}
+
+	values, _, _, grid := p.timeSeries(matrix)
+	assert.Len(t, grid, 4, "one point per step from start to end")
+	assert.Equal(t, [][]float64{{1, null, 3, 4}, {null, null, 5, null}}, values)
+
+	// A sample a millisecond off the step still lands on it.
+	matrix[1].Values[0].Timestamp++
+	values, _, _, _ = p.timeSeries(matrix)
+	assert.InDelta(t, 5, values[1][2], 0)
+
+	// Without a step the grid is every timestamp any series has.
+	values, _, _, grid = chartParams{}.timeSeries(matrix[:1])
+	assert.Len(t, grid, 3)
+	assert.Equal(t, [][]float64{{1, 3, 4}}, values)
+
+	// nullMode fills the gaps.
+	p.nullMode = config.NullZero
+	values, _, _, _ = p.timeSeries(matrix)
+	assert.Equal(t, [][]float64{{1, 0, 3, 4}, {0, 0, 5, 0}}, values)
+}
+
+func TestFillNulls(t *testing.T) {
+	t.Parallel()
+	null := charts.GetNullValue()
+	row := func() []float64 { return []float64{null, 1, null, null, 4, null} }
+
+	assert.Equal(t, row(), chartParams{}.fillNulls(row()), "gap is the default")
+	assert.Equal(t, []float64{0, 1, 0, 0, 4, 0}, chartParams{nullMode: config.NullZero}.fillNulls(row()))
+	assert.Equal(t, []float64{null, 1, 2, 3, 4, null}, chartParams{nullMode: config.NullConnect}.fillNulls(row()),
+		"interpolated between values; the ends stay gaps")
+	assert.Equal(t, row(), chartParams{nullMode: config.NullConnect, chart: config.ChartBar}.fillNulls(row()),
+		"bars don't invent values")
+}
+
+func TestRenderChart_NoData(t *testing.T) {
+	t.Parallel()
+	allNaN := makeMatrix([][]float64{{math.NaN(), math.NaN()}})
+	for _, chart := range []string{config.ChartLine, config.ChartStackedArea, config.ChartBar, config.ChartHorizontalBar, config.ChartPie, config.ChartDonut} {
+		for name, matrix := range map[string]model.Matrix{"empty": nil, "no samples": {{}}, "all NaN": allNaN} {
+			t.Run(chart+"/"+name, func(t *testing.T) {
+				t.Parallel()
+				svg, err := renderChart(matrix, chartParams{width: 400, height: 150, format: formatSVG, chart: chart, theme: "dracula", title: "A & B"})
+				require.NoError(t, err)
+				out := string(svg)
+				assert.Contains(t, out, `<svg width="400" height="150"`)
+				assert.Contains(t, out, ">"+noDataText+"</text>")
+				assert.Contains(t, out, ">A &amp; B</text>", "titled, and escaped")
+				assert.Contains(t, out, "rgb(40,42,54)", "themed background")
+			})
+		}
+	}
+
+	png, err := renderChart(nil, chartParams{width: 400, height: 150, format: formatPNG})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), png[:4])
+}
+
 func TestRenderChart_Theme(t *testing.T) {
 	t.Parallel()
 	// A custom theme's background color should appear in the rendered SVG.
@@ -194,16 +391,59 @@ func TestRenderChart_Theme(t *testing.T) {
 	assert.Contains(t, string(svg), "rgb(40,42,54)", "dracula background should be present")
 }
 
+func TestRenderChart_ConfigTheme(t *testing.T) {
+	t.Parallel()
+	themes, err := resolveThemes(map[string]config.Theme{"paper": testTheme})
+	require.NoError(t, err)
+	matrix := makeMatrix([][]float64{{1, 2, 3}})
+	svg, err := renderChart(matrix, chartParams{width: 400, height: 150, theme: "paper", themes: themes, format: formatSVG})
+	require.NoError(t, err)
+	assert.Contains(t, string(svg), "rgb(253,246,227)", "paper background should be present")
+	// The series is hashed onto one of paper's two colors.
+	out := string(svg)
+	assert.True(t, strings.Contains(out, "stroke:rgb(0,126,198)") || strings.Contains(out, "stroke:rgb(220,50,47)"))
+}
+
 func TestSeriesLabel(t *testing.T) {
 	t.Parallel()
-	stream := &model.SampleStream{Metric: model.Metric{
-		"__name__": "x", "instance": "node-1", "job": "kube",
-	}}
+	var p chartParams
+	metric := model.Metric{"__name__": "x", "instance": "node-1", "job": "kube"}
 	// Sorted by key (instance, job); __name__ excluded.
-	assert.Equal(t, "node-1, kube", seriesLabel(stream))
+	assert.Equal(t, "node-1, kube", p.seriesLabel(metric))
+	assert.Empty(t, p.seriesLabel(model.Metric{"__name__": "x"}))
+
+	// A multi-query graph's series lead with their query's name.
+	assert.Equal(t, "errors (500)", p.seriesLabel(model.Metric{queryLabel: "errors", "code": "500"}))
+	assert.Equal(t, "errors", p.seriesLabel(model.Metric{queryLabel: "errors"}))
+	// A compare series is followed by its period.
+	assert.Equal(t, "errors (500) (last week)", p.seriesLabel(model.Metric{queryLabel: "errors", "code": "500", compareLabel: "last week"}))
+	assert.Equal(t, "last week", p.seriesLabel(model.Metric{compareLabel: "last week"}))
 
-	bare := &model.SampleStream{Metric: model.Metric{"__name__": "x"}}
-	assert.Empty(t, seriesLabel(bare))
+	// A legendExpr names the series from its labels instead.
+	env, err := newCELEnv()
+	require.NoError(t, err)
+	p.legendExpr, err = compileStringExpr(env, "g", "legend", `labels.instance + " (" + labels.job + ")"`)
+	require.NoError(t, err)
+	assert.Equal(t, "node-1 (kube)", p.seriesLabel(metric))
+	assert.Equal(t, "errors (node-1 (kube))", p.seriesLabel(model.Metric{queryLabel: "errors", "instance": "node-1", "job": "kube"}),
+		"__query__ is not among the labels")
+	// A runtime error (here, a missing label) falls back to the joined values.
+	assert.Equal(t, "node-1", p.seriesLabel(model.Metric{"instance": "node-1"}))
+}
+
+func TestRenderChart_LegendExpr(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+	prog, err := compileStringExpr(env, "g", "legend", `"<" + labels.series + ">"`)
+	require.NoError(t, err)
+	for _, chart := range []string{config.ChartLine, config.ChartPie} {
+		svg, err := renderChart(makeMatrix([][]float64{{1, 2, 3}}),
+			chartParams{width: 400, height: 150, legend: true, format: formatSVG, chart: chart, legendExpr: prog})
+		require.NoError(t, err)
+		assert.Contains(t, string(svg), "&lt;s0&gt;", "%s legend is named by legendExpr and escaped", chart)
+		assert.NotContains(t, string(svg), "<s0>")
+	}
 }
 
 func TestChartParams_WithOverrides(t *testing.T) {
@@ -211,24 +451,54 @@ func TestChartParams_WithOverrides(t *testing.T) {
 	base := chartParams{width: 300, height: 80, legend: true, theme: "dark", format: formatSVG}
 
 	req := httptest.NewRequest(http.MethodGet,
-		"/?width=500&height=250&legend=false&fill=true&ymin=0&ymax=100&theme=dracula&format=png", nil)
+		"/?width=500&height=250&legend=false&fill=true&tooltips=true&alignticks=true&ymin=0&ymax=100&theme=dracula&themedark=nord&chart=pie&nullmode=zero&tz=Asia/Tokyo&compare=7d&format=png", nil)
 	got := base.withOverrides(req)
 
 	assert.Equal(t, 500, got.width)
 	assert.Equal(t, 250, got.height)
 	assert.False(t, got.legend)
 	assert.True(t, got.fill)
+	assert.True(t, got.tooltips)
+	assert.True(t, got.alignTicks)
 	require.NotNil(t, got.yMin)
 	assert.Equal(t, 0.0, *got.yMin)
 	require.NotNil(t, got.yMax)
 	assert.Equal(t, 100.0, *got.yMax)
 	assert.Equal(t, "dracula", got.theme)
+	assert.Equal(t, "nord", got.themeDark)
+	assert.False(t, got.legendTable)
+	assert.Equal(t, config.ChartPie, got.chart)
+	assert.Equal(t, config.NullZero, got.nullMode)
+	assert.Equal(t, "Asia/Tokyo", got.timeZone().String())
+	assert.Equal(t, 7*24*time.Hour, got.compare)
 	assert.Equal(t, formatPNG, got.format)
 	assert.Equal(t, "image/png", got.contentType())
 
-	// Width is clamped to the maximum.
-	clamped := base.withOverrides(httptest.NewRequest(http.MethodGet, "/?width=99999", nil))
+	// Width is clamped to the maximum; an unknown chart type, time zone, or compare
+	// offset is ignored.
+	base.compare = time.Hour
+	clamped := base.withOverrides(httptest.NewRequest(http.MethodGet, "/?width=99999&chart=radar&tz=Mars/Olympus&compare=-1d", nil))
 	assert.Equal(t, maxChartDimension, clamped.width)
+	assert.Empty(t, clamped.chart)
+	assert.Equal(t, time.UTC, clamped.timeZone())
+	assert.Empty(t, clamped.mode)
+	assert.Equal(t, time.Hour, clamped.compare)
+	assert.Zero(t, base.withOverrides(httptest.NewRequest(http.MethodGet, "/?compare=0", nil)).compare, "0 turns it off")
+
+	// ?legend=table swaps the legend for a table, and true swaps it back.
+	table := base.withOverrides(httptest.NewRequest(http.MethodGet, "/?legend=table", nil))
+	assert.True(t, table.legendTable)
+	assert.False(t, table.legend)
+	shown := table.withOverrides(httptest.NewRequest(http.MethodGet, "/?legend=true", nil))
+	assert.False(t, shown.legendTable)
+	assert.True(t, shown.legend)
+
+	// In sparkline mode the size overrides set the sparkline's own.
+	base.sparkline = sparklineParams{width: 100, height: 20}
+	spark := base.withOverrides(httptest.NewRequest(http.MethodGet, "/?mode=sparkline&width=160", nil))
+	assert.Equal(t, config.ModeSparkline, spark.mode)
+	assert.Equal(t, sparklineParams{width: 160, height: 20}, spark.sparkline)
+	assert.Equal(t, 300, spark.width)
 }
 
 func TestResolveGraphFont(t *testing.T) {
@@ -275,7 +545,40 @@ func TestValidTheme(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.theme, func(t *testing.T) {
 			t.Parallel()
-			assert.Equal(t, tc.want, validTheme(tc.theme))
+			assert.Equal(t, tc.want, validTheme(tc.theme, nil))
 		})
 	}
 }
+
+// testTheme is a valid config theme: a light "paper" with two series colors.
+var testTheme = config.Theme{Background: "#fdf6e3", Text: "#586e75", Axis: "#93a1a1", SplitLine: "#eee8d5", Series: []string{"blue", "#dc322f"}}
+
+func TestResolveThemes(t *testing.T) {
+	t.Parallel()
+	themes, err := resolveThemes(map[string]config.Theme{"paper": testTheme})
+	require.NoError(t, err)
+	assert.True(t, validTheme("paper", themes))
+	assert.False(t, validTheme("paper", nil))
+	theme := chartTheme("paper", themes)
+	assert.Equal(t, charts.ColorFromHex("#fdf6e3"), theme.GetBackgroundColor())
+	assert.Equal(t, charts.ColorFromHex(badgeColors["blue"]), theme.GetSeriesColor(0))
+	assert.Equal(t, charts.ColorFromHex("#dc322f"), theme.GetSeriesColor(1))
+	// A config theme doesn't change the built-ins.
+	assert.Equal(t, chartTheme("dracula", nil), chartTheme("dracula", themes))
+
+	bad := testTheme
+	bad.Series = []string{"blue", "nope"}
+	for _, tc := range []struct {
+		name  string
+		theme config.Theme
+		want  string
+	}{
+		{"dracula", testTheme, `theme "dracula": already a built-in theme`},
+		{"dark", testTheme, `theme "dark": already a built-in theme`},
+		{"paper", bad, `theme "paper" series[1]: unknown color "nope"`},
+	} {
+		_, err := resolveThemes(map[string]config.Theme{tc.name: tc.theme})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+}
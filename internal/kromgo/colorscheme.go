@@ -0,0 +1,54 @@
+package kromgo
+
+import "bytes"
+
+// A graph with a dark theme (themeDark) renders its SVG twice, in its theme and in
+// the dark one, and carries both: an inline stylesheet shows the one matching the
+// viewer's prefers-color-scheme, so a README image suits GitHub's light and dark
+// modes alike. The stylesheet is a <style> element, which the CSP's
+// style-src 'unsafe-inline' allows; PNGs have no media queries and keep the theme.
+// Badges switch only their label color, in render.
+
+// schemeStyle shows the light rendering by default and the dark one under a dark
+// color scheme. The classes aren't namespaced: every kromgo SVG uses the same rule.
+const schemeStyle = `<style>.kromgo-dark{display:none}@media (prefers-color-scheme: dark){.kromgo-light{display:none}.kromgo-dark{display:inline}}</style>`
+
+// renderSchemes renders a graph image with render and, for an SVG with a dark theme,
+// again in that theme, merging the two into one scheme-switching SVG.
+func renderSchemes(p chartParams, render func(chartParams) ([]byte, error)) ([]byte, error) {
+	light, err := render(p)
+	if err != nil || p.themeDark == "" || p.format == formatPNG {
+		return light, err
+	}
+	p.theme = p.themeDark
+	dark, err := render(p)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSchemes(light, dark), nil
+}
+
+// mergeSchemes nests the light and dark SVGs' contents in one SVG, under the light
+// one's root element (both share the size), with schemeStyle choosing between them.
+func mergeSchemes(light, dark []byte) []byte {
+	root, lightBody := svgContent(light)
+	_, darkBody := svgContent(dark)
+	var b bytes.Buffer
+	b.Grow(len(light) + len(dark) + len(schemeStyle) + 64)
+	b.Write(root)
+	b.WriteString(schemeStyle)
+	b.WriteString(`<g class="kromgo-light">`)
+	b.Write(lightBody)
+	b.WriteString(`</g><g class="kromgo-dark">`)
+	b.Write(darkBody)
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
+
+// svgContent splits an SVG into its root start tag and the content inside it,
+// dropping anything before the root (an XML prolog or a comment).
+func svgContent(svg []byte) (root, content []byte) {
+	start := max(bytes.Index(svg, []byte("<svg")), 0)
+	i := start + bytes.IndexByte(svg[start:], '>') + 1
+	return svg[start:i], bytes.TrimSuffix(bytes.TrimSpace(svg[i:]), []byte("</svg>"))
+}
@@ -0,0 +1,55 @@
+package kromgo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeSchemes(t *testing.T) {
+	t.Parallel()
+	light := []byte(`<svg width="4" height="2" viewBox="0 0 4 2"><path d="L"/></svg>`)
+	dark := []byte(`<svg width="4" height="2" viewBox="0 0 4 2"><path d="D"/></svg>` + "\n")
+	assert.Equal(t, `<svg width="4" height="2" viewBox="0 0 4 2">`+schemeStyle+
+		`<g class="kromgo-light"><path d="L"/></g><g class="kromgo-dark"><path d="D"/></g></svg>`,
+		string(mergeSchemes(light, dark)))
+
+	// A prolog or comment before the root is dropped rather than taken for it.
+	prolog := []byte(`<?xml version="1.0" encoding="UTF-8"?><!-- chart --><svg width="4" height="2" viewBox="0 0 4 2"><path d="D"/></svg>`)
+	assert.Equal(t, `<svg width="4" height="2" viewBox="0 0 4 2">`+schemeStyle+
+		`<g class="kromgo-light"><path d="D"/></g><g class="kromgo-dark"><path d="L"/></g></svg>`,
+		string(mergeSchemes(prolog, light)))
+}
+
+func TestRenderSchemes(t *testing.T) {
+	t.Parallel()
+	matrix := makeMatrix([][]float64{{1, 2, 3}})
+	chart := func(p chartParams) ([]byte, error) { return renderChart(matrix, p) }
+	p := chartParams{width: 400, height: 150, theme: "light", themeDark: "dracula", format: formatSVG}
+
+	svg, err := renderSchemes(p, chart)
+	require.NoError(t, err)
+	out := string(svg)
+	assert.Equal(t, 1, strings.Count(out, "<svg "), "one root element")
+	assert.True(t, strings.HasSuffix(out, "</g></svg>"))
+	light, dark, ok := strings.Cut(out, `<g class="kromgo-dark">`)
+	require.True(t, ok)
+	assert.Contains(t, light, schemeStyle)
+	assert.NotContains(t, light, "rgb(40,42,54)", "the light rendering isn't dracula")
+	assert.Contains(t, dark, "rgb(40,42,54)", "the dark rendering is dracula")
+
+	// No dark theme, or a PNG, renders once in the theme.
+	for _, q := range []chartParams{
+		{width: 400, height: 150, theme: "light", format: formatSVG},
+		{width: 400, height: 150, theme: "light", themeDark: "dracula", format: formatPNG},
+	} {
+		want, err := chart(q)
+		require.NoError(t, err)
+		got, err := renderSchemes(q, chart)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(want, got))
+	}
+}
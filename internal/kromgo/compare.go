@@ -0,0 +1,228 @@
+package kromgo
+
+import (
+	"html"
+	"math"
+	"time"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// A graph's compare overlay runs its queries again over the window shifted back by an
+// offset (e.g. a week) and re-stamps the results onto the current window, so each
+// series can be drawn against its own past. The shifted series travel with the
+// current ones, tagged with compareLabel; kromgo draws them over the finished chart
+// as dashed, lighter lines in their current series' colors, in the plot locatePlot
+// measures, and names them in the legend.
+
+// compareLabel tags a series from a compare query with its period's name ("last
+// week"). Like queryLabel it never reaches the JSON labels; the legend shows its value.
+const compareLabel model.LabelName = "__compare__"
+
+const (
+	// compareOpacity is the alpha (0-255) of a compare line's color — lighter than the
+	// series it shadows, so the current data stays in front.
+	compareOpacity    = 140
+	compareLineWidth  = 1.5
+	compareDashLength = 5
+)
+
+// comparePeriod names the period a compare offset reaches back to: "yesterday", "last
+// week", or "<offset> ago".
+func comparePeriod(offset time.Duration) string {
+	switch offset {
+	case 24 * time.Hour:
+		return "yesterday"
+	case 7 * 24 * time.Hour:
+		return "last week"
+	}
+	return model.Duration(offset).String() + " ago"
+}
+
+// restamp moves a compare query's samples forward by the offset onto the current
+// window and tags its series with the period's compareLabel.
+func restamp(matrix model.Matrix, offset time.Duration) {
+	period := model.LabelValue(comparePeriod(offset))
+	for _, stream := range matrix {
+		stream.Metric = stream.Metric.Clone()
+		stream.Metric[compareLabel] = period
+		for i := range stream.Values {
+			stream.Values[i].Timestamp = stream.Values[i].Timestamp.Add(offset)
+		}
+		for i := range stream.Histograms {
+			stream.Histograms[i].Timestamp = stream.Histograms[i].Timestamp.Add(offset)
+		}
+	}
+}
+
+// splitCompare separates the compare series from the current ones, keeping the order
+// of each.
+func splitCompare(matrix model.Matrix) (current, previous model.Matrix) {
+	for _, stream := range matrix {
+		if _, ok := stream.Metric[compareLabel]; ok {
+			previous = append(previous, stream)
+		} else {
+			current = append(current, stream)
+		}
+	}
+	return current, previous
+}
+
+// shadowing pairs the compare series with the current series they repeat (the same
+// labels, but for the compare tag). It returns them in the current series' order,
+// with the index of the one each shadows; a compare series whose current series was
+// filtered, limited, or capped away is dropped.
+func shadowing(current, previous model.Matrix) (model.Matrix, []int) {
+	byLabels := make(map[model.Fingerprint]*model.SampleStream, len(previous))
+	for _, stream := range previous {
+		metric := stream.Metric.Clone()
+		delete(metric, compareLabel)
+		byLabels[metric.Fingerprint()] = stream
+	}
+	var out model.Matrix
+	var of []int
+	for i, stream := range current {
+		if prev, ok := byLabels[stream.Metric.Fingerprint()]; ok {
+			out = append(out, prev)
+			of = append(of, i)
+		}
+	}
+	return out, of
+}
+
+// compareOverlay is the compare series prepared for drawing: their values on their
+// own time grid, which is placed on the chart's by timestamp.
+type compareOverlay struct {
+	grid  []model.Time
+	lines []compareLine
+}
+
+// compareLine is one compare series: its (escaped) legend name, lighter color, and
+// the values drawn, a running total on a stacked chart.
+type compareLine struct {
+	name   string
+	color  charts.Color
+	values []float64
+}
+
+// compareOverlay prepares the compare series shadowing the current ones. On a chart
+// it leaves off the right axis' series (the overlay is drawn to the left axis'
+// scale) and, on a stacked-area chart, stacks them as the current series are: in the
+// current series' order, a current series with no compare series (nothing in the
+// shifted window) adding zero to the total beneath the ones above it.
+func (p chartParams) compareOverlay(current, previous model.Matrix, chart bool) compareOverlay {
+	previous, of := shadowing(current, previous)
+	if chart {
+		var left model.Matrix
+		var leftOf []int
+		for k, stream := range previous {
+			if !p.rightAxis(stream.Metric) {
+				left, leftOf = append(left, stream), append(leftOf, of[k])
+			}
+		}
+		previous, of = left, leftOf
+	}
+	if len(previous) == 0 {
+		return compareOverlay{}
+	}
+	values, _, _, grid := p.timeSeries(previous)
+	if chart && p.chart == config.ChartStackedArea {
+		stackRows(values)
+	}
+	palette := p.seriesPalette(streamMetrics(current))
+	overlay := compareOverlay{grid: grid}
+	for k, stream := range previous {
+		overlay.lines = append(overlay.lines, compareLine{
+			name:   html.EscapeString(p.seriesLabel(stream.Metric)),
+			color:  palette.GetSeriesColor(of[k]).WithAlpha(compareOpacity),
+			values: values[k],
+		})
+	}
+	if lo, hi := valueExtent(overlay.rows(), false); lo > hi {
+		return compareOverlay{} // nothing finite to draw
+	}
+	return overlay
+}
+
+// stackRows turns the rows into the running totals a stacked chart plots, a null
+// adding nothing to the total.
+func stackRows(values [][]float64) {
+	null := charts.GetNullValue()
+	var totals []float64
+	for _, row := range values {
+		for len(totals) < len(row) {
+			totals = append(totals, 0)
+		}
+		for j, v := range row {
+			if v != null {
+				totals[j] += v
+				row[j] = totals[j]
+			}
+		}
+	}
+}
+
+// rows is the overlay's values, a row per line.
+func (o compareOverlay) rows() [][]float64 {
+	rows := make([][]float64, len(o.lines))
+	for i, l := range o.lines {
+		rows[i] = l.values
+	}
+	return rows
+}
+
+// names is the overlay's legend names, in line order.
+func (o compareOverlay) names() []string {
+	names := make([]string, len(o.lines))
+	for i, l := range o.lines {
+		names[i] = l.name
+	}
+	return names
+}
+
+// withCompareColors extends a chart's palette past its n series with the compare
+// lines' colors, for their legend entries.
+func (p chartParams) withCompareColors(palette charts.ColorPalette, n int) charts.ColorPalette {
+	if len(p.overlay.lines) == 0 {
+		return palette
+	}
+	colors := make([]charts.Color, n, n+len(p.overlay.lines))
+	for i := range colors {
+		colors[i] = palette.GetSeriesColor(i)
+	}
+	for _, l := range p.overlay.lines {
+		colors = append(colors, l.color)
+	}
+	return palette.WithSeriesColors(colors)
+}
+
+// drawCompare paints the compare lines onto a rendered chart, dashed, at their
+// timestamps' places on the chart's grid.
+func drawCompare(painter *charts.Painter, plot plotArea, p chartParams, grid []model.Time) {
+	if len(grid) < 2 {
+		return
+	}
+	x := make([]int, len(p.overlay.grid))
+	for j, ts := range p.overlay.grid {
+		x[j] = plot.left + int(math.Round(p.plotFraction(grid, ts.Time())*float64(plot.right-plot.left)))
+	}
+	for _, l := range p.overlay.lines {
+		painter.DashedLineStroke(comparePoints(l.values, x, plot.y), l.color,
+			compareLineWidth, []float64{compareDashLength, compareDashLength})
+	}
+}
+
+// comparePoints places a compare line's values, a null as a gap.
+func comparePoints(values []float64, x []int, y func(float64) int) []charts.Point {
+	null := charts.GetNullValue()
+	points := make([]charts.Point, len(values))
+	for j, v := range values {
+		points[j] = charts.Point{X: x[j], Y: math.MaxInt32} // a gap, to DashedLineStroke
+		if v != null {
+			points[j].Y = y(v)
+		}
+	}
+	return points
+}
@@ -0,0 +1,126 @@
+package kromgo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withCompare appends a compare copy of each series, its values scaled by factor.
+func withCompare(matrix model.Matrix, period string, factor float64) model.Matrix {
+	out := append(model.Matrix{}, matrix...)
+	for _, stream := range matrix {
+		prev := &model.SampleStream{Metric: stream.Metric.Clone()}
+		prev.Metric[compareLabel] = model.LabelValue(period)
+		for _, pt := range stream.Values {
+			prev.Values = append(prev.Values, model.SamplePair{Timestamp: pt.Timestamp, Value: pt.Value * model.SampleValue(factor)})
+		}
+		out = append(out, prev)
+	}
+	return out
+}
+
+func TestComparePeriod(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "yesterday", comparePeriod(24*time.Hour))
+	assert.Equal(t, "last week", comparePeriod(7*24*time.Hour))
+	assert.Equal(t, "1h ago", comparePeriod(time.Hour))
+	assert.Equal(t, "4w ago", comparePeriod(28*24*time.Hour))
+}
+
+func TestRestamp(t *testing.T) {
+	t.Parallel()
+	matrix := model.Matrix{{
+		Metric:     model.Metric{"pod": "a"},
+		Values:     []model.SamplePair{{Timestamp: 0, Value: 1}},
+		Histograms: []model.SampleHistogramPair{{Timestamp: 60_000}},
+	}}
+	original := matrix[0].Metric
+	restamp(matrix, 7*24*time.Hour)
+	assert.Equal(t, model.Metric{"pod": "a", compareLabel: "last week"}, matrix[0].Metric)
+	assert.Equal(t, model.Metric{"pod": "a"}, original, "the labels are copied, not changed in place")
+	assert.Equal(t, model.Time(7*24*time.Hour/time.Millisecond), matrix[0].Values[0].Timestamp)
+	assert.Equal(t, model.Time(7*24*time.Hour/time.Millisecond)+60_000, matrix[0].Histograms[0].Timestamp)
+}
+
+func TestSplitCompare(t *testing.T) {
+	t.Parallel()
+	matrix := withCompare(makeMatrix([][]float64{{1}, {2}, {3}}), "last week", 1)
+	current, previous := splitCompare(matrix)
+	require.Len(t, current, 3)
+	require.Len(t, previous, 3)
+
+	// Only series still current are shadowed, in the current order.
+	current = model.Matrix{current[2], current[0]}
+	shadows, of := shadowing(current, previous)
+	require.Len(t, shadows, 2)
+	assert.Equal(t, model.LabelValue("s2"), shadows[0].Metric["series"])
+	assert.Equal(t, model.LabelValue("s0"), shadows[1].Metric["series"])
+	assert.Equal(t, []int{0, 1}, of)
+}
+
+func TestCompareOverlay(t *testing.T) {
+	t.Parallel()
+	current, previous := splitCompare(withCompare(makeMatrix([][]float64{{1, 2}, {3, 4}}), "last week", 2))
+	p := chartParams{}
+
+	overlay := p.compareOverlay(current, previous, true)
+	require.Len(t, overlay.lines, 2)
+	assert.Equal(t, []string{"s0 (last week)", "s1 (last week)"}, overlay.names())
+	assert.Equal(t, [][]float64{{2, 4}, {6, 8}}, overlay.rows())
+	palette := p.seriesPalette(streamMetrics(current))
+	assert.Equal(t, palette.GetSeriesColor(1).WithAlpha(compareOpacity), overlay.lines[1].color,
+		"the current series' color, lighter")
+
+	p.chart = config.ChartStackedArea
+	assert.Equal(t, [][]float64{{2, 4}, {8, 12}}, p.compareOverlay(current, previous, true).rows(), "stacked like the chart")
+	assert.Equal(t, [][]float64{{2, 4}, {6, 8}}, p.compareOverlay(current, previous, false).rows(), "a sparkline doesn't stack")
+	assert.Equal(t, [][]float64{{6, 8}}, p.compareOverlay(current, previous[1:], true).rows(),
+		"s0 has no past, so s1's stacks on zero")
+
+	p = chartParams{rightQueries: map[string]bool{"b": true}}
+	current[1].Metric = model.Metric{queryLabel: "b"}
+	previous[1].Metric = model.Metric{queryLabel: "b", compareLabel: "last week"}
+	assert.Len(t, p.compareOverlay(current, previous, true).lines, 1, "the right axis' series are left off")
+
+	assert.Empty(t, p.compareOverlay(current, nil, true).lines)
+}
+
+func TestRenderChart_Compare(t *testing.T) {
+	t.Parallel()
+	matrix := withCompare(makeMatrix([][]float64{{10, 25, 15, 40, 30}}), "last week", 2)
+	p := chartParams{width: 600, height: 200, legend: true, format: formatSVG}
+	color := p.seriesPalette(streamMetrics(matrix[:1])).GetSeriesColor(0).WithAlpha(compareOpacity)
+	stroke := fmt.Sprintf("stroke:rgba(%d,%d,%d,", color.R, color.G, color.B)
+	for _, chart := range []string{config.ChartLine, config.ChartStackedArea, config.ChartBar} {
+		p.chart = chart
+		svg, err := renderChart(matrix, p)
+		require.NoError(t, err)
+		out := string(svg)
+		assert.Contains(t, out, ">s0 (last week)</text>", "%s: named in the legend", chart)
+		assert.Contains(t, out, "stroke-dasharray", "%s: dashed", chart)
+		assert.Contains(t, out, stroke, "%s: in the series' color, lighter", chart)
+		assert.Contains(t, out, ">80</text>", "%s: the y-axis covers the compare series", chart)
+	}
+
+	p.chart = config.ChartPie
+	svg, err := renderChart(matrix, p)
+	require.NoError(t, err)
+	assert.NotContains(t, string(svg), "last week", "a pie shows only the current values")
+}
+
+func TestRenderSparkline_Compare(t *testing.T) {
+	t.Parallel()
+	matrix := withCompare(makeMatrix([][]float64{{10, 20, 30}}), "yesterday", 2)
+	svg, err := renderSparkline(matrix, chartParams{format: formatSVG, sparkline: sparklineParams{width: 100, height: 20}})
+	require.NoError(t, err)
+	out := string(svg)
+	assert.Equal(t, 1, strings.Count(out, "stroke-dasharray"), "the compare series is dashed")
+	assert.Contains(t, out, `d="M 2 18`, "the current line starts at the bottom of the shared range")
+}
@@ -0,0 +1,220 @@
+package kromgo
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// Downsampling thins a long window to about one point per pixel before it's drawn,
+// keeping its shape: LTTB (Largest-Triangle-Three-Buckets) keeps the point in each
+// bucket that best preserves the line's visual area, and min/max keeps each bucket's
+// extremes, so no peak or trough is lost. Missing points are NaN throughout.
+
+// bucket is a half-open range [start, end) of point indices.
+type bucket struct{ start, end int }
+
+// lttbBuckets splits n points into target buckets for LTTB: the first and last
+// points alone, and the rest spread evenly across the remaining target-2.
+func lttbBuckets(n, target int) []bucket {
+	if target >= n || target < 3 {
+		out := make([]bucket, n)
+		for i := range out {
+			out[i] = bucket{i, i + 1}
+		}
+		return out
+	}
+	out := make([]bucket, 0, target)
+	out = append(out, bucket{0, 1})
+	inner := float64(n-2) / float64(target-2)
+	for b := range target - 2 {
+		out = append(out, bucket{1 + int(float64(b)*inner), 1 + int(float64(b+1)*inner)})
+	}
+	return append(out, bucket{n - 1, n})
+}
+
+// evenBuckets splits n points into count equal buckets, for min/max downsampling.
+func evenBuckets(n, count int) []bucket {
+	count = max(min(count, n), 1)
+	out := make([]bucket, count)
+	for b := range out {
+		out[b] = bucket{b * n / count, (b + 1) * n / count}
+	}
+	return out
+}
+
+// lttbPick returns, per bucket, the index LTTB keeps from xs/ys, or -1 for a bucket
+// with no finite point. Each is the point forming the largest triangle with the
+// previous kept point and the next bucket's average; when the next bucket is empty,
+// the one furthest from the previous kept point.
+func lttbPick(xs, ys []float64, buckets []bucket) []int {
+	picks := make([]int, len(buckets))
+	prev := -1
+	for b, bk := range buckets {
+		nextX, nextY, haveNext := 0.0, 0.0, false
+		if b+1 < len(buckets) {
+			var count int
+			for i := buckets[b+1].start; i < buckets[b+1].end; i++ {
+				if !math.IsNaN(ys[i]) {
+					nextX += xs[i]
+					nextY += ys[i]
+					count++
+				}
+			}
+			if count > 0 {
+				nextX, nextY, haveNext = nextX/float64(count), nextY/float64(count), true
+			}
+		}
+		best, bestArea := -1, -1.0
+		for i := bk.start; i < bk.end; i++ {
+			if math.IsNaN(ys[i]) {
+				continue
+			}
+			var area float64
+			switch {
+			case prev < 0:
+				// Nothing kept yet (the first bucket): keep its first point.
+			case haveNext:
+				area = math.Abs((xs[prev]-nextX)*(ys[i]-ys[prev]) - (xs[prev]-xs[i])*(nextY-ys[prev]))
+			default:
+				area = math.Abs(ys[i] - ys[prev])
+			}
+			if area > bestArea {
+				best, bestArea = i, area
+			}
+		}
+		picks[b] = best
+		if best >= 0 {
+			prev = best
+		}
+	}
+	return picks
+}
+
+// minMaxPick returns, per bucket, the indices of its minimum and maximum finite
+// points in time order (the same index twice for a single point), or -1s for a bucket
+// with none.
+func minMaxPick(ys []float64, buckets []bucket) [][2]int {
+	picks := make([][2]int, len(buckets))
+	for b, bk := range buckets {
+		lo, hi := -1, -1
+		for i := bk.start; i < bk.end; i++ {
+			if math.IsNaN(ys[i]) {
+				continue
+			}
+			if lo < 0 || ys[i] < ys[lo] {
+				lo = i
+			}
+			if hi < 0 || ys[i] > ys[hi] {
+				hi = i
+			}
+		}
+		picks[b] = [2]int{min(lo, hi), max(lo, hi)}
+	}
+	return picks
+}
+
+// downsampleRows thins aligned chart rows sharing grid to about target points. Every
+// series is bucketed alike, and each bucket becomes one x position (two for min/max)
+// holding each series' kept value(s), so the series stay aligned; a kept point moves
+// at most a bucket's width — under a pixel at target = the chart's width.
+func downsampleRows(mode string, grid []model.Time, rows [][]float64, target int) ([]model.Time, [][]float64) {
+	if len(grid) <= target {
+		return grid, rows
+	}
+	xs := make([]float64, len(grid))
+	for i := range xs {
+		xs[i] = float64(i)
+	}
+	if mode == config.DownsampleMinMax {
+		buckets := evenBuckets(len(grid), target/2)
+		outGrid := make([]model.Time, 0, 2*len(buckets))
+		for _, bk := range buckets {
+			outGrid = append(outGrid, grid[bk.start], grid[(bk.start+bk.end)/2])
+		}
+		out := make([][]float64, len(rows))
+		for r, ys := range rows {
+			row := make([]float64, 0, len(outGrid))
+			for _, pick := range minMaxPick(ys, buckets) {
+				row = append(row, valueAt(ys, pick[0]), valueAt(ys, pick[1]))
+			}
+			out[r] = row
+		}
+		return outGrid, out
+	}
+	buckets := lttbBuckets(len(grid), target)
+	outGrid := make([]model.Time, len(buckets))
+	for b, bk := range buckets {
+		outGrid[b] = grid[bk.start]
+	}
+	out := make([][]float64, len(rows))
+	for r, ys := range rows {
+		row := make([]float64, len(buckets))
+		for b, i := range lttbPick(xs, ys, buckets) {
+			row[b] = valueAt(ys, i)
+		}
+		out[r] = row
+	}
+	return outGrid, out
+}
+
+// valueAt is ys[i], or NaN for a bucket's -1 (no point).
+func valueAt(ys []float64, i int) float64 {
+	if i < 0 {
+		return math.NaN()
+	}
+	return ys[i]
+}
+
+// downsamplePoints thins one JSON series to about target points, keeping each kept
+// point's own timestamp.
+func downsamplePoints(mode string, data []HistoryDataPoint, target int) []HistoryDataPoint {
+	if len(data) <= target {
+		return data
+	}
+	xs := make([]float64, len(data))
+	ys := make([]float64, len(data))
+	for i, d := range data {
+		xs[i], ys[i] = float64(d.T), d.V
+	}
+	var keep []int
+	if mode == config.DownsampleMinMax {
+		for _, pick := range minMaxPick(ys, evenBuckets(len(data), target/2)) {
+			keep = append(keep, pick[0])
+			if pick[1] != pick[0] {
+				keep = append(keep, pick[1])
+			}
+		}
+	} else {
+		keep = lttbPick(xs, ys, lttbBuckets(len(data), target))
+	}
+	out := make([]HistoryDataPoint, 0, len(keep))
+	for _, i := range keep {
+		out = append(out, data[i])
+	}
+	return out
+}
+
+// downsample thins each series' data to about target points. Stats were computed
+// from every point and are kept.
+func (resp *HistoryResponse) downsample(mode string, target int) {
+	for i := range resp.Series {
+		resp.Series[i].Data = downsamplePoints(mode, resp.Series[i].Data, target)
+	}
+}
+
+// minDownsamplePoints is the fewest points ?downsample= may ask for: LTTB keeps the
+// first and last point plus at least one between.
+const minDownsamplePoints = 3
+
+// jsonDownsample parses a JSON request's ?downsample=<points>, returning 0 (keep
+// every point) when it's absent or invalid.
+func jsonDownsample(q string) int {
+	n, err := strconv.Atoi(q)
+	if err != nil || n < minDownsamplePoints {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,115 @@
+package kromgo
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLTTBBuckets(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []bucket{{0, 1}, {1, 5}, {5, 9}, {9, 10}}, lttbBuckets(10, 4))
+	assert.Len(t, lttbBuckets(3, 10), 3, "no fewer points than asked for: one bucket each")
+}
+
+func TestEvenBuckets(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []bucket{{0, 3}, {3, 6}, {6, 10}}, evenBuckets(10, 3))
+}
+
+// spiky is n flat points with one peak and one trough.
+func spiky(n, peak, trough int) []HistoryDataPoint {
+	data := make([]HistoryDataPoint, n)
+	for i := range data {
+		data[i] = HistoryDataPoint{T: int64(i * 60), V: 10}
+	}
+	data[peak].V, data[trough].V = 100, -50
+	return data
+}
+
+func TestDownsamplePoints(t *testing.T) {
+	t.Parallel()
+	data := spiky(1000, 537, 811)
+	for _, mode := range []string{config.DownsampleLTTB, config.DownsampleMinMax} {
+		t.Run(mode, func(t *testing.T) {
+			t.Parallel()
+			got := downsamplePoints(mode, data, 50)
+			assert.LessOrEqual(t, len(got), 50)
+			assert.Contains(t, got, data[537], "the peak survives")
+			assert.Contains(t, got, data[811], "the trough survives")
+			assert.True(t, slices.IsSortedFunc(got, func(a, b HistoryDataPoint) int { return int(a.T - b.T) }))
+		})
+	}
+	lttb := downsamplePoints(config.DownsampleLTTB, data, 50)
+	assert.Len(t, lttb, 50)
+	assert.Equal(t, data[0], lttb[0], "LTTB keeps the first point")
+	assert.Equal(t, data[999], lttb[49], "and the last")
+
+	assert.Equal(t, data[:10], downsamplePoints(config.DownsampleLTTB, data[:10], 50), "short series are untouched")
+}
+
+func TestDownsampleRows(t *testing.T) {
+	t.Parallel()
+	grid := make([]model.Time, 400)
+	for i := range grid {
+		grid[i] = model.Time(i * 60_000)
+	}
+	flat := func() []float64 {
+		row := make([]float64, len(grid))
+		for i := range row {
+			row[i] = 1
+		}
+		return row
+	}
+	a, b := flat(), flat()
+	a[123] = 50
+	for i := range 200 {
+		b[i] = math.NaN() // b starts halfway through the window
+	}
+
+	for _, mode := range []string{config.DownsampleLTTB, config.DownsampleMinMax} {
+		t.Run(mode, func(t *testing.T) {
+			t.Parallel()
+			outGrid, rows := downsampleRows(mode, grid, [][]float64{a, b}, 40)
+			require.Len(t, rows, 2)
+			assert.LessOrEqual(t, len(outGrid), 40)
+			assert.Len(t, rows[0], len(outGrid), "every row shares the grid")
+			assert.Len(t, rows[1], len(outGrid))
+			assert.Contains(t, rows[0], 50.0, "the peak survives")
+			assert.True(t, math.IsNaN(rows[1][1]), "a bucket with no points stays missing")
+			assert.InDelta(t, 1, rows[1][len(outGrid)-1], 0)
+		})
+	}
+
+	// Already within the target: unchanged.
+	outGrid, rows := downsampleRows(config.DownsampleLTTB, grid[:10], [][]float64{a[:10]}, 40)
+	assert.Equal(t, grid[:10], outGrid)
+	assert.Equal(t, [][]float64{a[:10]}, rows)
+}
+
+func TestRenderChart_Downsample(t *testing.T) {
+	t.Parallel()
+	values := make([]float64, 3000)
+	for i := range values {
+		values[i] = float64(i % 7)
+	}
+	matrix := makeMatrix([][]float64{values})
+	full, err := renderChart(matrix, chartParams{width: 300, height: 150, format: formatSVG})
+	require.NoError(t, err)
+	thin, err := renderChart(matrix, chartParams{width: 300, height: 150, format: formatSVG, downsample: config.DownsampleLTTB})
+	require.NoError(t, err)
+	assert.Less(t, len(thin), len(full)/2, "about a point per pixel instead of 3000")
+}
+
+func TestJSONDownsample(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 200, jsonDownsample("200"))
+	for _, q := range []string{"", "2", "-5", "lots"} {
+		assert.Zero(t, jsonDownsample(q), q)
+	}
+}
@@ -0,0 +1,183 @@
+package kromgo
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// The export formats hand a graph's data to other tools: CSV for spreadsheets, and
+// the envelope of Prometheus' /api/v1/query_range for clients that already parse it.
+// Both cover the same validated window and series cap. The CSV carries the JSON
+// output's series (after the series pipeline, with their compare series); the
+// Prometheus response the queries' own results.
+
+const (
+	formatCSV        = "csv"
+	formatPrometheus = "prometheus"
+
+	mimeCSV = "text/csv; charset=utf-8"
+)
+
+// csvTimeHeader heads a CSV export's first column: each row's Unix timestamp.
+const csvTimeHeader = "timestamp"
+
+// seriesCSV lays the series out as a table: a row per timestamp any series has a
+// sample at, in order, and a column per series named by its legend name, numbered
+// " (2)", " (3)", ... after the first when names repeat, and guarded by csvText. A
+// series with no (finite) sample at a row's time leaves its cell empty.
+func (p chartParams) seriesCSV(matrix model.Matrix) [][]string {
+	var times []model.Time
+	for _, stream := range matrix {
+		for _, pt := range stream.Values {
+			times = append(times, pt.Timestamp)
+		}
+	}
+	slices.Sort(times)
+	times = slices.Compact(times)
+
+	header := []string{csvTimeHeader}
+	seen := map[string]bool{csvTimeHeader: true}
+	for _, stream := range matrix {
+		name := p.seriesLabel(stream.Metric)
+		for n := 2; seen[name]; n++ {
+			name = parenthesize(p.seriesLabel(stream.Metric), strconv.Itoa(n))
+		}
+		seen[name] = true
+		header = append(header, csvText(name))
+	}
+	rows := make([][]string, len(times))
+	for j, ts := range times {
+		rows[j] = make([]string, len(matrix)+1)
+		rows[j][0] = strconv.FormatInt(int64(ts)/1000, 10)
+	}
+	for i, stream := range matrix {
+		for _, pt := range stream.Values {
+			v := float64(pt.Value)
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			j, _ := slices.BinarySearch(times, pt.Timestamp)
+			rows[j][i+1] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return append([][]string{header}, rows...)
+}
+
+// csvText guards a text cell against formula injection: a spreadsheet runs a cell
+// starting with =, +, -, or @ (or a tab or carriage return before one) as a formula,
+// so such text gets a leading ' and is shown as written. Numbers are written
+// unguarded.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csv lays the heatmap out as a table: a row per step with data and a column per bin,
+// named by its upper bound as on the chart's y-axis. Like a graph's series, the bins
+// are capped at maxGraphSeries columns, lowest first.
+func (hm heatmap) csv(log *slog.Logger) [][]string {
+	resp := hm.response()
+	bins := resp.Bins
+	if len(bins) > maxGraphSeries {
+		log.Warn("heatmap bins truncated", "total", len(bins), "cap", maxGraphSeries)
+		bins = bins[:maxGraphSeries]
+	}
+	header := []string{csvTimeHeader}
+	for _, b := range bins {
+		header = append(header, b.Upper.String())
+	}
+	out := [][]string{header}
+	for j, ts := range resp.Times {
+		row := []string{strconv.FormatInt(ts, 10)}
+		for b := range bins {
+			row = append(row, strconv.FormatFloat(resp.Counts[b][j], 'f', -1, 64))
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// writeCSV writes the table as a CSV response, falling back to a 500 error response
+// on encoding failure.
+func writeCSV(w http.ResponseWriter, log *slog.Logger, id string, table [][]string) {
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(table); err != nil {
+		log.Error("error writing csv response", "error", err)
+		writeError(w, id, "Error", "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mimeCSV)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// prometheusResponse is the envelope of Prometheus' HTTP API.
+type prometheusResponse struct {
+	Status    string          `json:"status"`
+	Data      *prometheusData `json:"data,omitempty"`
+	ErrorType string          `json:"errorType,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// prometheusData is a query result in Prometheus' API: a range query's is a matrix.
+type prometheusData struct {
+	ResultType model.ValueType `json:"resultType"`
+	Result     model.Matrix    `json:"result"`
+}
+
+// queryResult is the matrix as the graph's queries returned it, for a query_range
+// response: without the compare series, and without the internal queryLabel tag a
+// named query's series carry.
+func queryResult(matrix model.Matrix) model.Matrix {
+	current, _ := splitCompare(matrix)
+	out := make(model.Matrix, len(current))
+	for i, stream := range current {
+		s := *stream
+		s.Metric = stream.Metric.Clone()
+		delete(s.Metric, queryLabel)
+		out[i] = &s
+	}
+	return out
+}
+
+// writePrometheus writes the matrix in the envelope /api/v1/query_range returns.
+func writePrometheus(w http.ResponseWriter, log *slog.Logger, id string, matrix model.Matrix) {
+	if matrix == nil {
+		matrix = model.Matrix{} // Prometheus returns [] for no series, not null
+	}
+	writeJSONOr(w, log, id, http.StatusOK, prometheusResponse{
+		Status: "success",
+		Data:   &prometheusData{ResultType: model.ValMatrix, Result: matrix},
+	})
+}
+
+// writePrometheusError writes a failure in the Prometheus API's error envelope, with
+// the errorType Prometheus uses for the status code. Errors are never cached.
+func writePrometheusError(w http.ResponseWriter, reason string, code int) {
+	errorType := "internal"
+	switch {
+	case code == http.StatusNotFound:
+		errorType = "not_found"
+	case code >= 400 && code < 500:
+		errorType = "bad_data"
+	}
+	body, err := json.Marshal(prometheusResponse{Status: "error", ErrorType: errorType, Error: reason})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mimeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,137 @@
+package kromgo
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesCSV(t *testing.T) {
+	t.Parallel()
+	matrix := model.Matrix{
+		{Metric: model.Metric{"__name__": "up", "pod": "a"}, Values: []model.SamplePair{
+			{Timestamp: 60_000, Value: 1},
+			{Timestamp: 120_000, Value: model.SampleValue(math.NaN())},
+			{Timestamp: 180_000, Value: 0.25},
+		}},
+		{Metric: model.Metric{"pod": "b,c"}, Values: []model.SamplePair{
+			{Timestamp: 120_000, Value: 2},
+			{Timestamp: 240_000, Value: 1e21},
+		}},
+	}
+
+	assert.Equal(t, [][]string{
+		{"timestamp", "a", "b,c"},
+		{"60", "1", ""},
+		{"120", "", "2"},
+		{"180", "0.25", ""},
+		{"240", "", "1000000000000000000000"},
+	}, chartParams{}.seriesCSV(matrix))
+	assert.Equal(t, [][]string{{"timestamp"}}, chartParams{}.seriesCSV(nil))
+
+	// Repeated names are numbered, and text a spreadsheet would run is quoted.
+	one := []model.SamplePair{{Timestamp: 60_000, Value: -1}}
+	matrix = model.Matrix{
+		{Metric: model.Metric{"pod": "a"}, Values: one},
+		{Metric: model.Metric{"pod": "a"}, Values: one},
+		{Metric: model.Metric{"pod": "a (2)"}, Values: one},
+		{Metric: model.Metric{"pod": "timestamp"}, Values: one},
+		{Metric: model.Metric{"pod": "=HYPERLINK(\"http://x\")"}, Values: one},
+		{Metric: model.Metric{"pod": "@SUM(A1)"}, Values: one},
+	}
+	assert.Equal(t, [][]string{
+		{"timestamp", "a", "a (2)", "a (2) (2)", "timestamp (2)", "'=HYPERLINK(\"http://x\")", "'@SUM(A1)"},
+		{"60", "-1", "-1", "-1", "-1", "-1", "-1"},
+	}, chartParams{}.seriesCSV(matrix))
+}
+
+func TestCSVText(t *testing.T) {
+	t.Parallel()
+	for in, want := range map[string]string{
+		"api": "api", "": "", "=1+2": "'=1+2", "+1": "'+1", "-cmd": "'-cmd", "@A1": "'@A1", "a=b": "a=b",
+		"\t=1+2": "'\t=1+2", "\r=1+2": "'\r=1+2", "a\tb": "a\tb",
+	} {
+		assert.Equal(t, want, csvText(in), in)
+	}
+}
+
+func TestHeatmapCSV(t *testing.T) {
+	t.Parallel()
+	hm := heatmap{
+		bins:    []HeatmapBin{{Lower: 0, Upper: 0.5}, {Lower: 0.5, Upper: model.FloatString(math.Inf(1))}},
+		times:   []model.Time{60_000, 120_000, 180_000},
+		counts:  [][]float64{{0, 3, 1}, {0, 5, 2}},
+		present: []bool{false, true, true},
+	}
+	assert.Equal(t, [][]string{
+		{"timestamp", "0.5", "+Inf"},
+		{"120", "3", "5"},
+		{"180", "1", "2"},
+	}, hm.csv(slog.Default()))
+
+	// Past maxGraphSeries bins, the highest are dropped.
+	wide := heatmap{times: []model.Time{60_000, 120_000}, present: []bool{false, true}}
+	for b := range maxGraphSeries + 5 {
+		wide.bins = append(wide.bins, HeatmapBin{Lower: model.FloatString(b), Upper: model.FloatString(b + 1)})
+		wide.counts = append(wide.counts, []float64{0, 1})
+	}
+	table := wide.csv(slog.Default())
+	assert.Len(t, table[0], maxGraphSeries+1, "a timestamp column and a column per kept bin")
+	assert.Equal(t, "100", table[0][maxGraphSeries])
+}
+
+func TestQueryResult(t *testing.T) {
+	t.Parallel()
+	matrix := model.Matrix{
+		{Metric: model.Metric{"pod": "a", queryLabel: "requests"}},
+		{Metric: model.Metric{"pod": "a", queryLabel: "requests", compareLabel: "1d"}},
+	}
+	got := queryResult(matrix)
+	require.Len(t, got, 1, "no compare series")
+	assert.Equal(t, model.Metric{"pod": "a"}, got[0].Metric)
+	assert.Equal(t, model.LabelValue("requests"), matrix[0].Metric[queryLabel], "the graph's own series keep their tag")
+}
+
+func TestWritePrometheus(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	writePrometheus(w, slog.Default(), "g", nil)
+	assert.Equal(t, mimeJSON, w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"status":"success","data":{"resultType":"matrix","result":[]}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	writePrometheus(w, slog.Default(), "g", model.Matrix{{
+		Metric: model.Metric{"pod": "a"},
+		Values: []model.SamplePair{{Timestamp: 1_500, Value: 2}},
+	}})
+	assert.JSONEq(t, `{"status":"success","data":{"resultType":"matrix","result":[
+		{"metric":{"pod":"a"},"values":[[1.5,"2"]]}
+	]}}`, w.Body.String())
+}
+
+func TestWritePrometheusError(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		code int
+		want string
+	}{
+		{http.StatusBadRequest, "bad_data"},
+		{http.StatusNotFound, "not_found"},
+		{http.StatusInternalServerError, "internal"},
+	} {
+		w := httptest.NewRecorder()
+		writePrometheusError(w, "why", tc.code)
+		assert.Equal(t, tc.code, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		var resp prometheusResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, prometheusResponse{Status: "error", ErrorType: tc.want, Error: "why"}, resp)
+	}
+}
@@ -1,23 +1,38 @@
 package kromgo
 
 import (
+	"cmp"
 	"fmt"
+	"log/slog"
 	"reflect"
+	"time"
 
 	"github.com/google/cel-go/cel"
+	celast "github.com/google/cel-go/common/ast"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
 	"github.com/google/cel-go/ext"
+	"github.com/prometheus/common/model"
 )
 
 // newCELEnv builds the CEL environment exposed to a metric's value/color
-// expressions. Expressions get two variables — result (the sample value) and
-// labels (its label set) — plus the string and math extensions, optional types,
-// and kromgo's humanizer functions. CEL is sandboxed: no env/file/network access.
+// expressions. Expressions get six variables — result (the sample value), labels
+// (its label set), text (a string query result), histogram (a native-histogram
+// sample), and timestamp/now (the sample's and the query's Unix time in seconds)
+// — plus the string and math extensions, optional types, and kromgo's humanizer
+// functions. CEL is sandboxed: no env/file/network access.
 func newCELEnv() (*cel.Env, error) {
 	return cel.NewEnv(append([]cel.EnvOption{
 		cel.Variable("result", cel.DoubleType),
 		cel.Variable("labels", cel.MapType(cel.StringType, cel.StringType)),
+		// A PromQL string result has no number; its value arrives here ("" otherwise).
+		cel.Variable("text", cel.StringType),
+		// Doubles, like result, so `now - timestamp` feeds humanizeDuration directly.
+		cel.Variable("timestamp", cel.DoubleType),
+		cel.Variable("now", cel.DoubleType),
+		// A native-histogram sample's count, sum, and buckets; empty for a float sample.
+		cel.Variable("histogram", cel.MapType(cel.StringType, cel.DynType)),
 		// result is a double; allow comparing it against plain int literals
 		// (result < 35, not result < 35.0) — the usual color-threshold case.
 		cel.CrossTypeNumericComparisons(true),
@@ -28,7 +43,7 @@ func newCELEnv() (*cel.Env, error) {
 		// Prometheus can return non-finite values that would otherwise render
 		// literally (e.g. "NaN") on a badge.
 		ext.Math(),
-	}, append(humanizerFuncs(), colorFuncs()...)...)...)
+	}, append(append(humanizerFuncs(), colorFuncs()...), histogramFuncs()...)...)...)
 }
 
 // unaryStringFunc registers a CEL function that takes the numeric result and returns
@@ -45,10 +60,57 @@ func unaryStringFunc(name string, impl func(float64) string) cel.EnvOption {
 func humanizerFuncs() []cel.EnvOption {
 	return []cel.EnvOption{
 		unaryStringFunc("humanizeBytes", humanizeBytes),
+		unaryStringFunc("humanizeBytesIEC", humanizeBytesIEC),
+		unaryStringFunc("humanizeBits", humanizeBits),
+		unaryStringFunc("humanizeBitRate", humanizeBitRate),
 		unaryStringFunc("humanizeCommas", humanizeCommas),
 		unaryStringFunc("humanizeFloat", humanizeFloat),
+		unaryStringFunc("humanizePercent", humanizePercent),
 		unaryStringFunc("humanizeDuration", humanizeDuration),
 		unaryStringFunc("humanizeDurationDays", humanizeDurationDays),
+		unaryStringFunc("humanizeRelativeTime", humanizeRelativeTime),
+		cel.Function("humanizeSI", cel.Overload("humanizeSI_double_string",
+			[]*cel.Type{cel.DoubleType, cel.StringType}, cel.StringType,
+			cel.BinaryBinding(func(v, unit ref.Val) ref.Val {
+				return types.String(humanizeSI(float64(v.(types.Double)), string(unit.(types.String))))
+			}))),
+		// sprintf("%.1f%% of %s", [result, labels["node"]]) — see sprintf for the
+		// restrictions that keep it safe on untrusted label values.
+		cel.Function("sprintf", cel.Overload("sprintf_string_list",
+			[]*cel.Type{cel.StringType, cel.ListType(cel.DynType)}, cel.StringType,
+			cel.BinaryBinding(func(format, list ref.Val) ref.Val {
+				var args []any
+				it := list.(traits.Lister).Iterator()
+				for it.HasNext() == types.True {
+					args = append(args, it.Next().Value())
+				}
+				s, err := sprintf(string(format.(types.String)), args)
+				if err != nil {
+					return types.NewErr("%v", err)
+				}
+				return types.String(s)
+			}))),
+	}
+}
+
+// histogramFuncs registers histogramQuantile(histogram, q) and the one-argument
+// shorthand histogramQuantile(q), a macro that expands to the same call on the
+// sample's own histogram variable.
+func histogramFuncs() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Function("histogramQuantile", cel.Overload("histogramQuantile_map_double",
+			[]*cel.Type{cel.MapType(cel.StringType, cel.DynType), cel.DoubleType}, cel.DoubleType,
+			cel.BinaryBinding(func(hist, q ref.Val) ref.Val {
+				h, err := histogramFromCEL(hist)
+				if err != nil {
+					return types.NewErr("%v", err)
+				}
+				return types.Double(histogramQuantile(h, float64(q.(types.Double))))
+			}))),
+		cel.Macros(cel.GlobalMacro("histogramQuantile", 1,
+			func(eh cel.MacroExprFactory, _ celast.Expr, args []celast.Expr) (celast.Expr, *cel.Error) {
+				return eh.NewCall("histogramQuantile", eh.NewIdent("histogram"), args[0]), nil
+			})),
 	}
 }
 
@@ -75,12 +137,22 @@ func colorFuncs() []cel.EnvOption {
 // compileStringExpr compiles src and requires it to evaluate to a string. kind/name
 // are used only for error context.
 func compileStringExpr(env *cel.Env, name, kind, src string) (cel.Program, error) {
+	return compileTypedExpr(env, name, kind, src, cel.StringType)
+}
+
+// compileBoolExpr compiles a predicate, requiring it to evaluate to a bool.
+func compileBoolExpr(env *cel.Env, name, kind, src string) (cel.Program, error) {
+	return compileTypedExpr(env, name, kind, src, cel.BoolType)
+}
+
+// compileTypedExpr compiles src and requires it to evaluate to want.
+func compileTypedExpr(env *cel.Env, name, kind, src string, want *cel.Type) (cel.Program, error) {
 	ast, iss := env.Compile(src)
 	if iss != nil && iss.Err() != nil {
 		return nil, fmt.Errorf("metric %q %s expression: %w", name, kind, iss.Err())
 	}
-	if ast.OutputType() != cel.StringType {
-		return nil, fmt.Errorf("metric %q %s expression must return string, got %s", name, kind, ast.OutputType())
+	if ast.OutputType() != want {
+		return nil, fmt.Errorf("metric %q %s expression must return %s, got %s", name, kind, want, ast.OutputType())
 	}
 	prog, err := env.Program(ast)
 	if err != nil {
@@ -89,9 +161,41 @@ func compileStringExpr(env *cel.Env, name, kind, src string) (cel.Program, error
 	return prog, nil
 }
 
-// evalStringExpr evaluates prog against the sample value and labels.
-func evalStringExpr(prog cel.Program, result float64, labels map[string]string) (string, error) {
-	out, _, err := prog.Eval(map[string]any{"result": result, "labels": labels})
+// exprVars is the input to a value/color expression: a sample's value, labels,
+// timestamp, and native histogram (if any), the text of a string result, and the
+// time the query ran. A zero time binds as 0.
+type exprVars struct {
+	result    float64
+	labels    map[string]string
+	text      string
+	timestamp time.Time
+	now       time.Time
+	histogram *model.SampleHistogram
+}
+
+// unixSeconds converts t to fractional Unix seconds, mapping the zero time to 0.
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// activation binds the variables for an expression evaluation.
+func (v exprVars) activation() map[string]any {
+	return map[string]any{
+		"result":    v.result,
+		"labels":    v.labels,
+		"text":      v.text,
+		"timestamp": unixSeconds(v.timestamp),
+		"now":       unixSeconds(v.now),
+		"histogram": histogramVar(v.histogram),
+	}
+}
+
+// evalStringExpr evaluates prog against a sample's variables.
+func evalStringExpr(prog cel.Program, v exprVars) (string, error) {
+	out, _, err := prog.Eval(v.activation())
 	if err != nil {
 		return "", err
 	}
@@ -101,3 +205,30 @@ func evalStringExpr(prog cel.Program, result float64, labels map[string]string)
 	}
 	return s, nil
 }
+
+// evalStringOr evaluates prog against a sample's variables, keeping fallback when
+// there is no prog, or it fails (logged as kind) or returns "".
+func evalStringOr(prog cel.Program, v exprVars, fallback, kind string, log *slog.Logger) string {
+	if prog == nil {
+		return fallback
+	}
+	s, err := evalStringExpr(prog, v)
+	if err != nil {
+		log.Error(kind+" expression failed", "error", err)
+		return fallback
+	}
+	return cmp.Or(s, fallback)
+}
+
+// evalBoolExpr evaluates a predicate against a sample's variables.
+func evalBoolExpr(prog cel.Program, v exprVars) (bool, error) {
+	out, _, err := prog.Eval(v.activation())
+	if err != nil {
+		return false, err
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression returned %T, want bool", out.Value())
+	}
+	return b, nil
+}
@@ -3,6 +3,7 @@ package kromgo
 import (
 	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,7 +15,7 @@ func eval(t *testing.T, src string, result float64, labels map[string]string) (s
 	require.NoError(t, err)
 	prog, err := compileStringExpr(env, "test", "value", src)
 	require.NoError(t, err)
-	return evalStringExpr(prog, result, labels)
+	return evalStringExpr(prog, exprVars{result: result, labels: labels})
 }
 
 func TestCEL_Expressions(t *testing.T) {
@@ -39,6 +40,12 @@ func TestCEL_Expressions(t *testing.T) {
 		{"humanizeDurationDays", `humanizeDurationDays(result)`, 544 * 86400, nil, "544d"},
 		{"humanizeCommas", `humanizeCommas(result)`, 1000000, nil, "1,000,000"},
 		{"humanizeFloat", `humanizeFloat(result)`, 2.5, nil, "2.5"},
+		{"humanizeBytesIEC", `humanizeBytesIEC(result)`, 1572864, nil, "1.5MiB"},
+		{"humanizeBitRate", `humanizeBitRate(result * 8.0)`, 125000, nil, "1Mbps"},
+		{"humanizeSI", `humanizeSI(result, "W")`, 1234, nil, "1.2kW"},
+		{"humanizePercent", `humanizePercent(result)`, 0.425, nil, "42.5%"},
+		{"humanizeRelativeTime", `humanizeRelativeTime(result).endsWith(" ago") ? "past" : "future"`, 0, nil, "past"},
+		{"sprintf", `sprintf("%.1f%% on %s", [result, labels["node"]])`, 42.36, map[string]string{"node": "n1"}, "42.4% on n1"},
 		// color helper (colors.go)
 		{"colorScale high", `colorScale(result, [35.0, 75.0], ["green", "orange", "red"])`, 80, nil, "red"},
 		{"colorScale low", `colorScale(result, [35.0, 75.0], ["green", "orange", "red"])`, 10, nil, "green"},
@@ -63,6 +70,19 @@ func TestCEL_Expressions(t *testing.T) {
 	}
 }
 
+func TestCEL_TimestampAndNow(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+	prog, err := compileStringExpr(env, "test", "value", `humanizeDuration(now - timestamp) + " ago"`)
+	require.NoError(t, err)
+
+	now := time.Unix(1_700_000_000, 0)
+	got, err := evalStringExpr(prog, exprVars{timestamp: now.Add(-5 * time.Minute), now: now})
+	require.NoError(t, err)
+	assert.Equal(t, "5m ago", got)
+}
+
 func TestCEL_CompileRejectsNonString(t *testing.T) {
 	t.Parallel()
 	env, err := newCELEnv()
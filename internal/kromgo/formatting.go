@@ -5,6 +5,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // The functions below are registered with the CEL environment (see expr.go) and
@@ -13,27 +14,97 @@ import (
 // (no external humanize dependency) so the output is exactly what kromgo specifies.
 
 // byteUnits is the SI suffix ladder for humanizeBytes (decimal, powers of 1000).
-var byteUnits = [...]string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+var byteUnits = []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+
+// iecByteUnits is the binary suffix ladder for humanizeBytesIEC (powers of 1024).
+var iecByteUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// bitUnits is the SI suffix ladder for humanizeBits; humanizeBitRate appends "ps".
+var bitUnits = []string{"b", "kb", "Mb", "Gb", "Tb", "Pb", "Eb"}
 
 // humanizeBytes formats a byte count with SI decimal units and no space, scaling by
 // powers of 1000: 1500000 -> "1.5MB", 1000 -> "1kB", 512 -> "512B". Scaled values keep
 // at most one decimal, with a trailing ".0" stripped.
 func humanizeBytes(f float64) string {
+	return scaleUnits(f, 1000, byteUnits)
+}
+
+// humanizeBytesIEC formats a byte count with binary (IEC) units, scaling by powers of
+// 1024: 1572864 -> "1.5MiB", 1024 -> "1KiB", 512 -> "512B".
+func humanizeBytesIEC(f float64) string {
+	return scaleUnits(f, 1024, iecByteUnits)
+}
+
+// humanizeBits formats a bit count with SI decimal units: 1500000 -> "1.5Mb".
+func humanizeBits(f float64) string {
+	return scaleUnits(f, 1000, bitUnits)
+}
+
+// humanizeBitRate formats a bits-per-second rate with SI decimal units: 1500000 ->
+// "1.5Mbps". A byte rate converts with result * 8.0.
+func humanizeBitRate(f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return humanizeFloat(f)
+	}
+	return humanizeBits(f) + "ps"
+}
+
+// scaleUnits divides f by base until it fits the unit ladder and formats it to one
+// decimal with the matching suffix — the shared core of the byte/bit humanizers.
+func scaleUnits(f, base float64, units []string) string {
 	if math.IsInf(f, 0) || math.IsNaN(f) {
 		return humanizeFloat(f) // "+Inf" / "-Inf" / "NaN", not "+InfEB" / "NaNB"
 	}
 	v, i := f, 0
-	for math.Abs(v) >= 1000 && i < len(byteUnits)-1 {
-		v /= 1000
+	for math.Abs(v) >= base && i < len(units)-1 {
+		v /= base
 		i++
 	}
-	// Rounding to one decimal can tip |v| up to 1000 (e.g. 999999 → 999.999); carry
+	// Rounding to one decimal can tip |v| up to the base (e.g. 999999 → 999.999); carry
 	// into the next unit so it renders "1MB", not "1000kB".
-	if math.Round(math.Abs(v)*10)/10 >= 1000 && i < len(byteUnits)-1 {
+	if math.Round(math.Abs(v)*10)/10 >= base && i < len(units)-1 {
+		v /= base
+		i++
+	}
+	return trimOneDecimal(v) + units[i]
+}
+
+// siPrefixes is the metric prefix ladder for humanizeSI, smallest first; the empty
+// prefix (10^0) sits at siBase.
+var siPrefixes = []string{"p", "n", "µ", "m", "", "k", "M", "G", "T", "P", "E"}
+
+const siBase = 4
+
+// humanizeSI formats a value with a metric prefix and the given unit, scaling by
+// powers of 1000 in both directions: (1234, "W") -> "1.2kW", (0.0015, "A") -> "1.5mA",
+// (1234, "") -> "1.2k". Zero and values already in [1, 1000) take no prefix.
+func humanizeSI(f float64, unit string) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return humanizeFloat(f)
+	}
+	v, i := f, siBase
+	for math.Abs(v) >= 1000 && i < len(siPrefixes)-1 {
 		v /= 1000
 		i++
 	}
-	return trimOneDecimal(v) + byteUnits[i]
+	for v != 0 && math.Abs(v) < 1 && i > 0 {
+		v *= 1000
+		i--
+	}
+	if math.Round(math.Abs(v)*10)/10 >= 1000 && i < len(siPrefixes)-1 {
+		v /= 1000
+		i++
+	}
+	return trimOneDecimal(v) + siPrefixes[i] + unit
+}
+
+// humanizePercent formats a 0..1 ratio as a percentage to one decimal: 0.425 ->
+// "42.5%", 1 -> "100%".
+func humanizePercent(f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return humanizeFloat(f)
+	}
+	return trimOneDecimal(f*100) + "%"
 }
 
 // humanizeCommas formats a number with comma thousands separators in the integer part,
@@ -121,6 +192,36 @@ func humanizeDuration(f float64) string {
 	return b.String()
 }
 
+// humanizeRelativeTime formats a Unix timestamp in seconds (e.g. a *_timestamp_seconds
+// metric) as the single most-significant unit relative to now: "3d ago", "5m ago",
+// or "in 2h" for a future time. Anything within a second of now is "now".
+func humanizeRelativeTime(f float64) string {
+	return relativeTime(f, time.Now())
+}
+
+// relativeTime is humanizeRelativeTime against an explicit now, for testing.
+func relativeTime(f float64, now time.Time) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return humanizeFloat(f)
+	}
+	delta := float64(now.UnixNano())/1e9 - f
+	secs := int(math.Round(math.Abs(delta)))
+	if secs < 1 {
+		return "now"
+	}
+	span := ""
+	for _, u := range durationUnits {
+		if n := secs / u.secs; n > 0 {
+			span = strconv.Itoa(n) + u.suffix
+			break
+		}
+	}
+	if delta < 0 {
+		return "in " + span
+	}
+	return span + " ago"
+}
+
 // humanizeDurationDays formats a number of seconds as a whole-day count, e.g.
 // 5961600 -> "69d". Unlike humanizeDuration it never rolls up to months/years —
 // just total days, truncated and clamped at zero.
@@ -134,3 +235,115 @@ func humanizeDurationDays(f float64) string {
 	}
 	return fmt.Sprintf("%dd", days)
 }
+
+// maxSprintfWidth caps the width and precision sprintf accepts, so an expression like
+// "%999999999d" can't turn one badge request into a huge allocation.
+const maxSprintfWidth = 64
+
+// sprintf is a restricted fmt.Sprintf for CEL: verbs are limited to d, x, X, f, e, g,
+// s, v, q (plus %%), width and precision are capped at maxSprintfWidth, and the verb
+// count must match len(args). Each argument is coerced to suit its verb — a double
+// under %d is truncated to an integer, a number under %s formats like humanizeFloat
+// — so a template never renders Go's "%!d(float64=…)" noise. A non-finite number
+// under an integer verb renders as "NaN" / "+Inf" / "-Inf".
+func sprintf(format string, args []any) (string, error) {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+# 0", format[j]) >= 0 {
+			j++
+		}
+		width, j := scanDigits(format, j)
+		prec := 0
+		if j < len(format) && format[j] == '.' {
+			prec, j = scanDigits(format, j+1)
+		}
+		if width > maxSprintfWidth || prec > maxSprintfWidth {
+			return "", fmt.Errorf("sprintf: width/precision exceeds %d", maxSprintfWidth)
+		}
+		if j >= len(format) {
+			return "", fmt.Errorf("sprintf: incomplete verb at end of format")
+		}
+		spec, verb := format[i:j+1], format[j]
+		i = j
+		if verb == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		if n >= len(args) {
+			return "", fmt.Errorf("sprintf: missing argument for %s", spec)
+		}
+		arg, err := sprintfArg(verb, args[n])
+		if err != nil {
+			return "", fmt.Errorf("sprintf: %s: %w", spec, err)
+		}
+		if s, ok := arg.(string); ok && strings.IndexByte("dxX", verb) >= 0 {
+			b.WriteString(s) // non-finite under an integer verb
+		} else {
+			fmt.Fprintf(&b, spec, arg)
+		}
+		n++
+	}
+	if n != len(args) {
+		return "", fmt.Errorf("sprintf: %d arguments for %d verbs", len(args), n)
+	}
+	return b.String(), nil
+}
+
+// scanDigits reads a run of decimal digits from s at i, returning its value (capped
+// just above maxSprintfWidth so a huge run can't overflow) and the index after it.
+func scanDigits(s string, i int) (int, int) {
+	v := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		v = min(v*10+int(s[i]-'0'), maxSprintfWidth+1)
+		i++
+	}
+	return v, i
+}
+
+// sprintfArg coerces one sprintf argument to the Go type its verb expects.
+func sprintfArg(verb byte, arg any) (any, error) {
+	switch verb {
+	case 'd', 'x', 'X':
+		switch v := arg.(type) {
+		case int64, uint64:
+			return v, nil
+		case float64:
+			if math.IsInf(v, 0) || math.IsNaN(v) {
+				return humanizeFloat(v), nil
+			}
+			return int64(v), nil
+		}
+	case 'f', 'e', 'g':
+		switch v := arg.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		case uint64:
+			return float64(v), nil
+		}
+	case 's', 'v', 'q':
+		switch v := arg.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return humanizeFloat(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case uint64:
+			return strconv.FormatUint(v, 10), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported verb %q", verb)
+	}
+	return nil, fmt.Errorf("cannot format %T", arg)
+}
@@ -3,8 +3,10 @@ package kromgo
 import (
 	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const day = 86400.0
@@ -30,6 +32,24 @@ func TestHumanizers(t *testing.T) {
 		// Non-finite inputs degrade to a clean string, not a unit-suffixed/comma-mangled one.
 		{"bytes NaN", humanizeBytes, math.NaN(), "NaN"},
 		{"bytes +Inf", humanizeBytes, math.Inf(1), "+Inf"},
+		// humanizeBytesIEC: binary units (powers of 1024).
+		{"iec B", humanizeBytesIEC, 512, "512B"},
+		{"iec KiB", humanizeBytesIEC, 1024, "1KiB"},
+		{"iec MiB", humanizeBytesIEC, 1572864, "1.5MiB"},
+		{"iec GiB", humanizeBytesIEC, 8 * 1024 * 1024 * 1024, "8GiB"},
+		{"iec carry", humanizeBytesIEC, 1048575, "1MiB"},
+		{"iec NaN", humanizeBytesIEC, math.NaN(), "NaN"},
+		// humanizeBits / humanizeBitRate: SI bits.
+		{"bits b", humanizeBits, 800, "800b"},
+		{"bits Mb", humanizeBits, 1500000, "1.5Mb"},
+		{"bitrate Gbps", humanizeBitRate, 2.5e9, "2.5Gbps"},
+		{"bitrate small", humanizeBitRate, 12, "12bps"},
+		{"bitrate -Inf", humanizeBitRate, math.Inf(-1), "-Inf"},
+		// humanizePercent: a 0..1 ratio to one decimal.
+		{"percent", humanizePercent, 0.425, "42.5%"},
+		{"percent whole", humanizePercent, 1, "100%"},
+		{"percent rounds", humanizePercent, 0.99999, "100%"},
+		{"percent NaN", humanizePercent, math.NaN(), "NaN"},
 		{"commas +Inf", humanizeCommas, math.Inf(1), "+Inf"},
 		{"commas NaN", humanizeCommas, math.NaN(), "NaN"},
 		{"float NaN", humanizeFloat, math.NaN(), "NaN"},
@@ -96,3 +116,109 @@ func TestHumanizeDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestHumanizeSI(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		in   float64
+		unit string
+		want string
+	}{
+		{"kilo", 1234, "W", "1.2kW"},
+		{"mega", 3.5e6, "Hz", "3.5MHz"},
+		{"no prefix", 42, "V", "42V"},
+		{"milli", 0.0015, "A", "1.5mA"},
+		{"micro", 2.5e-6, "s", "2.5µs"},
+		{"bare unit", 1234, "", "1.2k"},
+		{"zero", 0, "W", "0W"},
+		{"negative", -2500, "W", "-2.5kW"},
+		{"carry", 999999, "W", "1MW"},
+		{"NaN", math.NaN(), "W", "NaN"},
+		{"+Inf", math.Inf(1), "W", "+Inf"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, humanizeSI(tc.in, tc.unit))
+		})
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1_700_000_000, 0)
+	ts := func(d time.Duration) float64 { return float64(now.Add(d).Unix()) }
+	cases := []struct {
+		name string
+		in   float64
+		want string
+	}{
+		{"now", ts(0), "now"},
+		{"seconds ago", ts(-45 * time.Second), "45s ago"},
+		{"minutes ago", ts(-5*time.Minute - 20*time.Second), "5m ago"},
+		{"days ago", ts(-3*24*time.Hour - 2*time.Hour), "3d ago"},
+		{"months ago", ts(-65 * 24 * time.Hour), "2mo ago"},
+		{"future", ts(2 * time.Hour), "in 2h"},
+		{"NaN", math.NaN(), "NaN"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, relativeTime(tc.in, now))
+		})
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name   string
+		format string
+		args   []any
+		want   string
+	}{
+		{"float precision", "%.1f%%", []any{42.345}, "42.3%"},
+		{"int from double", "%d pods", []any{12.9}, "12 pods"},
+		{"padded int", "%03d", []any{int64(7)}, "007"},
+		{"hex", "%x", []any{255.0}, "ff"},
+		{"string and number", "%s on %s", []any{1.5, "node1"}, "1.5 on node1"},
+		{"quoted label", "%q", []any{`a"b`}, `"a\"b"`},
+		{"bool", "%v", []any{true}, "true"},
+		{"NaN under %d", "%d", []any{math.NaN()}, "NaN"},
+		{"NaN under %f", "%.2f", []any{math.NaN()}, "NaN"},
+		{"no verbs", "static", nil, "static"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := sprintf(tc.format, tc.args)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestSprintf_Rejects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name   string
+		format string
+		args   []any
+	}{
+		{"huge width", "%999999999d", []any{1.0}},
+		{"huge precision", "%.100f", []any{1.0}},
+		{"unsupported verb", "%p", []any{1.0}},
+		{"missing argument", "%s %s", []any{"a"}},
+		{"extra argument", "%s", []any{"a", "b"}},
+		{"dangling percent", "50%", nil},
+		{"string under %d", "%d", []any{"x"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := sprintf(tc.format, tc.args)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package kromgo
 
 import (
+	"cmp"
 	"log/slog"
 	"math"
 	"net/http"
+	"slices"
 	"time"
 
+	"github.com/home-operations/kromgo/internal/config"
 	"github.com/home-operations/kromgo/internal/logging"
 	"github.com/prometheus/common/model"
 )
@@ -25,8 +28,21 @@ type HistoryDataPoint struct {
 
 // HistorySeries is one labelled series in a graph's JSON time series.
 type HistorySeries struct {
-	Labels map[string]string  `json:"labels"`
-	Data   []HistoryDataPoint `json:"data"`
+	Name    string             `json:"name"`              // the legend's name for the series
+	Query   string             `json:"query,omitempty"`   // the query's name on a multi-query graph
+	Compare string             `json:"compare,omitempty"` // the period ("last week") of a compare series
+	Labels  map[string]string  `json:"labels"`
+	Data    []HistoryDataPoint `json:"data"`
+	Stats   *HistoryStats      `json:"stats,omitempty"` // nil when the series has no finite samples
+}
+
+// HistoryStats summarizes a series' finite samples over the window — the same values
+// the chart's average/min/max/median mark lines are drawn at.
+type HistoryStats struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Avg    float64 `json:"avg"`
+	Median float64 `json:"median"`
 }
 
 // HistoryResponse is the JSON returned for a graph's ?format=json.
@@ -37,21 +53,26 @@ type HistoryResponse struct {
 	End    int64           `json:"end"`
 	Step   int64           `json:"step"`
 	Series []HistorySeries `json:"series"`
+	// Heatmap is a heatmap graph's buckets per step, in place of its series.
+	Heatmap *HistoryHeatmap `json:"heatmap,omitempty"`
+	// Annotations are the events from the graph's annotation queries, by start time.
+	Annotations []HistoryAnnotation `json:"annotations,omitempty"`
 }
 
 // graphFormat resolves a graph request's output format, defaulting to SVG for an
 // empty or unrecognized value.
 func graphFormat(r *http.Request) string {
 	switch f := r.URL.Query().Get("format"); f {
-	case formatJSON, formatPNG:
+	case formatJSON, formatPNG, formatCSV, formatPrometheus:
 		return f
 	default:
 		return formatSVG
 	}
 }
 
-// serveGraph renders a time series as an SVG sparkline (default) or as JSON
-// (?format=json).
+// serveGraph renders a time series as an SVG (default) or PNG chart, or returns its
+// data as JSON (?format=json), CSV (?format=csv), or a Prometheus range-query
+// response (?format=prometheus).
 func (h *Handler) serveGraph(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
@@ -74,19 +95,77 @@ func (h *Handler) serveGraph(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	matrix, ok := h.queryMatrix(w, r, graph, start, end, step, log)
+	params := graph.defaults.withOverrides(r)
+	params.log = log
+	if params.chart == config.ChartHeatmap {
+		params.compare = 0 // a heatmap's buckets have no line to compare against
+	}
+	matrix, events, ok := h.queryMatrix(w, r, graph, start, end, step, params.compare, log)
 	if !ok {
 		return
 	}
-	matrix = capSeries(matrix, log)
+	params.start, params.end, params.step = start, end, step
+	params.annotations = events
 
-	if format == formatJSON {
-		writeJSONOr(w, log, id, historyResponse(graph, start, end, step, matrix))
-		return
+	var img []byte
+	var err error
+	if params.chart == config.ChartHeatmap {
+		// A heatmap reads the bucket series as returned: every bucket counts, so no
+		// quantiles or series pipeline. The cap still bounds the grid a query with a
+		// stray label (not grouped by le) would multiply.
+		matrix = capSeries(matrix, log)
+		hm := params.heatmap(matrix)
+		params.title = graph.title(matrix, log)
+		switch format {
+		case formatJSON:
+			resp := historyResponse(graph, start, end, step, nil)
+			resp.Title = params.title
+			resp.Heatmap = hm.response()
+			resp.Annotations = annotationsResponse(events)
+			writeJSONOr(w, log, id, http.StatusOK, resp)
+			return
+		case formatCSV:
+			writeCSV(w, log, id, hm.csv(log))
+			return
+		case formatPrometheus:
+			writePrometheus(w, log, id, queryResult(matrix)) // the bucket series as queried
+			return
+		}
+		img, err = renderSchemes(params, func(p chartParams) ([]byte, error) { return renderHeatmap(hm, p) })
+	} else {
+		if format == formatPrometheus {
+			// A query_range response holds what the queries returned: no quantiles,
+			// series pipeline, or compare series.
+			writePrometheus(w, log, id, capSeries(queryResult(matrix), log))
+			return
+		}
+		// The pipeline and cap pick the current series; their compare series follow,
+		// and the exports cap the two together.
+		current, previous := splitCompare(expandHistograms(matrix, graph.quantiles))
+		current = capSeries(graph.series.apply(current, log), log)
+		previous, _ = shadowing(current, previous)
+		matrix = append(current, previous...)
+		params.title = graph.title(current, log)
+		switch format {
+		case formatJSON:
+			resp := historyResponse(graph, start, end, step, capSeries(matrix, log))
+			resp.Title = params.title
+			if n := jsonDownsample(r.URL.Query().Get("downsample")); n > 0 {
+				resp.downsample(cmp.Or(graph.Downsample, config.DownsampleLTTB), n)
+			}
+			resp.Annotations = annotationsResponse(events)
+			writeJSONOr(w, log, id, http.StatusOK, resp)
+			return
+		case formatCSV:
+			writeCSV(w, log, id, params.seriesCSV(capSeries(matrix, log)))
+			return
+		}
+		render := renderChart
+		if params.mode == config.ModeSparkline {
+			render = renderSparkline
+		}
+		img, err = renderSchemes(params, func(p chartParams) ([]byte, error) { return render(matrix, p) })
 	}
-
-	params := graph.defaults.withOverrides(r)
-	img, err := renderChart(matrix, params)
 	if err != nil {
 		log.Error("error rendering chart", "error", err)
 		h.errorResponse(w, format, id, "Render Error", http.StatusInternalServerError)
@@ -96,6 +175,25 @@ func (h *Handler) serveGraph(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(img)
 }
 
+// title is the graph's title for a request: its titleExpr evaluated over the
+// matrix's first series' latest finite sample or, without one or on failure, the
+// static title.
+func (g *resolvedGraph) title(matrix model.Matrix, log *slog.Logger) string {
+	title := displayTitle(g.Title, g.ID)
+	if g.titleProg == nil {
+		return title
+	}
+	vector := reduceMatrix(matrix, config.ReduceLast)
+	if len(vector) == 0 {
+		return title
+	}
+	sample := vector[0]
+	return evalStringOr(g.titleProg, exprVars{
+		result: sampleResult(sample), labels: seriesLabels(sample.Metric),
+		timestamp: sample.Timestamp.Time(), now: time.Now(), histogram: sample.Histogram,
+	}, title, "title", log)
+}
+
 // capSeries truncates a matrix to maxGraphSeries, logging when it drops series so a
 // silent truncation doesn't read as "this is the whole result".
 func capSeries(matrix model.Matrix, log *slog.Logger) model.Matrix {
@@ -106,21 +204,55 @@ func capSeries(matrix model.Matrix, log *slog.Logger) model.Matrix {
 	return matrix[:maxGraphSeries]
 }
 
+// seriesStats summarizes a series' (finite) data points, or returns nil when there
+// are none.
+func seriesStats(data []HistoryDataPoint) *HistoryStats {
+	if len(data) == 0 {
+		return nil
+	}
+	values := make([]float64, len(data))
+	var sum float64
+	for i, p := range data {
+		values[i] = p.V
+		sum += p.V
+	}
+	slices.Sort(values)
+	n := len(values)
+	median := values[n/2]
+	if n%2 == 0 {
+		median = (values[n/2-1] + values[n/2]) / 2
+	}
+	return &HistoryStats{Min: values[0], Max: values[n-1], Avg: sum / float64(n), Median: median}
+}
+
+// finitePoints is a series' samples as JSON data points, skipping non-finite ones:
+// encoding/json errors on NaN/Inf (a single such sample would 500 the whole
+// response), and the chart renders them as gaps.
+func finitePoints(stream *model.SampleStream) []HistoryDataPoint {
+	data := make([]HistoryDataPoint, 0, len(stream.Values))
+	for _, point := range stream.Values {
+		v := float64(point.Value)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		data = append(data, HistoryDataPoint{T: int64(point.Timestamp) / 1000, V: v})
+	}
+	return data
+}
+
 // historyResponse builds the JSON time-series payload from a query matrix.
 func historyResponse(graph *resolvedGraph, start, end time.Time, step time.Duration, matrix model.Matrix) HistoryResponse {
 	series := make([]HistorySeries, 0, len(matrix))
 	for _, stream := range matrix {
-		data := make([]HistoryDataPoint, 0, len(stream.Values))
-		for _, point := range stream.Values {
-			v := float64(point.Value)
-			// Skip non-finite samples: encoding/json errors on NaN/Inf (a single such
-			// sample would 500 the whole response), and the chart renders them as gaps.
-			if math.IsNaN(v) || math.IsInf(v, 0) {
-				continue
-			}
-			data = append(data, HistoryDataPoint{T: int64(point.Timestamp) / 1000, V: v})
-		}
-		series = append(series, HistorySeries{Labels: labelMap(stream.Metric), Data: data})
+		data := finitePoints(stream)
+		series = append(series, HistorySeries{
+			Name:    graph.defaults.seriesLabel(stream.Metric),
+			Query:   string(stream.Metric[queryLabel]),
+			Compare: string(stream.Metric[compareLabel]),
+			Labels:  seriesLabels(stream.Metric),
+			Data:    data,
+			Stats:   seriesStats(data),
+		})
 	}
 	return HistoryResponse{
 		ID:     graph.ID,
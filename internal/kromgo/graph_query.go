@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/home-operations/kromgo/internal/config"
@@ -15,6 +16,12 @@ import (
 // The helpers below are the windowed range-query foundation shared by the graph
 // output formats: parameter parsing, window validation, and the range query itself.
 
+// queryLabel tags each series of a multi-query graph with its query's name as the
+// results are merged, so the legend, y-axis choice, and JSON can tell the queries
+// apart. Prometheus never returns a "__"-prefixed label other than __name__, so it
+// can't collide.
+const queryLabel model.LabelName = "__query__"
+
 var (
 	errStartAfterEnd       = errors.New("start must be before end")
 	errNonPositiveDuration = errors.New("last must be a positive duration")
@@ -90,20 +97,110 @@ func (h *Handler) validateGraphAccess(w http.ResponseWriter, r *http.Request, gr
 	return start, end, step, true
 }
 
-// queryMatrix runs a range query and asserts the result is a matrix, writing an error
-// response and returning ok=false otherwise.
-func (h *Handler) queryMatrix(w http.ResponseWriter, r *http.Request, graph *resolvedGraph, start, end time.Time, step time.Duration, log *slog.Logger) (model.Matrix, bool) {
-	value, err := h.prom.QueryRange(r.Context(), graph.Query, v1.Range{Start: start, End: end, Step: step})
-	if err != nil {
-		log.Error("error executing range query", "error", err)
-		h.errorResponse(w, graphFormat(r), graph.ID, "Query Error", http.StatusInternalServerError)
-		return nil, false
+// queryMatrix runs the graph's range queries, their compare queries (the same
+// queries over the window shifted back by compare, when it's set), and the annotation
+// queries concurrently. It merges the range queries' matrices in query order, tagging
+// a named query's series with queryLabel, followed by the compare queries' re-stamped
+// onto the window (see restamp). Any failed range query, or one that doesn't return a
+// matrix, writes an error response and returns ok=false; a failed compare or
+// annotation query is logged and its series or events left out, so the graph still
+// renders. Each annotation query's series are capped like the graph's (capSeries).
+func (h *Handler) queryMatrix(w http.ResponseWriter, r *http.Request, graph *resolvedGraph, start, end time.Time, step, compare time.Duration, log *slog.Logger) (model.Matrix, []annotationEvent, bool) {
+	rng := v1.Range{Start: start, End: end, Step: step}
+	values := make([]model.Value, len(graph.queries))
+	errs := make([]error, len(graph.queries))
+	var cmpValues []model.Value
+	var cmpErrs []error
+	annValues := make([]model.Value, len(graph.annotations))
+	annErrs := make([]error, len(graph.annotations))
+	var wg sync.WaitGroup
+	for i, q := range graph.queries {
+		wg.Go(func() {
+			values[i], errs[i] = h.prom.QueryRange(r.Context(), q.query, rng)
+		})
+	}
+	if compare > 0 {
+		cmpValues = make([]model.Value, len(graph.queries))
+		cmpErrs = make([]error, len(graph.queries))
+		shifted := v1.Range{Start: start.Add(-compare), End: end.Add(-compare), Step: step}
+		for i, q := range graph.queries {
+			wg.Go(func() {
+				cmpValues[i], cmpErrs[i] = h.prom.QueryRange(r.Context(), q.query, shifted)
+			})
+		}
+	}
+	for i, a := range graph.annotations {
+		wg.Go(func() {
+			annValues[i], annErrs[i] = h.prom.QueryRange(r.Context(), a.query, rng)
+		})
+	}
+	wg.Wait()
+
+	var merged model.Matrix
+	for i, q := range graph.queries {
+		log := log
+		if q.name != "" {
+			log = log.With("query", q.name)
+		}
+		if errs[i] != nil {
+			log.Error("error executing range query", "error", errs[i])
+			h.errorResponse(w, graphFormat(r), graph.ID, "Query Error", http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		matrix, ok := values[i].(model.Matrix)
+		if !ok {
+			log.Error("range query did not return a matrix", "type", values[i].Type().String())
+			h.errorResponse(w, graphFormat(r), graph.ID, "Unexpected result type", http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		tagQuery(matrix, q.name)
+		merged = append(merged, matrix...)
+	}
+
+	for i, q := range graph.queries[:len(cmpValues)] {
+		log := log.With("compare", compare.String())
+		if q.name != "" {
+			log = log.With("query", q.name)
+		}
+		if cmpErrs[i] != nil {
+			log.Warn("error executing compare query", "error", cmpErrs[i])
+			continue
+		}
+		matrix, ok := cmpValues[i].(model.Matrix)
+		if !ok {
+			log.Warn("compare query did not return a matrix", "type", cmpValues[i].Type().String())
+			continue
+		}
+		tagQuery(matrix, q.name)
+		restamp(matrix, compare)
+		merged = append(merged, matrix...)
+	}
+
+	var events []annotationEvent
+	for i, a := range graph.annotations {
+		log := log.With("annotation", i)
+		if annErrs[i] != nil {
+			log.Warn("error executing annotation query", "error", annErrs[i])
+			continue
+		}
+		matrix, ok := annValues[i].(model.Matrix)
+		if !ok {
+			log.Warn("annotation query did not return a matrix", "type", annValues[i].Type().String())
+			continue
+		}
+		events = append(events, a.events(capSeries(matrix, log), step)...)
+	}
+	sortEvents(events)
+	return merged, events, true
+}
+
+// tagQuery labels a named query's series with its name (queryLabel).
+func tagQuery(matrix model.Matrix, name string) {
+	if name == "" {
+		return
 	}
-	matrix, ok := value.(model.Matrix)
-	if !ok {
-		log.Error("range query did not return a matrix", "type", value.Type().String())
-		h.errorResponse(w, graphFormat(r), graph.ID, "Unexpected result type", http.StatusInternalServerError)
-		return nil, false
+	for _, stream := range matrix {
+		stream.Metric = stream.Metric.Clone()
+		stream.Metric[queryLabel] = model.LabelValue(name)
 	}
-	return matrix, true
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -135,7 +136,7 @@ func TestResolveGraph_MaxDuration(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			rg, err := resolveGraph(tc.graph, tc.def, env)
+			rg, err := resolveGraph(tc.graph, tc.def, nil, env)
 			require.NoError(t, err)
 			assert.Equal(t, tc.want, rg.maxDuration)
 		})
@@ -146,7 +147,7 @@ func TestResolveGraph_InvalidTheme(t *testing.T) {
 	t.Parallel()
 	env, err := newCELEnv()
 	require.NoError(t, err)
-	_, err = resolveGraph(config.Graph{ID: "t", Query: "q", Theme: "nope"}, config.Defaults{}, env)
+	_, err = resolveGraph(config.Graph{ID: "t", Query: "q", Theme: "nope"}, config.Defaults{}, nil, env)
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "theme")
 }
@@ -155,7 +156,7 @@ func TestResolveGraph_DefaultParams(t *testing.T) {
 	t.Parallel()
 	env, err := newCELEnv()
 	require.NoError(t, err)
-	rg, err := resolveGraph(config.Graph{ID: "t"}, config.Defaults{}, env)
+	rg, err := resolveGraph(config.Graph{ID: "t"}, config.Defaults{}, nil, env)
 	require.NoError(t, err)
 	assert.Equal(t, defaultGraphWidth, rg.defaults.width)
 	assert.Equal(t, defaultGraphHeight, rg.defaults.height)
@@ -173,61 +174,140 @@ func TestResolveGraph_MarkLine(t *testing.T) {
 	require.NoError(t, err)
 
 	// Valid types resolve onto the params.
-	rg, err := resolveGraph(config.Graph{ID: "g", Query: "q", MarkLine: []string{"average", "max"}}, config.Defaults{}, env)
+	rg, err := resolveGraph(config.Graph{ID: "g", Query: "q", MarkLine: []string{"average", "max"}}, config.Defaults{}, nil, env)
 	require.NoError(t, err)
 	assert.Equal(t, []string{"average", "max"}, rg.defaults.markLines)
 
-	// defaults.graph.markLine applies when the graph doesn't set its own.
-	rg, err = resolveGraph(config.Graph{ID: "g", Query: "q"}, config.Defaults{Graph: config.GraphDefaults{MarkLine: []string{"average"}}}, env)
+	// defaults.graph.markLine (and markLineMatch) apply when the graph doesn't set its own.
+	def := config.Defaults{Graph: config.GraphDefaults{MarkLine: []string{"average"}, MarkLineMatch: map[string]string{"job": "node"}}}
+	rg, err = resolveGraph(config.Graph{ID: "g", Query: "q"}, def, nil, env)
 	require.NoError(t, err)
 	assert.Equal(t, []string{"average"}, rg.defaults.markLines)
+	assert.Equal(t, map[string]string{"job": "node"}, rg.defaults.markMatch)
+
+	rg, err = resolveGraph(config.Graph{ID: "g", Query: "q", MarkLineMatch: map[string]string{"instance": "a"}}, def, nil, env)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"instance": "a"}, rg.defaults.markMatch)
 
 	// An unknown mark type fails at resolve (startup), not on a request.
-	_, err = resolveGraph(config.Graph{ID: "g", Query: "q", MarkLine: []string{"p99"}}, config.Defaults{}, env)
+	_, err = resolveGraph(config.Graph{ID: "g", Query: "q", MarkLine: []string{"p99"}}, config.Defaults{}, nil, env)
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "markLine")
 }
 
+func TestResolveGraph_TimeAxis(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+	def := config.Defaults{Graph: config.GraphDefaults{
+		Timezone:    "Europe/Berlin",
+		TimeFormats: config.TimeFormats{Hour: "15h", Day: "Jan 2"},
+	}}
+	rg, err := resolveGraph(config.Graph{ID: "g", Query: "q", TimeFormats: config.TimeFormats{Hour: "3pm"}}, def, nil, env)
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Berlin", rg.defaults.timeZone().String())
+	assert.Equal(t, "3pm", rg.defaults.labelLayout(unitHour), "the graph's layout wins")
+	assert.Equal(t, "Jan 2", rg.defaults.labelLayout(unitDay), "merged field by field")
+	assert.Equal(t, "15:04", rg.defaults.labelLayout(unitMinute))
+
+	rg, err = resolveGraph(config.Graph{ID: "g", Query: "q", Timezone: "Asia/Tokyo"}, def, nil, env)
+	require.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", rg.defaults.timeZone().String())
+}
+
 func TestResolveGraph_ValueExpr(t *testing.T) {
 	t.Parallel()
 	env, err := newCELEnv()
 	require.NoError(t, err)
 
 	// A per-graph valueExpr compiles into a y-axis tick formatter.
-	rg, err := resolveGraph(config.Graph{ID: "t", Query: "q", ValueExpr: `string(int(result)) + " pods"`}, config.Defaults{}, env)
+	rg, err := resolveGraph(config.Graph{ID: "t", Query: "q", ValueExpr: `string(int(result)) + " pods"`}, config.Defaults{}, nil, env)
 	require.NoError(t, err)
 	require.NotNil(t, rg.defaults.valueFormatter)
 	assert.Equal(t, "42 pods", rg.defaults.valueFormatter(42.0))
 	assert.Equal(t, "42 pods", rg.defaults.valueFormatter(42.7), "int() truncates the float tick")
 
 	// defaults.graph.valueExpr applies when the graph doesn't set its own.
-	rg, err = resolveGraph(config.Graph{ID: "t", Query: "q"}, config.Defaults{Graph: config.GraphDefaults{ValueExpr: "humanizeBytes(result)"}}, env)
+	rg, err = resolveGraph(config.Graph{ID: "t", Query: "q"}, config.Defaults{Graph: config.GraphDefaults{ValueExpr: "humanizeBytes(result)"}}, nil, env)
 	require.NoError(t, err)
 	require.NotNil(t, rg.defaults.valueFormatter)
 	assert.Equal(t, "1.5MB", rg.defaults.valueFormatter(1500000))
 
 	// A per-graph valueExpr overrides the default.
-	rg, err = resolveGraph(config.Graph{ID: "t", Query: "q", ValueExpr: "string(int(result))"}, config.Defaults{Graph: config.GraphDefaults{ValueExpr: "humanizeBytes(result)"}}, env)
+	rg, err = resolveGraph(config.Graph{ID: "t", Query: "q", ValueExpr: "string(int(result))"}, config.Defaults{Graph: config.GraphDefaults{ValueExpr: "humanizeBytes(result)"}}, nil, env)
 	require.NoError(t, err)
 	assert.Equal(t, "1500000", rg.defaults.valueFormatter(1500000))
 
 	// A malformed expression fails at resolve (startup), not on a request.
-	_, err = resolveGraph(config.Graph{ID: "t", Query: "q", ValueExpr: "nope("}, config.Defaults{}, env)
+	_, err = resolveGraph(config.Graph{ID: "t", Query: "q", ValueExpr: "nope("}, config.Defaults{}, nil, env)
 	require.Error(t, err)
 
 	// An expression that compiles but returns a non-string is rejected too.
-	_, err = resolveGraph(config.Graph{ID: "t", Query: "q", ValueExpr: "result + 1.0"}, config.Defaults{}, env)
+	_, err = resolveGraph(config.Graph{ID: "t", Query: "q", ValueExpr: "result + 1.0"}, config.Defaults{}, nil, env)
+	require.Error(t, err)
+}
+
+func TestResolveGraph_Queries(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+
+	// A single-query graph runs its query unnamed, on the left axis alone.
+	rg, err := resolveGraph(config.Graph{ID: "t", Query: "q"}, config.Defaults{}, nil, env)
+	require.NoError(t, err)
+	assert.Equal(t, []graphQuery{{query: "q"}}, rg.queries)
+	assert.Empty(t, rg.defaults.rightQueries)
+
+	rg, err = resolveGraph(config.Graph{ID: "t", ValueExpr: `string(int(result)) + "°C"`, Queries: []config.GraphQuery{
+		{Name: "temp", Query: "a"},
+		{Name: "fan", Query: "b", Axis: config.AxisRight, ValueExpr: `string(int(result)) + " rpm"`},
+		{Name: "pump", Query: "c", Axis: config.AxisRight, ValueExpr: `string(int(result)) + " l/h"`},
+	}}, config.Defaults{}, nil, env)
+	require.NoError(t, err)
+	assert.Equal(t, []graphQuery{{name: "temp", query: "a"}, {name: "fan", query: "b"}, {name: "pump", query: "c"}}, rg.queries)
+	assert.Equal(t, map[string]bool{"fan": true, "pump": true}, rg.defaults.rightQueries)
+	// The left axis falls back to the graph's valueExpr; the right uses fan's own, the
+	// first set on it.
+	assert.Equal(t, "40°C", rg.defaults.valueFormatter(40))
+	assert.Equal(t, "900 rpm", rg.defaults.rightValueFormatter(900))
+	// Each query's series keep their own.
+	pump := model.Metric{queryLabel: "pump"}
+	assert.Equal(t, "3 l/h", rg.defaults.seriesFormatter(pump)(3))
+	assert.Equal(t, "900 rpm", rg.defaults.axisFormatter(pump)(900))
+	assert.Equal(t, "40°C", rg.defaults.seriesFormatter(model.Metric{queryLabel: "temp"})(40), "the axis' without its own")
+
+	// A query's malformed valueExpr fails at resolve too.
+	_, err = resolveGraph(config.Graph{ID: "t", Queries: []config.GraphQuery{{Name: "a", Query: "q", ValueExpr: "nope("}}}, config.Defaults{}, nil, env)
 	require.Error(t, err)
 }
 
+func TestResolveGraph_LegendExpr(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+
+	rg, err := resolveGraph(config.Graph{ID: "t", Query: "q", LegendExpr: "labels.instance"}, config.Defaults{}, nil, env)
+	require.NoError(t, err)
+	assert.NotNil(t, rg.defaults.legendExpr)
+
+	// A malformed or non-string expression fails at resolve (startup).
+	for _, expr := range []string{"labels.", "size(labels)"} {
+		_, err = resolveGraph(config.Graph{ID: "t", Query: "q", LegendExpr: expr}, config.Defaults{}, nil, env)
+		require.Error(t, err, expr)
+		assert.Contains(t, err.Error(), "legend")
+	}
+}
+
 func TestResolveBadge_InvalidExprFailsFast(t *testing.T) {
 	t.Parallel()
 	env, err := newCELEnv()
 	require.NoError(t, err)
 	cases := map[string]config.Badge{
-		"syntax error":  {ID: "a", Query: "q", ValueExpr: "result +"},
-		"not a string":  {ID: "b", Query: "q", ValueExpr: "result"},       // value must be string
-		"unknown ident": {ID: "c", Query: "q", ColorExpr: "nope(result)"}, // bad color expr
+		"syntax error":       {ID: "a", Query: "q", ValueExpr: "result +"},
+		"not a string":       {ID: "b", Query: "q", ValueExpr: "result"},       // value must be string
+		"unknown ident":      {ID: "c", Query: "q", ColorExpr: "nope(result)"}, // bad color expr
+		"title not a string": {ID: "d", Query: "q", TitleExpr: "result"},
+		"bad label color":    {ID: "e", Query: "q", LabelColorExpr: "nope(result)"},
 	}
 	for name, b := range cases {
 		t.Run(name, func(t *testing.T) {
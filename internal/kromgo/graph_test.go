@@ -56,8 +56,43 @@ func TestHistoryResponse_SkipsNonFinite(t *testing.T) {
 	assert.Equal(t, 1.5, data[0].V)
 	assert.Equal(t, int64(1), data[0].T, "ms timestamp is divided to seconds")
 	assert.Equal(t, 2.5, data[1].V)
+	assert.Equal(t, "a", resp.Series[0].Name)
+	assert.Equal(t, &HistoryStats{Min: 1.5, Max: 2.5, Avg: 2, Median: 2}, resp.Series[0].Stats,
+		"stats cover the finite samples only")
 
 	// The payload must marshal — a surviving NaN/Inf would make json.Marshal fail.
 	_, err := json.Marshal(resp)
 	require.NoError(t, err)
 }
+
+func TestHistoryResponse_LegendExpr(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+	rg, err := resolveGraph(config.Graph{ID: "g", Query: "q", LegendExpr: `labels.pod + " on " + labels.node`}, config.Defaults{}, nil, env)
+	require.NoError(t, err)
+	matrix := model.Matrix{{Metric: model.Metric{"pod": "<a>", "node": "n1"}}}
+
+	resp := historyResponse(rg, time.Unix(0, 0), time.Unix(10, 0), time.Minute, matrix)
+
+	require.Len(t, resp.Series, 1)
+	assert.Equal(t, "<a> on n1", resp.Series[0].Name, "raw in JSON; encoding/json escapes the markup")
+	out, err := json.Marshal(resp)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"name":"\u003ca\u003e on n1"`)
+}
+
+func TestSeriesStats(t *testing.T) {
+	t.Parallel()
+	points := func(vs ...float64) []HistoryDataPoint {
+		data := make([]HistoryDataPoint, len(vs))
+		for i, v := range vs {
+			data[i] = HistoryDataPoint{T: int64(i), V: v}
+		}
+		return data
+	}
+	assert.Nil(t, seriesStats(nil), "no finite samples, no stats")
+	assert.Equal(t, &HistoryStats{Min: 1, Max: 9, Avg: 4, Median: 2}, seriesStats(points(9, 1, 2)))
+	// An even count's median is the mean of the middle two.
+	assert.Equal(t, &HistoryStats{Min: 1, Max: 4, Avg: 2.5, Median: 2.5}, seriesStats(points(4, 1, 3, 2)))
+}
@@ -51,9 +51,13 @@ func New(cfg config.KromgoConfig, prom *prometheus.Client) (*Handler, error) {
 		badges[b.ID] = rb
 	}
 
+	themes, err := resolveThemes(cfg.Themes)
+	if err != nil {
+		return nil, err
+	}
 	graphs := make(map[string]*resolvedGraph, len(cfg.Graphs))
 	for _, g := range cfg.Graphs {
-		rg, err := resolveGraph(g, cfg.Defaults, env)
+		rg, err := resolveGraph(g, cfg.Defaults, themes, env)
 		if err != nil {
 			return nil, err
 		}
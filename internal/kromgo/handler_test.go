@@ -1,17 +1,23 @@
 package kromgo
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/home-operations/kromgo/internal/config"
 	"github.com/home-operations/kromgo/internal/prometheus"
 	"github.com/home-operations/kromgo/internal/promtest"
 	promclient "github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -162,6 +168,78 @@ func TestNew_InvalidIconFailsFast(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestNew_Themes(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "1", []float64{10, 20, 15, 30})
+	cfg := baseConfig()
+	cfg.Themes = map[string]config.Theme{"paper": testTheme}
+	cfg.Graphs[0].Theme = "paper"
+	h := newHandlerForTest(t, cfg, srv.URL)
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h")
+	assert.Contains(t, w.Body.String(), "rgb(253,246,227)") // paper background
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?theme=dracula&last=1h")
+	assert.Contains(t, w.Body.String(), "rgb(40,42,54)") // ?theme= still picks a built-in
+
+	client, err := prometheus.New(srv.URL, 0)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		name   string
+		themes map[string]config.Theme
+		graph  string
+	}{
+		{"shadows built-in", map[string]config.Theme{"dracula": testTheme}, ""},
+		{"unknown graph theme", nil, "paper"},
+	} {
+		cfg := baseConfig()
+		cfg.Themes = tc.themes
+		cfg.Graphs[0].Theme = tc.graph
+		_, err = New(cfg, client)
+		assert.Error(t, err, tc.name)
+	}
+}
+
+func TestServe_ColorScheme(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "17.5", []float64{10, 20, 15, 30})
+	cfg := baseConfig()
+	cfg.Defaults.Badge.LabelColorDark = "#24292f"
+	cfg.Badges[0].ColorExprDark = `result > 10.0 ? "orange" : "green"`
+	cfg.Graphs[0].ThemeDark = "dracula"
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	badge := promtest.Get(t, h.Mux(), "/badges/cpu").Body.String()
+	assert.Contains(t, badge, "prefers-color-scheme: dark")
+	assert.Contains(t, badge, ".d-cpu{fill:#24292f}")
+	assert.Contains(t, badge, ".dm-cpu{fill:#fe7d37}", "the message's dark color, from the sample")
+
+	graph := promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h").Body.String()
+	assert.Contains(t, graph, schemeStyle)
+	assert.Contains(t, graph, "rgb(40,42,54)") // dracula background, in the dark rendering
+	png := promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h&format=png")
+	assert.Equal(t, "image/png", png.Header().Get("Content-Type"))
+
+	cfg = baseConfig()
+	cfg.Graphs[0].ThemeDark = "nope"
+	client, err := prometheus.New(srv.URL, 0)
+	require.NoError(t, err)
+	_, err = New(cfg, client)
+	assert.ErrorContains(t, err, `unknown themeDark "nope"`)
+}
+
+func TestServe_Tooltips(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "17.5", []float64{10, 20, 15, 30})
+	cfg := baseConfig()
+	cfg.Defaults.Graph.Tooltips = new(true)
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	graph := promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h").Body.String()
+	assert.Contains(t, graph, tooltipStyle)
+	assert.Contains(t, graph, "<title>")
+	off := promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h&tooltips=false").Body.String()
+	assert.NotContains(t, off, "kromgo-tip")
+}
+
 func TestRoutes_NonGETRejected(t *testing.T) {
 	t.Parallel()
 	srv := mockProm(t, "17.5", nil)
@@ -230,6 +308,29 @@ func TestServeBadge_Expressions(t *testing.T) {
 			wantCode: http.StatusOK,
 			contains: []string{`"message":"Healthy"`, `"color":"green"`},
 		},
+		{
+			name: "title and label color expressions",
+			badge: config.Badge{
+				ID: "load", Title: "load", Query: "q",
+				TitleExpr: `labels.instance`, LabelColorExpr: `result > 50.0 ? "red" : "blue"`,
+			},
+			sample:   promtest.Scalar("80", map[string]string{"instance": "node-1"}),
+			path:     "/badges/load?format=json",
+			wantCode: http.StatusOK,
+			contains: []string{`"title":"node-1"`, `"labelColor":"#e05d44"`},
+		},
+		{
+			// A failing title or label color expression keeps the static value.
+			name: "failing title expression keeps title",
+			badge: config.Badge{
+				ID: "load", Title: "load", LabelColor: "blue", Query: "q",
+				TitleExpr: `labels["instance"]`, LabelColorExpr: `labels["color"]`,
+			},
+			sample:   promtest.Scalar("80", map[string]string{"job": "node"}),
+			path:     "/badges/load?format=shields",
+			wantCode: http.StatusOK,
+			contains: []string{`"label":"load"`, `"labelColor":"#007ec6"`, `"message":"80"`},
+		},
 		{
 			name:     "empty vector renders no data",
 			badge:    baseConfig().Badges[0],
@@ -270,6 +371,192 @@ func TestServeBadge_Expressions(t *testing.T) {
 	}
 }
 
+// TestServeBadge_Staleness covers timestamp, now, and maxAge on range badges, whose
+// timestamp is that of the last sample rather than the evaluation time.
+func TestServeBadge_Staleness(t *testing.T) {
+	t.Parallel()
+	stream := func(age time.Duration) []any {
+		last := time.Now().Add(-age).Unix()
+		return []any{map[string]any{
+			"metric": map[string]string{"instance": "a"},
+			"values": []any{[]any{last - 60, "41"}, []any{last, "42"}},
+		}}
+	}
+	rangeBadge := func(valueExpr, maxAge string) config.Badge {
+		return config.Badge{
+			ID: "b", Type: config.TypeRange, Query: "q", ValueExpr: valueExpr, MaxAge: maxAge,
+			Range: &config.RangeQuery{Last: "1d"},
+		}
+	}
+	cases := []struct {
+		name     string
+		badge    config.Badge
+		age      time.Duration
+		path     string
+		contains []string
+	}{
+		{
+			name:     "sample age from timestamp and now",
+			badge:    rangeBadge(`string(int((now - timestamp) / 3600.0)) + "h"`, ""),
+			age:      2 * time.Hour,
+			path:     "/badges/b?format=shields",
+			contains: []string{`"message":"2h"`},
+		},
+		{
+			name:     "sample older than maxAge renders stale",
+			badge:    rangeBadge("", "10m"),
+			age:      time.Hour,
+			path:     "/badges/b?format=json",
+			contains: []string{`"value":"stale"`, `"color":"lightgrey"`, `"stale":true`, `"timestamp":`},
+		},
+		{
+			name:     "fresh sample within maxAge renders value",
+			badge:    rangeBadge("", "10m"),
+			age:      time.Minute,
+			path:     "/badges/b?format=shields",
+			contains: []string{`"message":"42"`},
+		},
+		{
+			name: "age is measured from an offset window's end",
+			badge: func() config.Badge {
+				b := rangeBadge("", "10m")
+				b.Range.Offset = "1h"
+				return b
+			}(),
+			age:      time.Hour + time.Minute,
+			path:     "/badges/b?format=shields",
+			contains: []string{`"message":"42"`},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv := promtest.Result(t, "matrix", stream(tc.age))
+			h := newHandlerForTest(t, config.KromgoConfig{Badges: []config.Badge{tc.badge}}, srv.URL)
+
+			w := promtest.Get(t, h.Mux(), tc.path)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			for _, want := range tc.contains {
+				assert.Contains(t, w.Body.String(), want)
+			}
+		})
+	}
+}
+
+// TestServeBadge_ResultTypes covers instant queries that don't return a vector:
+// scalars and strings go through the same CEL pipeline, and a matrix (a range
+// selector or subquery) is reduced with the badge's reducer.
+func TestServeBadge_ResultTypes(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Unix()
+	matrix := []any{map[string]any{
+		"metric": map[string]string{"instance": "a"},
+		"values": []any{[]any{now - 120, "10"}, []any{now - 60, "40"}, []any{now, "25"}},
+	}}
+	// Native histograms: 4 observations in (0,1] and 6 in (1,2], so p70 is 1.5.
+	hist := map[string]any{"count": "10", "sum": "12", "buckets": []any{
+		[]any{0, "0", "1", "4"}, []any{0, "1", "2", "6"},
+	}}
+	histVector := []any{map[string]any{"metric": map[string]string{}, "histogram": []any{now, hist}}}
+	histMatrix := []any{map[string]any{"metric": map[string]string{}, "histograms": []any{[]any{now - 60, hist}, []any{now, hist}}}}
+	cases := []struct {
+		name       string
+		badge      config.Badge
+		resultType string
+		result     any
+		want       string
+	}{
+		{"scalar", config.Badge{ID: "b", Query: "time()", ValueExpr: `humanizeFloat(result)`}, "scalar", []any{now, "1700000000"}, `"message":"1700000000"`},
+		{"string default value", config.Badge{ID: "b", Query: `"v1.2.3"`}, "string", []any{now, "v1.2.3"}, `"message":"v1.2.3"`},
+		{"numeric string", config.Badge{ID: "b", Query: `"42"`, ValueExpr: `string(result * 2.0)`}, "string", []any{now, "42"}, `"message":"84"`},
+		{"matrix default last", config.Badge{ID: "b", Query: "up[5m]"}, "matrix", matrix, `"message":"25"`},
+		{"matrix reduce max", config.Badge{ID: "b", Query: "up[5m]", Reduce: config.ReduceMax}, "matrix", matrix, `"message":"40"`},
+		{"histogram count as result", config.Badge{ID: "b", Query: "h"}, "vector", histVector, `"message":"10"`},
+		{"histogram quantile", config.Badge{ID: "b", Query: "h", ValueExpr: `string(histogramQuantile(0.7))`}, "vector", histVector, `"message":"1.5"`},
+		{"histogram sum", config.Badge{ID: "b", Query: "h", ValueExpr: `string(histogram.sum / histogram.count)`}, "vector", histVector, `"message":"1.2"`},
+		{"histogram matrix", config.Badge{ID: "b", Query: "h[5m]", ValueExpr: `string(histogramQuantile(0.7))`}, "matrix", histMatrix, `"message":"1.5"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv := promtest.Result(t, tc.resultType, tc.result)
+			h := newHandlerForTest(t, config.KromgoConfig{Badges: []config.Badge{tc.badge}}, srv.URL)
+
+			w := promtest.Get(t, h.Mux(), "/badges/b?format=shields")
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), tc.want)
+		})
+	}
+}
+
+func TestServeBadge_Fallbacks(t *testing.T) {
+	t.Parallel()
+	failing := func(t *testing.T) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	empty := func(t *testing.T) *httptest.Server { return promtest.Result(t, "vector", []any{}) }
+	zero := 0.0
+	cases := []struct {
+		name      string
+		badge     config.Badge
+		defaults  config.BadgeDefaults
+		server    func(t *testing.T) *httptest.Server
+		path      string
+		wantCode  int
+		wantBody  []string
+		wantCache string
+	}{
+		{"no data default", config.Badge{}, config.BadgeDefaults{}, empty, "?format=shields",
+			http.StatusOK, []string{`"message":"no data"`}, "public, max-age=300, s-maxage=300"},
+		{"no data message and status", config.Badge{OnNoData: config.BadgeFallback{Message: "none", Color: "blue", Status: http.StatusNotFound}},
+			config.BadgeDefaults{}, empty, "?format=json", http.StatusNotFound, []string{`"value":"none"`, `"color":"blue"`}, "public, max-age=300, s-maxage=300"},
+		{"no data status ignored for svg", config.Badge{OnNoData: config.BadgeFallback{Status: http.StatusNotFound}},
+			config.BadgeDefaults{}, empty, "", http.StatusOK, []string{`aria-label="b: no data"`}, "public, max-age=300, s-maxage=300"},
+		{"no data value through valueExpr", config.Badge{ValueExpr: `string(result) + " alerts"`, ColorExpr: `result == 0.0 ? "green" : "red"`, OnNoData: config.BadgeFallback{Value: &zero}},
+			config.BadgeDefaults{}, empty, "?format=json", http.StatusOK, []string{`"value":"0 alerts"`, `"color":"green"`}, "public, max-age=300, s-maxage=300"},
+		{"error default", config.Badge{}, config.BadgeDefaults{}, failing, "?format=shields",
+			http.StatusInternalServerError, []string{`"message":"Query Error"`}, "no-store"},
+		{"error message and status", config.Badge{OnError: config.BadgeFallback{Message: "down", Status: http.StatusServiceUnavailable}},
+			config.BadgeDefaults{}, failing, "?format=shields", http.StatusServiceUnavailable, []string{`"message":"down"`, `"isError":true`}, "no-store"},
+		{"error color on svg", config.Badge{OnError: config.BadgeFallback{Color: "orange"}},
+			config.BadgeDefaults{}, failing, "", http.StatusOK, []string{`aria-label="b: Query Error"`, "#fe7d37"}, "no-store"},
+		{"error color on shields", config.Badge{OnError: config.BadgeFallback{Color: "orange"}},
+			config.BadgeDefaults{}, failing, "?format=shields", http.StatusInternalServerError, []string{`"message":"Query Error"`, `"color":"orange"`}, "no-store"},
+		{"error color on json", config.Badge{OnError: config.BadgeFallback{Color: "orange"}},
+			config.BadgeDefaults{}, failing, "?format=json", http.StatusInternalServerError, []string{`"color":"orange"`, `"isError":true`}, "no-store"},
+		{"hide reason from defaults", config.Badge{}, config.BadgeDefaults{OnError: config.BadgeFallback{HideReason: new(true)}},
+			failing, "?format=shields", http.StatusInternalServerError, []string{`"message":"unavailable"`}, "no-store"},
+		{"badge message over hidden reason", config.Badge{OnError: config.BadgeFallback{Message: "offline"}}, config.BadgeDefaults{OnError: config.BadgeFallback{HideReason: new(true)}},
+			failing, "?format=shields", http.StatusInternalServerError, []string{`"message":"offline"`}, "no-store"},
+		{"error value is served but not cached", config.Badge{OnError: config.BadgeFallback{Value: &zero}},
+			config.BadgeDefaults{}, failing, "?format=json", http.StatusOK, []string{`"value":"0"`}, "no-store"},
+		{"hidden expression error", config.Badge{ValueExpr: `labels["missing"]`}, config.BadgeDefaults{OnError: config.BadgeFallback{HideReason: new(true)}},
+			func(t *testing.T) *httptest.Server { return mockProm(t, "1", nil) }, "?format=shields", http.StatusInternalServerError, []string{`"message":"unavailable"`}, "no-store"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.badge.ID, tc.badge.Query = "b", "q"
+			cfg := config.KromgoConfig{Defaults: config.Defaults{Badge: tc.defaults}, Badges: []config.Badge{tc.badge}}
+			h := newHandlerForTest(t, cfg, tc.server(t).URL)
+
+			w := promtest.Get(t, h.Mux(), "/badges/b"+tc.path)
+
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.Equal(t, tc.wantCache, w.Header().Get("Cache-Control"))
+			for _, want := range tc.wantBody {
+				assert.Contains(t, w.Body.String(), want)
+			}
+		})
+	}
+}
+
 func TestCacheControl(t *testing.T) {
 	t.Parallel()
 
@@ -359,6 +646,11 @@ func TestServeGraph_Output(t *testing.T) {
 			assert.Equal(t, http.StatusOK, w.Code)
 			assert.Contains(t, w.Body.String(), "rgb(40,42,54)") // dracula background
 		}},
+		{"legend table", []float64{10, 20, 15, 30}, "/graphs/cpu?legend=table&last=1h", func(t *testing.T, w *httptest.ResponseRecorder) {
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `<svg width="600" height="250" `) // a header and a row beneath the chart
+			assert.Contains(t, w.Body.String(), ">Avg<")
+		}},
 		{"window too large", []float64{1, 2}, "/graphs/cpu?format=json&last=7d", func(t *testing.T, w *httptest.ResponseRecorder) {
 			assert.Equal(t, http.StatusBadRequest, w.Code)
 		}},
@@ -379,6 +671,426 @@ func TestServeGraph_Output(t *testing.T) {
 	}
 }
 
+func TestServeGraph_TitleExpr(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "0", []float64{10, 20, 42})
+	cfg := baseConfig()
+	cfg.Graphs[0].Title = "CPU"
+	cfg.Graphs[0].TitleExpr = `"CPU <" + labels.instance + "> — " + string(int(result)) + "%"`
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h").Body.Bytes(), &resp))
+	assert.Equal(t, "CPU <a> — 42%", resp.Title, "over the latest sample")
+	svg := promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h").Body.String()
+	assert.Contains(t, svg, "CPU &lt;a&gt; — 42%", "escaped in the SVG")
+
+	// A failing expression keeps the static title.
+	cfg.Graphs[0].TitleExpr = `labels["nope"]`
+	h = newHandlerForTest(t, cfg, srv.URL)
+	require.NoError(t, json.Unmarshal(promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h").Body.Bytes(), &resp))
+	assert.Equal(t, "CPU", resp.Title)
+}
+
+func TestServeGraph_NativeHistogram(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Unix()
+	hist := map[string]any{"count": "10", "sum": "12", "buckets": []any{
+		[]any{0, "0", "1", "4"}, []any{0, "1", "2", "6"},
+	}}
+	srv := promtest.Result(t, "matrix", []any{map[string]any{
+		"metric":     map[string]string{"job": "api"},
+		"histograms": []any{[]any{now - 60, hist}, []any{now, hist}},
+	}})
+	cfg := config.KromgoConfig{Graphs: []config.Graph{{ID: "latency", Query: "h", Quantiles: []float64{0.4, 0.7}}}}
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/latency?format=json&last=1h")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Series, 2)
+	assert.Equal(t, map[string]string{"job": "api", "quantile": "0.4"}, resp.Series[0].Labels)
+	assert.Equal(t, map[string]string{"job": "api", "quantile": "0.7"}, resp.Series[1].Labels)
+	assert.Equal(t, []HistoryDataPoint{{T: now - 60, V: 1}, {T: now, V: 1}}, resp.Series[0].Data)
+	assert.Equal(t, []HistoryDataPoint{{T: now - 60, V: 1.5}, {T: now, V: 1.5}}, resp.Series[1].Data)
+}
+
+func TestServeGraph_Heatmap(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Unix()
+	bucket := func(le string, counts ...string) map[string]any {
+		return map[string]any{
+			"metric": map[string]string{"le": le},
+			"values": []any{[]any{now - 60, counts[0]}, []any{now, counts[1]}},
+		}
+	}
+	srv := promtest.Result(t, "matrix", []any{bucket("0.5", "10", "13"), bucket("+Inf", "12", "20")})
+	cfg := config.KromgoConfig{Graphs: []config.Graph{{ID: "latency", Query: "h_bucket", Chart: config.ChartHeatmap}}}
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/latency?format=json&last=1h&step=1m")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Empty(t, resp.Series)
+	require.NotNil(t, resp.Heatmap)
+	assert.Equal(t, []HeatmapBin{{Lower: 0, Upper: 0.5}, {Lower: 0.5, Upper: model.FloatString(math.Inf(1))}}, resp.Heatmap.Bins)
+	assert.Equal(t, []int64{now}, resp.Heatmap.Times)
+	assert.Equal(t, [][]float64{{3}, {5}}, resp.Heatmap.Counts)
+	assert.Contains(t, w.Body.String(), `"upper":"+Inf"`)
+
+	w = promtest.Get(t, h.Mux(), "/graphs/latency?format=csv&last=1h&step=1m")
+	assert.Equal(t, fmt.Sprintf("timestamp,0.5,+Inf\n%d,3,5\n", now), w.Body.String(), "a column per bin")
+
+	assertSVGOK(t, promtest.Get(t, h.Mux(), "/graphs/latency?last=1h&step=1m"))
+}
+
+func TestServeGraph_HeatmapSeriesCap(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Unix()
+	result := make([]any, maxGraphSeries+5)
+	for i := range result {
+		result[i] = map[string]any{
+			"metric": map[string]string{"le": strconv.Itoa(i + 1)},
+			"values": []any{[]any{now - 60, "1"}, []any{now, "2"}},
+		}
+	}
+	srv := promtest.Result(t, "matrix", result)
+	cfg := config.KromgoConfig{Graphs: []config.Graph{{ID: "latency", Query: "h_bucket", Chart: config.ChartHeatmap}}}
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/latency?format=json&last=1h&step=1m")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotNil(t, resp.Heatmap)
+	assert.Len(t, resp.Heatmap.Bins, maxGraphSeries, "a bin per kept bucket series")
+	assertSVGOK(t, promtest.Get(t, h.Mux(), "/graphs/latency?last=1h&step=1m"))
+}
+
+func TestServeGraph_CSV(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "0", []float64{1, 2.5})
+	h := newHandlerForTest(t, baseConfig(), srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?format=csv&last=1h")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"timestamp", "a"}, rows[0])
+	assert.Equal(t, "2.5", rows[2][1])
+
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?format=csv&last=48h")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "maxDuration applies")
+	assert.Equal(t, mimeJSON, w.Header().Get("Content-Type"))
+}
+
+func TestServeGraph_Prometheus(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "0", []float64{1, 2})
+	cfg := config.KromgoConfig{Graphs: []config.Graph{{ID: "api", MaxDuration: "24h", Queries: []config.GraphQuery{
+		{Name: "requests", Query: "rate(a[5m])"},
+		{Name: "errors", Query: "rate(b[5m])"},
+	}, Series: &config.SeriesTransform{Limit: 1, Other: "rest"}}}}
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/api?format=prometheus&last=1h")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string       `json:"resultType"`
+			Result     model.Matrix `json:"result"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "success", resp.Status)
+	assert.Equal(t, "matrix", resp.Data.ResultType)
+	require.Len(t, resp.Data.Result, 2, "the queries' results, without the series pipeline")
+	assert.Equal(t, model.Metric{"instance": "a"}, resp.Data.Result[0].Metric, "without the internal __query__ tag")
+	assert.Equal(t, model.SampleValue(2), resp.Data.Result[0].Values[1].Value)
+
+	w = promtest.Get(t, h.Mux(), "/graphs/api?format=prometheus&last=48h")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "maxDuration applies")
+	assert.JSONEq(t, `{"status":"error","errorType":"bad_data","error":"Requested time window exceeds maximum allowed duration"}`, w.Body.String())
+
+	w = promtest.Get(t, h.Mux(), "/graphs/nope?format=prometheus")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"errorType":"not_found"`)
+}
+
+func TestServeGraph_ExportSeriesCap(t *testing.T) {
+	t.Parallel()
+	result := make([]any, maxGraphSeries+5)
+	for i := range result {
+		result[i] = map[string]any{
+			"metric": map[string]string{"pod": strconv.Itoa(i)},
+			"values": []any{[]any{time.Now().Unix(), "1"}},
+		}
+	}
+	srv := promtest.Result(t, "matrix", result)
+	h := newHandlerForTest(t, baseConfig(), srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?format=csv&last=1h")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, rows[0], maxGraphSeries+1, "a timestamp column and a column per kept series")
+
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?format=csv&last=1h&compare=1d")
+	rows, err = csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, rows[0], maxGraphSeries+1, "the compare series count toward the cap")
+
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h&compare=1d")
+	var history HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Len(t, history.Series, maxGraphSeries, "the JSON is capped alike")
+
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?format=prometheus&last=1h")
+	var resp struct {
+		Data struct {
+			Result model.Matrix `json:"result"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Data.Result, maxGraphSeries)
+}
+
+func TestServeGraph_JSONDownsample(t *testing.T) {
+	t.Parallel()
+	values := make([]float64, 60)
+	values[17] = 99
+	srv := mockProm(t, "0", values)
+	h := newHandlerForTest(t, baseConfig(), srv.URL)
+
+	var full, thin HistoryResponse
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &full))
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h&downsample=10")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &thin))
+
+	require.Len(t, thin.Series, 1)
+	assert.Len(t, full.Series[0].Data, 60, "only on request")
+	assert.Len(t, thin.Series[0].Data, 10)
+	assert.Contains(t, thin.Series[0].Data, full.Series[0].Data[17], "the peak survives")
+	assert.Equal(t, full.Series[0].Stats, thin.Series[0].Stats, "stats cover every point")
+}
+
+func TestServeGraph_MultiQuery(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "0", []float64{1, 2, 3})
+	cfg := config.KromgoConfig{Graphs: []config.Graph{{ID: "api", Queries: []config.GraphQuery{
+		{Name: "requests", Query: "rate(a[5m])"},
+		{Name: "errors", Query: "rate(b[5m])", Axis: config.AxisRight},
+	}}}}
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/api?format=json&last=1h")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Series, 2, "merged in query order")
+	assert.Equal(t, "requests", resp.Series[0].Query)
+	assert.Equal(t, "errors", resp.Series[1].Query)
+	assert.NotContains(t, resp.Series[0].Labels, string(queryLabel), "the tag is the query field, not a label")
+
+	assertSVGOK(t, promtest.Get(t, h.Mux(), "/graphs/api?last=1h"))
+}
+
+func TestServeGraph_Sparkline(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "0", []float64{1, 3, 2})
+	h := newHandlerForTest(t, baseConfig(), srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?mode=sparkline&last=1h")
+
+	assertSVGOK(t, w)
+	assert.True(t, strings.HasPrefix(w.Body.String(), `<svg width="100" height="20" `))
+	assert.NotContains(t, w.Body.String(), "<text")
+
+	cfg := baseConfig()
+	cfg.Graphs[0].Mode = config.ModeSparkline
+	cfg.Graphs[0].Sparkline = config.Sparkline{Width: 80, Height: 16}
+	h = newHandlerForTest(t, cfg, srv.URL)
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h&format=png")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h&mode=chart")
+	assertSVGOK(t, w)
+	assert.True(t, strings.HasPrefix(w.Body.String(), `<svg width="600" height="200" `), "?mode=chart draws the full chart")
+}
+
+func TestServeGraph_Annotations(t *testing.T) {
+	t.Parallel()
+	srv := mockProm(t, "0", []float64{1, 1, 1})
+	cfg := baseConfig()
+	cfg.Graphs[0].Annotations = []config.Annotation{{Query: "changes(gen[1m]) > 0", TextExpr: `"deploy " + labels.instance`}}
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h&step=1m")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Annotations, 1, "consecutive samples are one event")
+	assert.Equal(t, "deploy a", resp.Annotations[0].Text)
+	assert.Equal(t, int64(120), resp.Annotations[0].End-resp.Annotations[0].T)
+	assert.Equal(t, map[string]string{"instance": "a"}, resp.Annotations[0].Labels)
+
+	assertSVGOK(t, promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h&step=1m"))
+}
+
+func TestServeGraph_AnnotationSeriesCap(t *testing.T) {
+	t.Parallel()
+	result := make([]any, maxGraphSeries+5)
+	for i := range result {
+		result[i] = map[string]any{
+			"metric": map[string]string{"pod": strconv.Itoa(i)},
+			"values": []any{[]any{time.Now().Unix(), "1"}},
+		}
+	}
+	srv := promtest.Result(t, "matrix", result)
+	cfg := baseConfig()
+	cfg.Graphs[0].Annotations = []config.Annotation{{Query: "kube_pod_created"}}
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Annotations, maxGraphSeries, "an event per kept series")
+}
+
+func TestServeGraph_AnnotationQueryError(t *testing.T) {
+	t.Parallel()
+	prom := mockProm(t, "0", []float64{1, 2, 3})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("query") == "broken" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		prom.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	cfg := baseConfig()
+	cfg.Graphs[0].Annotations = []config.Annotation{{Query: "broken"}}
+	h := newHandlerForTest(t, cfg, srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h")
+
+	require.Equal(t, http.StatusOK, w.Code, "a failed annotation query doesn't fail the graph")
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Series, 1)
+	assert.Empty(t, resp.Annotations)
+}
+
+// rangeAtStart is a mock Prometheus answering each range query with one series of
+// three samples a step apart from the query's start, valued 2 when the window ends
+// more than a day ago and 1 otherwise.
+func rangeAtStart(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseFloat(r.FormValue("start"), 64)
+		end, _ := strconv.ParseFloat(r.FormValue("end"), 64)
+		v := "1"
+		if time.Since(time.Unix(int64(end), 0)) > 24*time.Hour {
+			v = "2"
+		}
+		values := make([][]any, 3)
+		for i := range values {
+			values[i] = []any{start + float64(i*60), v}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": map[string]any{
+			"resultType": "matrix",
+			"result":     []any{map[string]any{"metric": map[string]string{"instance": "a"}, "values": values}},
+		}})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestServeGraph_Compare(t *testing.T) {
+	t.Parallel()
+	cfg := baseConfig()
+	cfg.Graphs[0].Compare = "7d"
+	h := newHandlerForTest(t, cfg, rangeAtStart(t).URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h&step=1m")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Series, 2)
+	assert.Equal(t, "a", resp.Series[0].Name)
+	assert.Empty(t, resp.Series[0].Compare)
+	assert.Equal(t, "a (last week)", resp.Series[1].Name)
+	assert.Equal(t, "last week", resp.Series[1].Compare)
+	assert.Equal(t, map[string]string{"instance": "a"}, resp.Series[1].Labels)
+	assert.Equal(t, resp.Series[0].Data[0].T, resp.Series[1].Data[0].T, "re-stamped onto the window")
+	assert.InDelta(t, 2, resp.Series[1].Data[0].V, 0, "from a week back")
+
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?last=1h&step=1m")
+	assertSVGOK(t, w)
+	assert.Contains(t, w.Body.String(), ">a (last week)</text>")
+
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h&compare=0")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Series, 1, "?compare=0 turns it off")
+
+	h = newHandlerForTest(t, baseConfig(), rangeAtStart(t).URL)
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?format=csv&last=1h&compare=1d")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"timestamp", "a", "a (yesterday)"}, rows[0], "?compare= sets it per request")
+	assert.Equal(t, "1", rows[1][1])
+	assert.Equal(t, "2", rows[1][2])
+
+	w = promtest.Get(t, h.Mux(), "/graphs/cpu?format=prometheus&last=1h&compare=1d")
+	var prom struct {
+		Data struct {
+			Result model.Matrix `json:"result"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &prom))
+	require.Len(t, prom.Data.Result, 1, "a query_range response has no compare series")
+	assert.NotContains(t, prom.Data.Result[0].Metric, compareLabel)
+}
+
+func TestServeGraph_CompareQueryError(t *testing.T) {
+	t.Parallel()
+	prom := rangeAtStart(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		end, _ := strconv.ParseFloat(r.FormValue("end"), 64)
+		if time.Since(time.Unix(int64(end), 0)) > 24*time.Hour {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		prom.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	h := newHandlerForTest(t, baseConfig(), srv.URL)
+
+	w := promtest.Get(t, h.Mux(), "/graphs/cpu?format=json&last=1h&compare=7d")
+
+	require.Equal(t, http.StatusOK, w.Code, "a failed compare query doesn't fail the graph")
+	var resp HistoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Series, 1)
+}
+
 func TestIndexRoute(t *testing.T) {
 	t.Parallel()
 	cfg := baseConfig() // endpoints are shown in the gallery by default
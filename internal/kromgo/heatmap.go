@@ -0,0 +1,415 @@
+package kromgo
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/prometheus/common/model"
+)
+
+// A heatmap graph draws a histogram's bucket counters as a time × bucket grid: each
+// cell is how many observations fell in the bucket during the step, colored on a ramp
+// of the theme's first series color.
+
+// heatmapMinCell is the narrowest and shortest a heatmap cell is drawn, in pixels;
+// more steps or buckets than fit are merged into their neighbours for the chart.
+const heatmapMinCell = 4
+
+// HeatmapBin is one row of a heatmap: a histogram bucket's bounds. A classic bucket
+// spans from the previous le (0 below the first, or -Inf when it's negative) to its
+// own; +Inf is encoded as in Prometheus' API, as the string "+Inf".
+type HeatmapBin struct {
+	Lower model.FloatString `json:"lower"`
+	Upper model.FloatString `json:"upper"`
+}
+
+// HistoryHeatmap is a heatmap graph's JSON: its bins, lowest first, and for each step
+// with data, the increase in each bin's count. Counts[b][t] is bin b at Times[t].
+type HistoryHeatmap struct {
+	Bins   []HeatmapBin `json:"bins"`
+	Times  []int64      `json:"times"`
+	Counts [][]float64  `json:"counts"`
+}
+
+// heatmap is a histogram's per-step bucket increases on the query grid: counts[b][t]
+// is the increase of bins[b] during the step ending at times[t]. present[t] is false
+// for a step without two samples to subtract (the window's first, or after a gap),
+// whose counts are 0.
+type heatmap struct {
+	bins    []HeatmapBin
+	times   []model.Time
+	counts  [][]float64
+	present []bool
+}
+
+// heatmap computes the matrix's bucket increases per step. Classic buckets are the
+// series with an le label: each series' increases are summed per le, as
+// sum by (le) (increase(...)) would, so one instance's restart doesn't read as a reset
+// of all. Native histograms' buckets are summed by bounds. A counter reset (a drop)
+// counts from zero, as PromQL's increase() does. Series that are neither are ignored.
+func (p chartParams) heatmap(matrix model.Matrix) heatmap {
+	grid := p.timeGrid(matrix)
+	position := gridPosition(grid, p.step)
+	missing := func() []float64 {
+		row := make([]float64, len(grid))
+		for j := range row {
+			row[j] = math.NaN()
+		}
+		return row
+	}
+	add := func(row []float64, j int, v float64) {
+		if math.IsNaN(row[j]) {
+			row[j] = 0
+		}
+		row[j] += v
+	}
+
+	classic := map[float64][]float64{}   // le → increase per step, summed over its series
+	native := map[HeatmapBin][]float64{} // bounds → count per step
+	sampled := make([]bool, len(grid))   // steps with a native histogram sample
+	for _, stream := range matrix {
+		if le, ok := stream.Metric[model.BucketLabel]; ok {
+			upper, err := strconv.ParseFloat(string(le), 64)
+			if err != nil || math.IsNaN(upper) {
+				continue
+			}
+			cumulative := missing()
+			for _, pt := range stream.Values {
+				v := float64(pt.Value)
+				if j, ok := position(pt.Timestamp); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+					cumulative[j] = v
+				}
+			}
+			row, ok := classic[upper]
+			if !ok {
+				row = missing()
+				classic[upper] = row
+			}
+			for j, v := range increases(cumulative) {
+				row[j] = sumPresent(row[j], v)
+			}
+		}
+		for _, pt := range stream.Histograms {
+			j, ok := position(pt.Timestamp)
+			if !ok || pt.Histogram == nil {
+				continue
+			}
+			sampled[j] = true
+			for _, b := range pt.Histogram.Buckets {
+				bin := HeatmapBin{Lower: b.Lower, Upper: b.Upper}
+				row, ok := native[bin]
+				if !ok {
+					row = missing()
+					native[bin] = row
+				}
+				add(row, j, float64(b.Count))
+			}
+		}
+	}
+
+	rows := map[HeatmapBin][]float64{}
+	for bin, row := range native {
+		// A bucket a sample doesn't list is empty in it.
+		for j, ok := range sampled {
+			if ok && math.IsNaN(row[j]) {
+				row[j] = 0
+			}
+		}
+		rows[bin] = increases(row)
+	}
+	// A classic bucket counts everything up to its le; subtract the one below it.
+	les := slices.Sorted(maps.Keys(classic))
+	var below []float64
+	for i, le := range les {
+		row := classic[le]
+		lower := 0.0
+		switch {
+		case i > 0:
+			lower = les[i-1]
+		case le <= 0:
+			lower = math.Inf(-1)
+		}
+		counts := slices.Clone(row)
+		if below != nil {
+			for j := range counts {
+				counts[j] = max(row[j]-below[j], 0) // NaN stays NaN
+			}
+		}
+		below = row
+		bin := HeatmapBin{Lower: model.FloatString(lower), Upper: model.FloatString(le)}
+		if prev, ok := rows[bin]; ok {
+			for j := range counts {
+				counts[j] = sumPresent(prev[j], counts[j])
+			}
+		}
+		rows[bin] = counts
+	}
+
+	hm := heatmap{times: grid, present: make([]bool, len(grid))}
+	hm.bins = slices.SortedFunc(maps.Keys(rows), func(a, b HeatmapBin) int {
+		return cmp.Or(cmp.Compare(a.Upper, b.Upper), cmp.Compare(a.Lower, b.Lower))
+	})
+	hm.counts = make([][]float64, len(hm.bins))
+	for b, bin := range hm.bins {
+		row := rows[bin]
+		for j, v := range row {
+			if math.IsNaN(v) {
+				row[j] = 0
+			} else {
+				hm.present[j] = true
+			}
+		}
+		hm.counts[b] = row
+	}
+	return hm
+}
+
+// increases turns a row of cumulative counts into each step's increase over the
+// step before; a step without both samples is NaN, and a drop (a counter reset)
+// counts from zero.
+func increases(row []float64) []float64 {
+	out := make([]float64, len(row))
+	for j, v := range row {
+		switch {
+		case j == 0 || math.IsNaN(v) || math.IsNaN(row[j-1]):
+			out[j] = math.NaN()
+		case v < row[j-1]:
+			out[j] = v
+		default:
+			out[j] = v - row[j-1]
+		}
+	}
+	return out
+}
+
+// sumPresent adds two counts, treating NaN (no data) as absent rather than
+// poisoning the sum.
+func sumPresent(a, b float64) float64 {
+	switch {
+	case math.IsNaN(a):
+		return b
+	case math.IsNaN(b):
+		return a
+	default:
+		return a + b
+	}
+}
+
+// response is the heatmap as JSON: only the steps with data.
+func (hm heatmap) response() *HistoryHeatmap {
+	out := &HistoryHeatmap{
+		Bins:   hm.bins,
+		Times:  []int64{},
+		Counts: make([][]float64, len(hm.bins)),
+	}
+	for b := range out.Counts {
+		out.Counts[b] = []float64{}
+	}
+	for j, ts := range hm.times {
+		if !hm.present[j] {
+			continue
+		}
+		out.Times = append(out.Times, int64(ts)/1000)
+		for b, row := range hm.counts {
+			out.Counts[b] = append(out.Counts[b], row[j])
+		}
+	}
+	return out
+}
+
+// renderHeatmap draws the heatmap in the params' theme, one row per bin (labelled by
+// its upper bound through the graph's valueExpr) with the lowest at the bottom. Steps
+// or bins too many for the chart's size are merged: a merged cell is its bins' total
+// per step. A heatmap without data renders as a "No data" chart.
+func renderHeatmap(hm heatmap, p chartParams) ([]byte, error) {
+	if len(hm.bins) == 0 || !slices.Contains(hm.present, true) {
+		return renderNoData(p)
+	}
+	// The first step never has a previous sample to subtract.
+	times, counts := hm.times[1:], make([][]float64, len(hm.counts))
+	for b, row := range hm.counts {
+		counts[b] = row[1:]
+	}
+	opt := charts.NewHeatMapOptionWithData(nil)
+	opt.Theme = chartTheme(p.theme, p.themes)
+	opt.Title = p.chartTitle()
+	opt.ScaleMinValue = charts.Ptr(0.0)
+
+	// Size the grid to the plot: the plot's height bounds the rows, and the y labels
+	// those rows get set the plot's width.
+	timeLabels := p.timeAxisLabels(times)
+	opt.XAxis = p.heatmapTimeAxis(timeLabels)
+	opt.YAxis = charts.HeatMapAxis{Labels: []string{""}}
+	_, height, err := heatmapGrid(opt, p)
+	if err != nil {
+		return nil, err
+	}
+	rowGroups := evenBuckets(len(hm.bins), max(height/heatmapMinCell, 1))
+	yLabels := make([]string, len(rowGroups))
+	for r, rows := range rowGroups {
+		yLabels[r] = p.binLabel(float64(hm.bins[rows.end-1].Upper))
+	}
+	opt.YAxis = charts.HeatMapAxis{Labels: yLabels, LabelCount: min(max(height/30, 2), len(yLabels))}
+	width, _, err := heatmapGrid(opt, p)
+	if err != nil {
+		return nil, err
+	}
+	colGroups := evenBuckets(len(times), heatmapColumns(width, len(times)))
+	opt.Values = make([][]float64, len(rowGroups))
+	for r, rows := range rowGroups {
+		row := make([]float64, len(colGroups))
+		for c, cols := range colGroups {
+			for b := rows.start; b < rows.end; b++ {
+				for j := cols.start; j < cols.end; j++ {
+					row[c] += counts[b][j]
+				}
+			}
+			row[c] /= float64(cols.end - cols.start) // per step, however many merged
+		}
+		// The library draws the first row at the top; put the highest bin there.
+		opt.Values[len(rowGroups)-1-r] = row
+	}
+	xLabels := make([]string, len(colGroups))
+	for c, cols := range colGroups {
+		xLabels[c] = timeLabels[cols.start]
+	}
+	opt.XAxis = p.heatmapTimeAxis(xLabels)
+
+	painter := charts.NewPainter(charts.PainterOptions{
+		OutputFormat: p.format,
+		Width:        p.width,
+		Height:       p.height,
+		Font:         p.font,
+	})
+	if err := painter.HeatMapChart(opt); err != nil {
+		return nil, err
+	}
+	return p.encode(painter)
+}
+
+// heatmapTimeAxis is the heatmap's x-axis of time labels, sampled like timeAxis'.
+func (p chartParams) heatmapTimeAxis(labels []string) charts.HeatMapAxis {
+	return charts.HeatMapAxis{Labels: labels, LabelCount: min(p.maxTimeLabels(), len(labels))}
+}
+
+// heatmapRectRe matches a filled rectangle in SVG, capturing its corners' x and y and
+// its fill.
+var heatmapRectRe = regexp.MustCompile(`<path d="M (-?\d+) (-?\d+)\nL -?\d+ -?\d+\nL (-?\d+) (-?\d+)\nL -?\d+ -?\d+\nL -?\d+ -?\d+" style="stroke:none;fill:([^"]*)"/>`)
+
+// heatmapGrid measures the heatmap's cell grid for a layout — title, y labels, and x
+// labels — with a single column: as wide as the plot, and with one row as tall. It
+// renders the layout with every cell in probeColor and finds them in the image,
+// in the output format because the PNG and SVG backends lay text out a few pixels
+// apart. A probe it finds no cell in measures as the whole image, so the cells come
+// out somewhat small rather than the request failing.
+func heatmapGrid(opt charts.HeatMapOption, p chartParams) (width, height int, err error) {
+	opt.Values = make([][]float64, len(opt.YAxis.Labels))
+	for r := range opt.Values {
+		opt.Values[r] = []float64{1}
+	}
+	// A cell at the scale's maximum is drawn in the base color, unadjusted.
+	opt.Theme = chartTheme(p.theme, p.themes).WithSeriesColors([]charts.Color{probeColor})
+	opt.BaseColorIndex = 0
+	opt.ScaleMinValue, opt.ScaleMaxValue = charts.Ptr(0.0), charts.Ptr(1.0)
+	out, err := renderProbe(p, p.format, func(probe *charts.Painter) error { return probe.HeatMapChart(opt) })
+	if err != nil {
+		return 0, 0, err
+	}
+	box, err := probedCells(out, p.format, probeColor.WithAdjustHSL(0, 0, 0))
+	if errors.Is(err, errNoPlotArea) {
+		return p.width, p.height, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return box.Dx(), box.Dy(), nil
+}
+
+// probedCells finds the box of a heatmapGrid probe's cells, drawn in cell. In a PNG
+// it reads the image's middle column for the cells' top and bottom, and the top
+// cell's row for their left and right, rather than every pixel: the probe's one
+// column spans the plot's middle. It returns errNoPlotArea when the image has no such
+// cell.
+func probedCells(out []byte, format string, cell charts.Color) (image.Rectangle, error) {
+	var box image.Rectangle
+	if format == formatPNG {
+		img, err := png.Decode(bytes.NewReader(out))
+		if err != nil {
+			return box, err
+		}
+		isCell := func(x, y int) bool {
+			r, g, b, _ := img.At(x, y).RGBA()
+			return uint8(r>>8) == cell.R && uint8(g>>8) == cell.G && uint8(b>>8) == cell.B
+		}
+		bounds := img.Bounds()
+		mid := (bounds.Min.X + bounds.Max.X) / 2
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			if isCell(mid, y) {
+				box = box.Union(image.Rect(mid, y, mid+1, y+1))
+			}
+		}
+		if !box.Empty() {
+			y := box.Min.Y // a cell's row, where a row between cells might not be
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if isCell(x, y) {
+					box = box.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+	} else {
+		fill := fmt.Sprintf("rgb(%d,%d,%d)", cell.R, cell.G, cell.B)
+		for _, m := range heatmapRectRe.FindAllSubmatch(out, -1) {
+			if string(m[5]) != fill {
+				continue
+			}
+			var corner [4]int
+			for i := range corner {
+				corner[i], _ = strconv.Atoi(string(m[i+1]))
+			}
+			box = box.Union(image.Rect(corner[0], corner[1], corner[2], corner[3]))
+		}
+	}
+	if box.Empty() {
+		return box, errNoPlotArea
+	}
+	return box, nil
+}
+
+// heatmapColumns picks how many columns n steps are drawn in across a plot width
+// pixels wide: as many as fit at heatmapMinCell pixels each, up to n. The library
+// floors the cell width, leaving width mod columns pixels blank at the right, so it
+// trades up to a fifth of the columns for the count leaving the fewest blank.
+func heatmapColumns(width, n int) int {
+	most := max(min(n, width/heatmapMinCell), 1)
+	best := most
+	for c := most - 1; c >= max(most*4/5, 1); c-- {
+		if width%c < width%best {
+			best = c
+		}
+	}
+	return best
+}
+
+// binLabel is a bin's y-axis label: its upper bound through the graph's valueExpr,
+// or the plain number.
+func (p chartParams) binLabel(upper float64) string {
+	label := strconv.FormatFloat(upper, 'g', 4, 64)
+	if p.valueFormatter != nil && !math.IsInf(upper, 0) {
+		label = p.valueFormatter(upper)
+	}
+	if p.format != formatPNG {
+		label = html.EscapeString(label) // the library writes SVG text unescaped
+	}
+	return label
+}
@@ -0,0 +1,187 @@
+package kromgo
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"testing"
+	"time"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bucketSeries is a classic histogram's _bucket counters, one series per le, sampled
+// every minute.
+func bucketSeries(buckets map[string][]float64) model.Matrix {
+	var matrix model.Matrix
+	for le, values := range buckets {
+		stream := &model.SampleStream{Metric: model.Metric{"le": model.LabelValue(le)}}
+		for j, v := range values {
+			stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.Time(j * 60_000), Value: model.SampleValue(v)})
+		}
+		matrix = append(matrix, stream)
+	}
+	return matrix
+}
+
+func TestHeatmap_Classic(t *testing.T) {
+	t.Parallel()
+	matrix := bucketSeries(map[string][]float64{
+		"+Inf": {10, 20, 35, 5},
+		"0.5":  {8, 14, 24, 4},
+		"0.1":  {5, 7, 12, 1}, // the counters reset before the last step
+	})
+	hm := chartParams{}.heatmap(matrix)
+
+	assert.Equal(t, []HeatmapBin{
+		{Lower: 0, Upper: 0.1},
+		{Lower: 0.1, Upper: 0.5},
+		{Lower: 0.5, Upper: model.FloatString(math.Inf(1))},
+	}, hm.bins)
+	assert.Equal(t, []bool{false, true, true, true}, hm.present, "the first step has nothing to subtract")
+	assert.Equal(t, [][]float64{
+		{0, 2, 5, 1},
+		{0, 4, 5, 3},
+		{0, 4, 5, 1},
+	}, hm.counts)
+}
+
+func TestHeatmap_SumsAcrossSeries(t *testing.T) {
+	t.Parallel()
+	matrix := append(bucketSeries(map[string][]float64{"1": {0, 2}}), bucketSeries(map[string][]float64{"1": {0, 3}})...)
+	matrix[1].Metric["instance"] = "b"
+	hm := chartParams{}.heatmap(matrix)
+	require.Len(t, hm.bins, 1)
+	assert.Equal(t, []float64{0, 5}, hm.counts[0])
+}
+
+func TestHeatmap_ResetOnOneInstance(t *testing.T) {
+	t.Parallel()
+	// Instance b restarts before the last step while a keeps counting: a's 10 more
+	// and b's 2 since its restart, not a drop of the summed counters.
+	matrix := append(bucketSeries(map[string][]float64{"1": {100, 110, 120}}), bucketSeries(map[string][]float64{"1": {50, 60, 2}})...)
+	matrix[1].Metric["instance"] = "b"
+	hm := chartParams{}.heatmap(matrix)
+	require.Len(t, hm.bins, 1)
+	assert.Equal(t, []float64{0, 20, 12}, hm.counts[0])
+}
+
+func TestHeatmap_Native(t *testing.T) {
+	t.Parallel()
+	hist := func(counts ...float64) *model.SampleHistogram {
+		h := &model.SampleHistogram{}
+		for i, c := range counts {
+			if c >= 0 {
+				h.Buckets = append(h.Buckets, &model.HistogramBucket{
+					Lower: model.FloatString(i), Upper: model.FloatString(i + 1), Count: model.FloatString(c),
+				})
+			}
+		}
+		return h
+	}
+	matrix := model.Matrix{{
+		Metric: model.Metric{"job": "api"},
+		Histograms: []model.SampleHistogramPair{
+			{Timestamp: 0, Histogram: hist(1, -1)}, // the second bucket appears later
+			{Timestamp: 60_000, Histogram: hist(4, 2)},
+			{Timestamp: 120_000, Histogram: hist(4, 7)},
+		},
+	}}
+	hm := chartParams{}.heatmap(matrix)
+	assert.Equal(t, []HeatmapBin{{Lower: 0, Upper: 1}, {Lower: 1, Upper: 2}}, hm.bins)
+	assert.Equal(t, [][]float64{{0, 3, 0}, {0, 2, 5}}, hm.counts, "a bucket a sample doesn't list is empty")
+}
+
+func TestHeatmap_Response(t *testing.T) {
+	t.Parallel()
+	hm := chartParams{step: time.Minute, start: time.UnixMilli(0), end: time.UnixMilli(240_000)}.heatmap(
+		bucketSeries(map[string][]float64{"+Inf": {1, 2, 4}}))
+	resp := hm.response()
+	assert.Equal(t, []int64{60, 120}, resp.Times, "steps with data only")
+	assert.Equal(t, [][]float64{{1, 2}}, resp.Counts)
+}
+
+func TestIncreases(t *testing.T) {
+	t.Parallel()
+	got := increases([]float64{1, 3, math.NaN(), 5, 6, 2})
+	assert.True(t, math.IsNaN(got[0]))
+	assert.InDelta(t, 2, got[1], 0)
+	assert.True(t, math.IsNaN(got[2]))
+	assert.True(t, math.IsNaN(got[3]), "no previous sample across the gap")
+	assert.InDelta(t, 1, got[4], 0)
+	assert.InDelta(t, 2, got[5], 0, "a reset counts from zero")
+}
+
+func TestHeatmapColumns(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 10, heatmapColumns(521, 10), "fewer steps than fit")
+	assert.Equal(t, 100, heatmapColumns(500, 120), "trading columns for a plot with none blank")
+	assert.Equal(t, 130, heatmapColumns(521, 1000), "as many as fit, leaving a pixel blank")
+	assert.Equal(t, 1, heatmapColumns(3, 1000))
+}
+
+func TestRenderHeatmap(t *testing.T) {
+	t.Parallel()
+	values := func(rate float64) []float64 {
+		out := make([]float64, 200)
+		for i := range out {
+			out[i] = rate * float64(i)
+		}
+		return out
+	}
+	hm := chartParams{}.heatmap(bucketSeries(map[string][]float64{
+		"0.25": values(3), "1": values(5), "+Inf": values(6),
+	}))
+	p := chartParams{width: 600, height: 300, format: formatSVG, valueFormatter: func(v float64) string {
+		return fmt.Sprintf("<%gms", v*1000)
+	}}
+	svg, err := renderHeatmap(hm, p)
+	require.NoError(t, err)
+	body := string(svg)
+	assert.Contains(t, body, `<svg width="600" height="300"`)
+	assert.Contains(t, body, "&lt;250ms", "bins are labelled by their upper bound, escaped")
+	assert.Contains(t, body, "+Inf")
+
+	p.format = formatPNG
+	img, err := renderHeatmap(hm, p)
+	require.NoError(t, err)
+	assert.Equal(t, "\x89PNG", string(img[:4]))
+
+	empty, err := renderHeatmap(chartParams{}.heatmap(nil), chartParams{width: 300, height: 150, format: formatSVG})
+	require.NoError(t, err)
+	assert.Contains(t, string(empty), noDataText)
+}
+
+func TestProbedCells(t *testing.T) {
+	t.Parallel()
+	cell := charts.Color{R: 1, G: 2, B: 3, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for _, rows := range []image.Rectangle{image.Rect(40, 10, 180, 50), image.Rect(40, 51, 180, 90)} {
+		draw.Draw(img, rows, image.NewUniform(color.RGBA{R: 1, G: 2, B: 3, A: 255}), image.Point{}, draw.Src)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	box, err := probedCells(buf.Bytes(), formatPNG, cell)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(40, 10, 180, 90), box, "spans rows a pixel apart")
+
+	svg := []byte("<path d=\"M 40 10\nL 180 10\nL 180 50\nL 40 50\nL 40 10\" style=\"stroke:none;fill:rgb(1,2,3)\"/>")
+	box, err = probedCells(svg, formatSVG, cell)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(40, 10, 180, 50), box)
+
+	_, err = probedCells([]byte("<svg></svg>"), formatSVG, cell)
+	require.ErrorIs(t, err, errNoPlotArea, "a library upgrade drawing cells unrecognizably")
+	width, height, err := heatmapGrid(charts.HeatMapOption{}, chartParams{width: 300, height: 150, format: formatSVG})
+	require.NoError(t, err)
+	assert.Positive(t, width)
+	assert.Positive(t, height)
+}
@@ -0,0 +1,157 @@
+package kromgo
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/prometheus/common/model"
+)
+
+// errNotHistogram is returned by histogramQuantile when its argument isn't shaped
+// like the `histogram` variable.
+var errNotHistogram = errors.New("histogramQuantile: argument is not a histogram")
+
+// defaultGraphQuantiles are the series a graph derives from a native-histogram query
+// when it doesn't set quantiles.
+var defaultGraphQuantiles = []float64{0.5, 0.9, 0.99}
+
+// histogramQuantile estimates the q-quantile (0 ≤ q ≤ 1) of a native histogram by
+// linear interpolation within the bucket holding the target rank. That approximates
+// PromQL's histogram_quantile, which interpolates exponentially within a standard
+// (exponential-schema) bucket and so can land lower in a wide one; the API's buckets
+// don't carry the schema to tell them from custom ones. q < 0 is -Inf and q > 1 is
+// +Inf (as in PromQL); an empty histogram, or a NaN q, is NaN.
+func histogramQuantile(h *model.SampleHistogram, q float64) float64 {
+	switch {
+	case math.IsNaN(q):
+		return math.NaN()
+	case q < 0:
+		return math.Inf(-1)
+	case q > 1:
+		return math.Inf(1)
+	}
+	if h == nil {
+		return math.NaN()
+	}
+	buckets := slices.SortedFunc(slices.Values(h.Buckets), func(a, b *model.HistogramBucket) int {
+		return cmp.Compare(a.Upper, b.Upper)
+	})
+	var total float64
+	for _, b := range buckets {
+		total += float64(b.Count)
+	}
+	if total <= 0 {
+		return math.NaN()
+	}
+
+	rank := q * total
+	var seen float64
+	for _, b := range buckets {
+		count := float64(b.Count)
+		if count <= 0 {
+			continue
+		}
+		if seen+count >= rank {
+			lower, upper := float64(b.Lower), float64(b.Upper)
+			return lower + (upper-lower)*(rank-seen)/count
+		}
+		seen += count
+	}
+	return float64(buckets[len(buckets)-1].Upper)
+}
+
+// histogramVar converts a native histogram to the CEL `histogram` variable: its
+// count, sum, and buckets (each a {lower, upper, count} map). A float sample binds
+// as an empty map, so histogram.count is an error and has(histogram.count) is false.
+func histogramVar(h *model.SampleHistogram) map[string]any {
+	if h == nil {
+		return map[string]any{}
+	}
+	buckets := make([]map[string]float64, 0, len(h.Buckets))
+	for _, b := range h.Buckets {
+		buckets = append(buckets, map[string]float64{
+			"lower": float64(b.Lower),
+			"upper": float64(b.Upper),
+			"count": float64(b.Count),
+		})
+	}
+	return map[string]any{
+		"count":   float64(h.Count),
+		"sum":     float64(h.Sum),
+		"buckets": buckets,
+	}
+}
+
+// histogramFromCEL reads a `histogram` map (see histogramVar) back into a native
+// histogram for histogramQuantile. Only the buckets matter; an empty map yields an
+// empty histogram.
+func histogramFromCEL(v ref.Val) (*model.SampleHistogram, error) {
+	m, ok := v.(traits.Mapper)
+	if !ok {
+		return nil, errNotHistogram
+	}
+	raw, found := m.Find(types.String("buckets"))
+	if !found {
+		return &model.SampleHistogram{}, nil
+	}
+	list, ok := raw.(traits.Lister)
+	if !ok {
+		return nil, errNotHistogram
+	}
+	h := &model.SampleHistogram{}
+	for it := list.Iterator(); it.HasNext() == types.True; {
+		bucket, ok := it.Next().(traits.Mapper)
+		if !ok {
+			return nil, errNotHistogram
+		}
+		var bounds [3]float64
+		for i, key := range []string{"lower", "upper", "count"} {
+			f, ok := bucket.Get(types.String(key)).ConvertToType(types.DoubleType).(types.Double)
+			if !ok {
+				return nil, errNotHistogram
+			}
+			bounds[i] = float64(f)
+		}
+		h.Buckets = append(h.Buckets, &model.HistogramBucket{
+			Lower: model.FloatString(bounds[0]),
+			Upper: model.FloatString(bounds[1]),
+			Count: model.FloatString(bounds[2]),
+		})
+	}
+	return h, nil
+}
+
+// expandHistograms replaces each native-histogram series in a range matrix with one
+// float series per quantile, labelled quantile="<q>", so the chart and JSON paths
+// (which only read float samples) can plot them. Float series pass through.
+func expandHistograms(matrix model.Matrix, quantiles []float64) model.Matrix {
+	out := make(model.Matrix, 0, len(matrix))
+	for _, stream := range matrix {
+		if len(stream.Histograms) == 0 {
+			out = append(out, stream)
+			continue
+		}
+		if len(stream.Values) > 0 {
+			out = append(out, &model.SampleStream{Metric: stream.Metric, Values: stream.Values})
+		}
+		for _, q := range quantiles {
+			metric := stream.Metric.Clone()
+			metric[model.QuantileLabel] = model.LabelValue(strconv.FormatFloat(q, 'f', -1, 64))
+			values := make([]model.SamplePair, 0, len(stream.Histograms))
+			for _, p := range stream.Histograms {
+				values = append(values, model.SamplePair{
+					Timestamp: p.Timestamp,
+					Value:     model.SampleValue(histogramQuantile(p.Histogram, q)),
+				})
+			}
+			out = append(out, &model.SampleStream{Metric: metric, Values: values})
+		}
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package kromgo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHistogram has 4 observations in (0,1] and 6 in (1,2], with buckets out of order.
+func testHistogram() *model.SampleHistogram {
+	return &model.SampleHistogram{Count: 10, Sum: 12, Buckets: model.HistogramBuckets{
+		{Lower: 1, Upper: 2, Count: 6},
+		{Lower: 0, Upper: 1, Count: 4},
+	}}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		h    *model.SampleHistogram
+		q    float64
+		want float64
+	}{
+		{"min", testHistogram(), 0, 0},
+		{"within first bucket", testHistogram(), 0.2, 0.5},
+		{"bucket boundary", testHistogram(), 0.4, 1},
+		{"within second bucket", testHistogram(), 0.7, 1.5},
+		{"max", testHistogram(), 1, 2},
+		{"below range", testHistogram(), -0.1, math.Inf(-1)},
+		{"above range", testHistogram(), 1.1, math.Inf(1)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.InDelta(t, tc.want, histogramQuantile(tc.h, tc.q), 1e-9)
+		})
+	}
+}
+
+func TestHistogramQuantile_NaN(t *testing.T) {
+	t.Parallel()
+	assert.True(t, math.IsNaN(histogramQuantile(nil, 0.5)), "no histogram")
+	assert.True(t, math.IsNaN(histogramQuantile(&model.SampleHistogram{}, 0.5)), "no observations")
+	assert.True(t, math.IsNaN(histogramQuantile(testHistogram(), math.NaN())), "NaN quantile")
+}
+
+func TestCEL_Histogram(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+	cases := []struct {
+		src  string
+		h    *model.SampleHistogram
+		want string
+	}{
+		{`string(histogramQuantile(0.7))`, testHistogram(), "1.5"},
+		{`string(histogramQuantile(histogram, 0.2))`, testHistogram(), "0.5"},
+		{`string(histogram.sum / histogram.count)`, testHistogram(), "1.2"},
+		{`string(size(histogram.buckets))`, testHistogram(), "2"},
+		{`has(histogram.count) ? "histogram" : "float"`, testHistogram(), "histogram"},
+		{`has(histogram.count) ? "histogram" : "float"`, nil, "float"},
+		{`math.isNaN(histogramQuantile(0.5)) ? "n/a" : "value"`, nil, "n/a"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.src, func(t *testing.T) {
+			t.Parallel()
+			prog, err := compileStringExpr(env, "test", "value", tc.src)
+			require.NoError(t, err)
+			got, err := evalStringExpr(prog, exprVars{histogram: tc.h})
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestExpandHistograms(t *testing.T) {
+	t.Parallel()
+	float := &model.SampleStream{Metric: model.Metric{"job": "a"}, Values: samples(1, 2)}
+	matrix := model.Matrix{float, &model.SampleStream{
+		Metric:     model.Metric{"job": "b"},
+		Histograms: []model.SampleHistogramPair{{Timestamp: 1000, Histogram: testHistogram()}},
+	}}
+
+	got := expandHistograms(matrix, []float64{0.2, 0.7})
+
+	require.Len(t, got, 3)
+	assert.Same(t, float, got[0], "float series pass through")
+	assert.Equal(t, model.Metric{"job": "b", "quantile": "0.2"}, got[1].Metric)
+	assert.Equal(t, []model.SamplePair{{Timestamp: 1000, Value: 0.5}}, got[1].Values)
+	assert.Equal(t, model.Metric{"job": "b", "quantile": "0.7"}, got[2].Metric)
+	assert.Equal(t, []model.SamplePair{{Timestamp: 1000, Value: 1.5}}, got[2].Values)
+	assert.Equal(t, model.Metric{"job": "b"}, matrix[1].Metric, "source metric is not mutated")
+}
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/home-operations/kromgo/internal/logging"
@@ -14,8 +15,22 @@ import (
 	"github.com/prometheus/common/model"
 )
 
+// Stale badges (sample older than maxAge) replace the value with this message and color.
+const (
+	staleMessage = "stale"
+	staleColor   = "lightgrey"
+)
+
+// noDataMessage is shown when the query returns no samples (unless onNoData overrides
+// it), and hiddenReason replaces an internal failure reason under onError.hideReason.
+const (
+	noDataMessage = "no data"
+	hiddenReason  = "unavailable"
+)
+
 // BadgeJSON is kromgo's native JSON for a badge value (format=json): the rendered
-// string plus the underlying number and labels, without the Prometheus envelope.
+// string plus the underlying number, labels, and sample timestamp (Unix seconds),
+// without the Prometheus envelope.
 type BadgeJSON struct {
 	ID         string            `json:"id"`
 	Title      string            `json:"title"`
@@ -24,6 +39,8 @@ type BadgeJSON struct {
 	LabelColor string            `json:"labelColor,omitempty"`
 	Result     *float64          `json:"result,omitempty"`
 	Labels     map[string]string `json:"labels,omitempty"`
+	Timestamp  int64             `json:"timestamp,omitempty"`
+	Stale      bool              `json:"stale,omitempty"`
 }
 
 // serveBadge renders an instant value as an SVG badge (default), shields.io endpoint
@@ -51,75 +68,133 @@ func (h *Handler) serveBadge(w http.ResponseWriter, r *http.Request) {
 	metricLabel = id
 	h.cache.apply(w)
 
-	value, err := h.queryValue(r.Context(), badge)
-	if err != nil {
-		log.Error("error executing query", "error", err)
-		h.errorResponse(w, format, id, "Query Error", http.StatusInternalServerError)
-		return
+	now := time.Now()
+	vector, text, reason := h.badgeVector(r.Context(), badge, now, log)
+	var fallback *badgeFallback
+	switch {
+	case reason != "":
+		fallback = &badge.onError
+		if fallback.value == nil {
+			h.badgeErrorResponse(w, format, badge, reason)
+			return
+		}
+		// Serve the stand-in, but never cache it past the outage.
+		w.Header().Set("Cache-Control", "no-store")
+	case len(vector) == 0:
+		fallback = &badge.onNoData
 	}
-	vector, ok := value.(model.Vector)
-	if !ok {
-		log.Error("query did not return an instant vector", "type", value.Type().String())
-		h.errorResponse(w, format, id, "Unexpected result type", http.StatusInternalServerError)
-		return
+	if fallback != nil && fallback.value != nil {
+		vector, text = model.Vector{{
+			Metric: model.Metric{}, Value: model.SampleValue(*fallback.value),
+			Timestamp: model.TimeFromUnixNano(now.UnixNano()),
+		}}, ""
 	}
 
-	title := displayTitle(badge.Title, badge.ID)
-	message, color := "no data", ""
+	label, labelColor := badge.Title, badge.labelColor
+	message, color, colorDark, status := noDataMessage, "", "", http.StatusOK
 	var result *float64
 	var labels map[string]string
+	var timestamp int64
+	stale := false
 	if len(vector) > 0 {
-		msg, col, ok := h.evalDisplay(badge, vector[0], log)
-		if !ok {
-			h.errorResponse(w, format, id, "Expression Error", http.StatusInternalServerError)
-			return
+		sample := vector[0]
+		ts, v := sample.Timestamp.Time(), sampleResult(sample)
+		vars := exprVars{
+			result: v, labels: labelMap(sample.Metric),
+			text: text, timestamp: ts, now: now, histogram: sample.Histogram,
+		}
+		label, labelColor = h.evalLabel(badge, vars, log)
+		if stale = badge.maxAge > 0 && badge.windowEnd(now).Sub(ts) > badge.maxAge; stale {
+			message, color = staleMessage, staleColor
+		} else {
+			msg, col, ok := h.evalDisplay(badge, vars, log)
+			if !ok {
+				h.badgeErrorResponse(w, format, badge, "Expression Error")
+				return
+			}
+			message, color = msg, col
+			colorDark = evalStringOr(badge.darkProg, vars, "", "colorDark", log)
 		}
-		v := float64(vector[0].Value)
-		message, color, labels = msg, col, labelMap(vector[0].Metric)
-		if !math.IsInf(v, 0) && !math.IsNaN(v) {
-			result = &v // omit a non-finite value: JSON can't encode NaN/Inf, and it isn't a real result
+		// A fallback value is a stand-in, not a sample: report no result for it.
+		if fallback == nil {
+			labels, timestamp = labelMap(sample.Metric), ts.Unix()
+			if !math.IsInf(v, 0) && !math.IsNaN(v) {
+				result = &v // omit a non-finite value: JSON can't encode NaN/Inf, and it isn't a real result
+			}
 		}
+	} else {
+		message = cmp.Or(badge.onNoData.message, noDataMessage)
+		color = badge.onNoData.color
+		status = cmp.Or(badge.onNoData.status, http.StatusOK)
 	}
+	title := displayTitle(label, badge.ID)
 
 	switch format {
 	case formatShields:
-		writeJSONOr(w, log, id, EndpointResponse{
+		writeJSONOr(w, log, id, status, EndpointResponse{
 			SchemaVersion: 1, Label: title, Message: message, Color: color,
-			LabelColor: badge.labelColor, CacheSeconds: h.cache.seconds,
+			LabelColor: labelColor, CacheSeconds: h.cache.seconds,
 		})
 	case formatJSON:
-		writeJSONOr(w, log, id, BadgeJSON{
+		writeJSONOr(w, log, id, status, BadgeJSON{
 			ID: badge.ID, Title: title, Value: message, Color: color,
-			LabelColor: badge.labelColor, Result: result, Labels: labels,
+			LabelColor: labelColor, Result: result, Labels: labels,
+			Timestamp: timestamp, Stale: stale,
 		})
 	default: // svg
 		// Label text: explicit Title, else the id — unless an icon stands in for it.
-		labelText := badge.Title
+		labelText := label
 		if labelText == "" && badge.iconPath == "" {
 			labelText = badge.ID
 		}
 		style := cmp.Or(r.URL.Query().Get("style"), badge.style)
 		writeSVG(w, h.gen.render(badgeSpec{
 			style: style, iconPath: badge.iconPath, label: labelText,
-			message: message, color: color, labelColor: badge.labelColor, id: badge.ID,
+			message: message, color: color, colorDark: colorDark, labelColor: labelColor,
+			labelColorDark: badge.labelDark, id: badge.ID,
 		}))
 	}
 }
 
-// evalDisplay evaluates the badge's value and color CEL expressions against a
-// sample. ok is false only if the value expression errors (caller returns 500);
-// a failing color expression is logged and treated as no color.
-func (h *Handler) evalDisplay(badge *resolvedBadge, sample *model.Sample, log *slog.Logger) (message, color string, ok bool) {
-	result := float64(sample.Value)
-	labels := labelMap(sample.Metric)
+// badgeVector runs the badge's query and normalizes the result to an instant vector
+// (see resultVector). On failure it logs the cause and returns a short public reason
+// for the error badge instead.
+func (h *Handler) badgeVector(ctx context.Context, badge *resolvedBadge, now time.Time, log *slog.Logger) (vector model.Vector, text, reason string) {
+	value, err := h.queryValue(ctx, badge, now)
+	if err != nil {
+		log.Error("error executing query", "error", err)
+		return nil, "", "Query Error"
+	}
+	vector, text, err = resultVector(value, badge.reduce)
+	if err != nil {
+		log.Error("unsupported query result", "error", err)
+		return nil, "", "Unexpected result type"
+	}
+	return vector, text, ""
+}
 
-	message, err := evalStringExpr(badge.valueProg, result, labels)
+// badgeErrorResponse renders a badge failure through its onError block: message,
+// color, and status override the reason's defaults, and hideReason masks the reason.
+func (h *Handler) badgeErrorResponse(w http.ResponseWriter, format string, badge *resolvedBadge, reason string) {
+	fb := badge.onError
+	if fb.hideReason {
+		reason = hiddenReason
+	}
+	h.coloredErrorResponse(w, format, badge.ID, cmp.Or(fb.message, reason), fb.color,
+		cmp.Or(fb.status, http.StatusInternalServerError))
+}
+
+// evalDisplay evaluates the badge's value and color CEL expressions against a
+// sample's variables. ok is false only if the value expression errors (caller
+// returns 500); a failing color expression is logged and treated as no color.
+func (h *Handler) evalDisplay(badge *resolvedBadge, vars exprVars, log *slog.Logger) (message, color string, ok bool) {
+	message, err := evalStringExpr(badge.valueProg, vars)
 	if err != nil {
 		log.Error("value expression failed", "error", err)
 		return "", "", false
 	}
 	if badge.colorProg != nil {
-		if color, err = evalStringExpr(badge.colorProg, result, labels); err != nil {
+		if color, err = evalStringExpr(badge.colorProg, vars); err != nil {
 			log.Error("color expression failed", "error", err) // degrade to no color
 			color = ""
 		}
@@ -127,15 +202,37 @@ func (h *Handler) evalDisplay(badge *resolvedBadge, sample *model.Sample, log *s
 	return message, color, true
 }
 
-// queryValue computes a badge's instant value: an instant query for the default
-// type, or a range query reduced to one value per series for type: range.
-func (h *Handler) queryValue(ctx context.Context, badge *resolvedBadge) (model.Value, error) {
+// evalLabel evaluates the badge's title and label color expressions against a
+// sample's variables, over its static title and label color: a failing expression is
+// logged and keeps the static value.
+func (h *Handler) evalLabel(badge *resolvedBadge, vars exprVars, log *slog.Logger) (title, labelColor string) {
+	title = evalStringOr(badge.titleProg, vars, badge.Title, "title", log)
+	labelColor = badge.labelColor
+	if c := evalStringOr(badge.labelProg, vars, "", "labelColor", log); c != "" {
+		labelColor = colorNameToHex(c)
+	}
+	return title, labelColor
+}
+
+// windowEnd is the end of the badge's query window: now, shifted back by a range
+// badge's offset. Staleness is measured from it, so an offset window's newest sample
+// isn't stale for the offset alone.
+func (b *resolvedBadge) windowEnd(now time.Time) time.Time {
+	if b.rangeQuery == nil {
+		return now
+	}
+	return now.Add(-b.rangeQuery.offset)
+}
+
+// queryValue computes a badge's instant value at now: an instant query for the
+// default type, or a range query reduced to one value per series for type: range.
+func (h *Handler) queryValue(ctx context.Context, badge *resolvedBadge, now time.Time) (model.Value, error) {
 	rq := badge.rangeQuery
 	if rq == nil {
-		return h.prom.Query(ctx, badge.Query, time.Now())
+		return h.prom.Query(ctx, badge.Query, now)
 	}
 
-	end := time.Now().Add(-rq.offset)
+	end := badge.windowEnd(now)
 	value, err := h.prom.QueryRange(ctx, badge.Query, v1.Range{Start: end.Add(-rq.last), End: end, Step: rq.step})
 	if err != nil {
 		return nil, err
@@ -146,3 +243,36 @@ func (h *Handler) queryValue(ctx context.Context, badge *resolvedBadge) (model.V
 	}
 	return reduceMatrix(matrix, rq.reduce), nil
 }
+
+// sampleResult is the `result` bound for a sample: its value, or for a native
+// histogram (which carries no float value) its observation count.
+func sampleResult(sample *model.Sample) float64 {
+	if sample.Histogram != nil {
+		return float64(sample.Histogram.Count)
+	}
+	return float64(sample.Value)
+}
+
+// resultVector normalizes an instant query result of any type to an instant vector
+// for the value pipeline. A scalar becomes one unlabelled sample; a string becomes
+// one unlabelled sample whose value is the string parsed as a number (NaN when it
+// isn't one), with the string itself returned as text; and a matrix — from a range
+// selector or subquery — is reduced to one sample per series with reduce.
+func resultVector(value model.Value, reduce string) (model.Vector, string, error) {
+	switch v := value.(type) {
+	case model.Vector:
+		return v, "", nil
+	case *model.Scalar:
+		return model.Vector{{Metric: model.Metric{}, Value: v.Value, Timestamp: v.Timestamp}}, "", nil
+	case *model.String:
+		f, err := strconv.ParseFloat(v.Value, 64)
+		if err != nil {
+			f = math.NaN()
+		}
+		return model.Vector{{Metric: model.Metric{}, Value: model.SampleValue(f), Timestamp: v.Timestamp}}, v.Value, nil
+	case model.Matrix:
+		return reduceMatrix(v, reduce), "", nil
+	default:
+		return nil, "", fmt.Errorf("unexpected result type %s", value.Type())
+	}
+}
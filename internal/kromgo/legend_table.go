@@ -0,0 +1,184 @@
+package kromgo
+
+import (
+	"cmp"
+	"html"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// A graph with legend: table draws its legend as a table beneath the chart, in place
+// of the library's: a row per series with its color swatch, its name, and the
+// legendColumns' values over the window — the JSON stats, formatted like the series'
+// y-axis. The chart keeps its height; the table adds its own to the image.
+
+// defaultLegendColumns are the legend table's columns when the graph names none.
+var defaultLegendColumns = []string{config.ColumnLast, config.ColumnMin, config.ColumnMax, config.ColumnAvg}
+
+// legendHeaders are the column headings.
+var legendHeaders = map[string]string{
+	config.ColumnLast: "Last", config.ColumnMin: "Min", config.ColumnMax: "Max", config.ColumnAvg: "Avg",
+}
+
+const (
+	legendRowHeight = 20
+	legendMargin    = 20 // left and right, as the chart's title and axes
+	legendFontSize  = 12
+	legendSwatch    = 10 // the color swatch's side
+	legendColumnGap = 16
+	legendNoValue   = "-" // a cell for a series with no finite samples
+)
+
+// legendRow is a legend table row: the series' name, swatch color, and a (formatted)
+// value per column.
+type legendRow struct {
+	name  string
+	color charts.Color
+	cells []string
+}
+
+// legendRows builds the table's rows in the chart's series order and colors: the
+// matrix's for the time-series types, the slices' (sorted by name) for the
+// categorical ones. It returns nil when the graph draws no table.
+func (p chartParams) legendRows(matrix model.Matrix) []legendRow {
+	if !p.legendTable {
+		return nil
+	}
+	metrics := streamMetrics(matrix)
+	if !p.plotsTime() {
+		_, _, metrics = p.categories(matrix)
+	}
+	streams := make(map[model.Fingerprint]*model.SampleStream, len(matrix))
+	for _, stream := range matrix {
+		streams[stream.Metric.Fingerprint()] = stream
+	}
+	palette := p.seriesPalette(metrics)
+	if p.chart == config.ChartHorizontalBar {
+		palette = chartTheme(p.theme, p.themes) // one bar series, in the theme's first color
+	}
+	rows := make([]legendRow, len(metrics))
+	for i, metric := range metrics {
+		data := finitePoints(streams[metric.Fingerprint()])
+		stats := seriesStats(data)
+		format := p.seriesFormatter(metric)
+		if format == nil {
+			format = defaultAxisFormatter
+		}
+		color := palette.GetSeriesColor(i)
+		if p.chart == config.ChartHorizontalBar {
+			color = palette.GetSeriesColor(0)
+		}
+		row := legendRow{name: cmp.Or(p.seriesLabel(metric), p.title), color: color}
+		for _, c := range p.legendColumns {
+			cell := legendNoValue
+			if stats != nil {
+				cell = format(legendValue(c, data, stats))
+			}
+			row.cells = append(row.cells, cell)
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// legendValue is a column's value for a series with finite samples.
+func legendValue(column string, data []HistoryDataPoint, stats *HistoryStats) float64 {
+	switch column {
+	case config.ColumnMin:
+		return stats.Min
+	case config.ColumnMax:
+		return stats.Max
+	case config.ColumnAvg:
+		return stats.Avg
+	default: // ColumnLast
+		return data[len(data)-1].V
+	}
+}
+
+// defaultAxisFormatter formats a value as the library's y-axis does without a valueExpr.
+func defaultAxisFormatter(v float64) string {
+	return charts.FormatValueHumanizeShort(v, 2, false)
+}
+
+// legendTableHeight is the height a table of n rows adds beneath the chart: a header
+// row, the rows, and half a row of bottom margin.
+func legendTableHeight(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return (n+1)*legendRowHeight + legendRowHeight/2
+}
+
+// drawLegendTable paints the table onto the image below the chart, which ends at top.
+// Values are right-aligned in their columns, packed from the right edge; a name too
+// long for the space left of them is cut short with an ellipsis.
+func drawLegendTable(painter *charts.Painter, p chartParams, rows []legendRow, top int) {
+	theme := chartTheme(p.theme, p.themes)
+	bg := theme.GetBackgroundColor()
+	painter.FilledRect(0, top, p.width, top+legendTableHeight(len(rows)), bg, bg, 0)
+	style := charts.FontStyle{Font: p.font, FontSize: legendFontSize, FontColor: theme.GetLegendTextColor()}
+	header := style
+	header.FontColor = theme.GetXAxisTextColor()
+	width := func(s string, style charts.FontStyle) int { return painter.MeasureText(s, 0, style).Width() }
+	textHeight := painter.MeasureText("0", 0, style).Height()
+	// baseline places text vertically centered in row i (the header is row 0).
+	baseline := func(i int) int { return top + i*legendRowHeight + (legendRowHeight+textHeight)/2 }
+
+	// Columns, right to left: each as wide as its heading or widest value.
+	rights := make([]int, len(p.legendColumns))
+	right := p.width - legendMargin
+	for k := len(p.legendColumns) - 1; k >= 0; k-- {
+		w := width(legendHeaders[p.legendColumns[k]], header)
+		for _, row := range rows {
+			w = max(w, width(p.svgText(row.cells[k]), style))
+		}
+		rights[k] = right
+		right -= w + legendColumnGap
+	}
+	for k, c := range p.legendColumns {
+		h := legendHeaders[c]
+		painter.Text(h, rights[k]-width(h, header), baseline(0), 0, header)
+	}
+	painter.LineStroke([]charts.Point{
+		{X: legendMargin, Y: top + legendRowHeight}, {X: p.width - legendMargin, Y: top + legendRowHeight},
+	}, theme.GetAxisSplitLineColor(), 1)
+
+	nameLeft := legendMargin + legendSwatch + legendSwatch/2
+	for i, row := range rows {
+		mid := top + (i+1)*legendRowHeight + legendRowHeight/2
+		painter.FilledRect(legendMargin, mid-legendSwatch/2, legendMargin+legendSwatch, mid+legendSwatch/2,
+			row.color, row.color, 0)
+		name := fitText(row.name, right+legendColumnGap/2-nameLeft, func(s string) int { return width(p.svgText(s), style) })
+		painter.Text(p.svgText(name), nameLeft, baseline(i+1), 0, style)
+		for k, cell := range row.cells {
+			cell = p.svgText(cell)
+			painter.Text(cell, rights[k]-width(cell, style), baseline(i+1), 0, style)
+		}
+	}
+}
+
+// fitText cuts s short with an ellipsis to fit within limit pixels as measured, or
+// returns "" when not even the ellipsis fits.
+func fitText(s string, limit int, measure func(string) int) string {
+	if measure(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	for n := len(runes) - 1; n >= 0; n-- {
+		if t := string(runes[:n]) + "…"; measure(t) <= limit {
+			return t
+		}
+	}
+	return ""
+}
+
+// svgText escapes text the chart library writes into an SVG unescaped; a PNG draws
+// it as is.
+func (p chartParams) svgText(s string) string {
+	if p.format == formatPNG {
+		return s
+	}
+	return html.EscapeString(s)
+}
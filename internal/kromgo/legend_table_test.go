@@ -0,0 +1,90 @@
+package kromgo
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegendRows(t *testing.T) {
+	t.Parallel()
+	matrix := makeMatrix([][]float64{{4, 1, 3}, {math.NaN()}, {10, 30, 20}})
+	matrix[2].Metric = model.Metric{queryLabel: "fan"}
+	percent := func(v float64) string { return model.SampleValue(v).String() + "%" }
+	rpm := func(v float64) string { return model.SampleValue(v).String() + " rpm" }
+	p := chartParams{
+		title: "temps", legendTable: true, legendColumns: []string{config.ColumnAvg, config.ColumnLast, config.ColumnMax},
+		valueFormatter: percent, rightValueFormatter: rpm, rightQueries: map[string]bool{"fan": true},
+	}
+
+	rows := p.legendRows(matrix)
+	require.Len(t, rows, 3)
+	palette := p.seriesPalette(streamMetrics(matrix))
+	assert.Equal(t, legendRow{name: "s0", color: palette.GetSeriesColor(0), cells: []string{"2.6666666666666665%", "3%", "4%"}}, rows[0])
+	assert.Equal(t, []string{"-", "-", "-"}, rows[1].cells, "no finite samples")
+	assert.Equal(t, "fan", rows[2].name)
+	assert.Equal(t, []string{"20 rpm", "20 rpm", "30 rpm"}, rows[2].cells, "the right axis' formatter")
+
+	// A query with its own valueExpr formats its series', whatever its axis shows.
+	p.queryFormatters = map[string]func(float64) string{"fan": func(v float64) string { return model.SampleValue(v).String() + " Hz" }}
+	assert.Equal(t, []string{"20 Hz", "20 Hz", "30 Hz"}, p.legendRows(matrix)[2].cells)
+
+	// A series without labels takes the title; without a valueExpr, the axis' format.
+	p = chartParams{title: "temps", legendTable: true, legendColumns: []string{config.ColumnMin}}
+	rows = p.legendRows(model.Matrix{{Values: []model.SamplePair{{Value: 1500}}}})
+	assert.Equal(t, "temps", rows[0].name)
+	assert.Equal(t, []string{"1.5k"}, rows[0].cells)
+
+	// The categorical types follow their slices: sorted by name, without empty series.
+	p = chartParams{chart: config.ChartPie, legendTable: true, legendColumns: []string{config.ColumnLast}}
+	matrix = makeMatrix([][]float64{{1}, {math.NaN()}, {3}})
+	matrix[0].Metric = model.Metric{"series": "b"}
+	matrix[2].Metric = model.Metric{"series": "a"}
+	rows = p.legendRows(matrix)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "a", rows[0].name)
+	assert.Equal(t, "b", rows[1].name)
+
+	assert.Nil(t, chartParams{legendColumns: defaultLegendColumns}.legendRows(matrix), "not a table")
+}
+
+func TestFitText(t *testing.T) {
+	t.Parallel()
+	measure := func(s string) int { return len([]rune(s)) }
+	assert.Equal(t, "node-1", fitText("node-1", 6, measure))
+	assert.Equal(t, "nod…", fitText("node-1", 4, measure))
+	assert.Equal(t, "…", fitText("node-1", 1, measure))
+	assert.Empty(t, fitText("node-1", 0, measure))
+}
+
+func TestRenderChart_LegendTable(t *testing.T) {
+	t.Parallel()
+	matrix := makeMatrix([][]float64{{10, 25, 15}, {5, 8, 12}})
+	matrix[0].Metric = model.Metric{"instance": "<b>&"}
+	for _, chart := range []string{config.ChartLine, config.ChartBar, config.ChartPie} {
+		t.Run(chart, func(t *testing.T) {
+			t.Parallel()
+			p := chartParams{width: 400, height: 150, format: formatSVG, chart: chart, legendTable: true, legendColumns: defaultLegendColumns}
+			svg, err := renderChart(matrix, p)
+			require.NoError(t, err)
+			out := string(svg)
+			// The table's header and two rows lengthen the image below the chart.
+			assert.Contains(t, out, `<svg width="400" height="220" `)
+			for _, h := range []string{">Last<", ">Min<", ">Max<", ">Avg<", ">s1<", ">&lt;b&gt;&amp;<"} {
+				assert.Contains(t, out, h)
+			}
+			assert.NotContains(t, out, "<b>")
+		})
+	}
+
+	// A chart without samples draws no table.
+	svg, err := renderChart(makeMatrix([][]float64{{math.NaN()}}),
+		chartParams{width: 400, height: 150, format: formatSVG, legendTable: true, legendColumns: defaultLegendColumns})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(svg), `<svg width="400" height="150" `))
+}
@@ -13,7 +13,7 @@ import (
 const (
 	defaultGraphMaxDuration = time.Hour
 	minRangeStep            = time.Minute
-	defaultValueExpr        = "string(result)"
+	defaultValueExpr        = `text != "" ? text : string(result)` // the number, or a string result's text
 	defaultGraphWidth       = 600
 	defaultGraphHeight      = 200
 )
@@ -26,18 +26,60 @@ type resolvedBadge struct {
 	config.Badge
 	valueProg  cel.Program // compiled Value expression (always set)
 	colorProg  cel.Program // compiled Color expression; nil when none
+	titleProg  cel.Program // compiled TitleExpr; nil when none
+	labelProg  cel.Program // compiled LabelColorExpr; nil when none
+	darkProg   cel.Program // compiled ColorExprDark; nil when none
 	style      string
-	labelColor string      // resolved label-segment hex; "" = default grey (#555)
-	iconPath   string      // resolved SVG path data for Icon; "" when none
-	rangeQuery *rangeQuery // non-nil when Type == range
+	labelColor string        // resolved label-segment hex; "" = default grey (#555)
+	labelDark  string        // resolved dark-mode label-segment hex; "" = no dark variant
+	iconPath   string        // resolved SVG path data for Icon; "" when none
+	maxAge     time.Duration // sample age beyond which the badge renders stale; 0 = never
+	reduce     string        // reducer for a matrix from an instant query
+	rangeQuery *rangeQuery   // non-nil when Type == range
+	onError    badgeFallback
+	onNoData   badgeFallback
+}
+
+// badgeFallback is a config.BadgeFallback merged over its default. An empty message
+// or color, or a zero status, means the built-in behavior.
+type badgeFallback struct {
+	value      *float64
+	message    string
+	color      string
+	status     int
+	hideReason bool
+}
+
+// resolveFallback merges a badge's onError/onNoData block over the default's, field
+// by field.
+func resolveFallback(b, def config.BadgeFallback) badgeFallback {
+	return badgeFallback{
+		value:      cmp.Or(b.Value, def.Value),
+		message:    cmp.Or(b.Message, def.Message),
+		color:      cmp.Or(b.Color, def.Color),
+		status:     cmp.Or(b.Status, def.Status),
+		hideReason: firstSet(false, b.HideReason, def.HideReason),
+	}
 }
 
 // resolvedGraph is a config.Graph with its window cap and default sparkline
 // parameters resolved once at startup.
 type resolvedGraph struct {
 	config.Graph
+	queries     []graphQuery  // run concurrently; one, unnamed, for a single-query graph
 	maxDuration time.Duration // 0 means unlimited
-	defaults    chartParams   // request query params override these
+	quantiles   []float64     // plotted for native-histogram series
+	series      seriesPipeline
+	annotations []annotation
+	titleProg   cel.Program // compiled TitleExpr; nil when none
+	defaults    chartParams // request query params override these
+}
+
+// graphQuery is one of a graph's range queries. Its series are tagged with name
+// (see queryLabel) when the results are merged.
+type graphQuery struct {
+	name  string
+	query string
 }
 
 // rangeQuery is the resolved window for a type: range badge.
@@ -61,12 +103,20 @@ func resolveBadge(b config.Badge, def config.Defaults, env *cel.Env) (*resolvedB
 	if labelColor != "" {
 		labelColor = colorNameToHex(labelColor)
 	}
+	labelDark := cmp.Or(b.LabelColorDark, def.Badge.LabelColorDark)
+	if labelDark != "" {
+		labelDark = colorNameToHex(labelDark)
+	}
 
 	rb := &resolvedBadge{
 		Badge:      b,
 		style:      cmp.Or(b.Style, def.Badge.Style, config.StyleFlat),
 		labelColor: labelColor,
+		labelDark:  labelDark,
 		iconPath:   iconPath,
+		reduce:     cmp.Or(b.Reduce, config.ReduceLast),
+		onError:    resolveFallback(b.OnError, def.Badge.OnError),
+		onNoData:   resolveFallback(b.OnNoData, def.Badge.OnNoData),
 	}
 
 	if rb.valueProg, err = compileStringExpr(env, b.ID, "value", cmp.Or(b.ValueExpr, defaultValueExpr)); err != nil {
@@ -77,6 +127,26 @@ func resolveBadge(b config.Badge, def config.Defaults, env *cel.Env) (*resolvedB
 			return nil, err
 		}
 	}
+	if b.TitleExpr != "" {
+		if rb.titleProg, err = compileStringExpr(env, b.ID, "title", b.TitleExpr); err != nil {
+			return nil, err
+		}
+	}
+	if b.LabelColorExpr != "" {
+		if rb.labelProg, err = compileStringExpr(env, b.ID, "labelColor", b.LabelColorExpr); err != nil {
+			return nil, err
+		}
+	}
+	if b.ColorExprDark != "" {
+		if rb.darkProg, err = compileStringExpr(env, b.ID, "colorDark", b.ColorExprDark); err != nil {
+			return nil, err
+		}
+	}
+	if b.MaxAge != "" {
+		if rb.maxAge, err = config.ParseDuration(b.MaxAge); err != nil {
+			return nil, fmt.Errorf("badge %q maxAge: %w", b.ID, err)
+		}
+	}
 	if b.Type == config.TypeRange {
 		if rb.rangeQuery, err = resolveRangeQuery(b); err != nil {
 			return nil, err
@@ -86,15 +156,20 @@ func resolveBadge(b config.Badge, def config.Defaults, env *cel.Env) (*resolvedB
 }
 
 // validMarkLine is the set of mark-line types the chart library supports — dynamic
-// values computed from the series, with no static-threshold option.
+// values computed from the series. Static values are thresholds, drawn by kromgo.
 var validMarkLine = map[string]bool{"average": true, "min": true, "max": true, "median": true}
 
 // resolveGraph precomputes a graph's cache TTL, window cap, and default parameters.
-func resolveGraph(g config.Graph, def config.Defaults, env *cel.Env) (*resolvedGraph, error) {
+// themes are the config's themes, which the graph's theme may name.
+func resolveGraph(g config.Graph, def config.Defaults, themes themeSet, env *cel.Env) (*resolvedGraph, error) {
 	theme := cmp.Or(g.Theme, def.Graph.Theme)
-	if theme != "" && !validTheme(theme) {
+	if theme != "" && !validTheme(theme, themes) {
 		return nil, fmt.Errorf("graph %q: unknown theme %q", g.ID, theme)
 	}
+	themeDark := cmp.Or(g.ThemeDark, def.Graph.ThemeDark)
+	if themeDark != "" && !validTheme(themeDark, themes) {
+		return nil, fmt.Errorf("graph %q: unknown themeDark %q", g.ID, themeDark)
+	}
 
 	font, err := resolveGraphFont(cmp.Or(g.Font, def.Graph.Font))
 	if err != nil {
@@ -111,23 +186,67 @@ func resolveGraph(g config.Graph, def config.Defaults, env *cel.Env) (*resolvedG
 		}
 	}
 
+	legend := cmp.Or(g.Legend, def.Graph.Legend, config.LegendShow)
+
+	markLineMatch := g.MarkLineMatch
+	if markLineMatch == nil {
+		markLineMatch = def.Graph.MarkLineMatch
+	}
+
 	rg := &resolvedGraph{
 		Graph:       g,
 		maxDuration: defaultGraphMaxDuration,
+		quantiles:   g.Quantiles,
 		defaults: chartParams{
-			width:     cmp.Or(g.Width, def.Graph.Width, defaultGraphWidth),
-			height:    cmp.Or(g.Height, def.Graph.Height, defaultGraphHeight),
-			legend:    firstSet(true, g.Legend, def.Graph.Legend),
-			fill:      firstSet(false, g.Fill, def.Graph.Fill),
-			yMin:      cmp.Or(g.YMin, def.Graph.YMin),
-			yMax:      cmp.Or(g.YMax, def.Graph.YMax),
-			markLines: markLines,
-			theme:     theme,
-			title:     displayTitle(g.Title, g.ID),
-			font:      font,
-			format:    formatSVG,
+			width:       cmp.Or(g.Width, def.Graph.Width, defaultGraphWidth),
+			height:      cmp.Or(g.Height, def.Graph.Height, defaultGraphHeight),
+			legend:      legend == config.LegendShow,
+			fill:        firstSet(false, g.Fill, def.Graph.Fill),
+			tooltips:    firstSet(false, g.Tooltips, def.Graph.Tooltips),
+			alignTicks:  firstSet(false, g.AlignTicks, def.Graph.AlignTicks),
+			yMin:        cmp.Or(g.YMin, def.Graph.YMin),
+			yMax:        cmp.Or(g.YMax, def.Graph.YMax),
+			markLines:   markLines,
+			markMatch:   markLineMatch,
+			theme:       theme,
+			themeDark:   themeDark,
+			themes:      themes,
+			title:       displayTitle(g.Title, g.ID),
+			font:        font,
+			format:      formatSVG,
+			chart:       g.Chart,
+			mode:        cmp.Or(g.Mode, def.Graph.Mode),
+			sparkline:   resolveSparkline(g.Sparkline, def.Graph.Sparkline),
+			nullMode:    g.NullMode,
+			downsample:  g.Downsample,
+			timeLayouts: resolveTimeLayouts(g.TimeFormats, def.Graph.TimeFormats),
+			thresholds:  resolveThresholds(g.Thresholds),
 		},
 	}
+	if tz := cmp.Or(g.Timezone, def.Graph.Timezone); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("graph %q timezone: %w", g.ID, err)
+		}
+		rg.defaults.location = loc
+	}
+	// The columns are kept when the legend isn't a table, for ?legend=table.
+	rg.defaults.legendTable = legend == config.LegendTable
+	rg.defaults.legendColumns = g.LegendColumns
+	if rg.defaults.legendColumns == nil {
+		rg.defaults.legendColumns = def.Graph.LegendColumns
+	}
+	if rg.defaults.legendColumns == nil {
+		rg.defaults.legendColumns = defaultLegendColumns
+	}
+	if g.Compare != "" {
+		if rg.defaults.compare, err = config.ParseDuration(g.Compare); err != nil {
+			return nil, fmt.Errorf("graph %q compare: %w", g.ID, err)
+		}
+	}
+	if rg.quantiles == nil {
+		rg.quantiles = defaultGraphQuantiles
+	}
 	if maxStr := cmp.Or(g.MaxDuration, def.Graph.MaxDuration); maxStr != "" {
 		d, err := config.ParseDuration(maxStr)
 		if err != nil {
@@ -135,25 +254,98 @@ func resolveGraph(g config.Graph, def config.Defaults, env *cel.Env) (*resolvedG
 		}
 		rg.maxDuration = d
 	}
-	if expr := cmp.Or(g.ValueExpr, def.Graph.ValueExpr); expr != "" {
-		prog, err := compileStringExpr(env, g.ID, "value", expr)
+	if err := rg.resolveQueries(env, cmp.Or(g.ValueExpr, def.Graph.ValueExpr)); err != nil {
+		return nil, err
+	}
+	if rg.series, err = resolveSeriesPipeline(g.Series, g.ID, env); err != nil {
+		return nil, err
+	}
+	if rg.defaults.seriesColors, err = resolveSeriesColors(g.SeriesColors); err != nil {
+		return nil, fmt.Errorf("graph %q seriesColors: %w", g.ID, err)
+	}
+	if rg.annotations, err = resolveAnnotations(g.Annotations, g.ID, env); err != nil {
+		return nil, err
+	}
+	if g.LegendExpr != "" {
+		prog, err := compileStringExpr(env, g.ID, "legend", g.LegendExpr)
 		if err != nil {
 			return nil, err
 		}
-		// Format each y-axis tick by evaluating the expression with result = the tick
-		// value (an axis tick has no series, so no labels). A runtime eval error
-		// degrades to a plain number rather than failing the whole render.
-		rg.defaults.valueFormatter = func(f float64) string {
-			s, err := evalStringExpr(prog, f, nil)
-			if err != nil {
-				return strconv.FormatFloat(f, 'f', -1, 64)
-			}
-			return s
+		rg.defaults.legendExpr = prog
+	}
+	if g.TitleExpr != "" {
+		if rg.titleProg, err = compileStringExpr(env, g.ID, "title", g.TitleExpr); err != nil {
+			return nil, err
 		}
 	}
 	return rg, nil
 }
 
+// resolveQueries sets the graph's queries and, from their axes and valueExprs, the
+// right-axis queries, each axis's tick formatter, and each query's own formatter. A
+// query's valueExpr formats its series' values, and its axis' ticks if it's the first
+// on that axis to set one; otherwise the graph's applies.
+func (rg *resolvedGraph) resolveQueries(env *cel.Env, graphExpr string) error {
+	if len(rg.Queries) == 0 {
+		rg.queries = []graphQuery{{query: rg.Query}}
+	}
+	leftExpr, rightExpr := "", ""
+	var err error
+	for _, q := range rg.Queries {
+		rg.queries = append(rg.queries, graphQuery{name: q.Name, query: q.Query})
+		if q.Axis == config.AxisRight {
+			if rg.defaults.rightQueries == nil {
+				rg.defaults.rightQueries = map[string]bool{}
+			}
+			rg.defaults.rightQueries[q.Name] = true
+			rightExpr = cmp.Or(rightExpr, q.ValueExpr)
+		} else {
+			leftExpr = cmp.Or(leftExpr, q.ValueExpr)
+		}
+		if q.ValueExpr == "" {
+			continue
+		}
+		if rg.defaults.queryFormatters == nil {
+			rg.defaults.queryFormatters = map[string]func(float64) string{}
+		}
+		if rg.defaults.queryFormatters[q.Name], err = compileAxisFormatter(env, rg.ID, q.ValueExpr); err != nil {
+			return err
+		}
+	}
+	if rg.defaults.valueFormatter, err = compileAxisFormatter(env, rg.ID, cmp.Or(leftExpr, graphExpr)); err != nil {
+		return err
+	}
+	if len(rg.defaults.rightQueries) > 0 {
+		if rg.defaults.rightValueFormatter, err = compileAxisFormatter(env, rg.ID, cmp.Or(rightExpr, graphExpr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileAxisFormatter compiles a valueExpr into a formatter for y-axis ticks or a
+// query's values, or returns nil (the chart's default numeric formatting) for an
+// empty expression.
+func compileAxisFormatter(env *cel.Env, id, expr string) (func(float64) string, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	prog, err := compileStringExpr(env, id, "value", expr)
+	if err != nil {
+		return nil, err
+	}
+	// Format each y-axis tick by evaluating the expression with result = the tick
+	// value (an axis tick has no series, so no labels or timestamp). A runtime eval
+	// error degrades to a plain number rather than failing the whole render.
+	return func(f float64) string {
+		s, err := evalStringExpr(prog, exprVars{result: f, now: time.Now()})
+		if err != nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return s
+	}, nil
+}
+
 // resolveRangeQuery parses a range badge's windowed query (already validated by
 // config.validate) into concrete durations, defaulting step to last/100 (min 1m).
 func resolveRangeQuery(b config.Badge) (*rangeQuery, error) {
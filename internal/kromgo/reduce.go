@@ -10,9 +10,19 @@ import (
 // reduceMatrix collapses each series in a range-query matrix to a single value via
 // op, producing an instant vector the normal value pipeline can consume. Series with
 // no finite samples are dropped (so an all-NaN series doesn't render as a value).
+// A native-histogram series can't be averaged or summed sample-wise, so it reduces
+// to its first histogram for op "first" and its last otherwise.
 func reduceMatrix(matrix model.Matrix, op string) model.Vector {
 	vector := make(model.Vector, 0, len(matrix))
 	for _, stream := range matrix {
+		if len(stream.Values) == 0 && len(stream.Histograms) > 0 {
+			p := stream.Histograms[len(stream.Histograms)-1]
+			if op == config.ReduceFirst {
+				p = stream.Histograms[0]
+			}
+			vector = append(vector, &model.Sample{Metric: stream.Metric, Histogram: p.Histogram, Timestamp: p.Timestamp})
+			continue
+		}
 		if value, ts, ok := reduceSamples(stream.Values, op); ok {
 			vector = append(vector, &model.Sample{Metric: stream.Metric, Value: value, Timestamp: ts})
 		}
@@ -30,12 +30,13 @@ type EndpointResponse struct {
 	CacheSeconds  int    `json:"cacheSeconds,omitempty"`
 }
 
-func writeJSON(w http.ResponseWriter, v any) error {
+func writeJSON(w http.ResponseWriter, code int, v any) error {
 	body, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 	w.Header().Set("Content-Type", mimeJSON)
+	w.WriteHeader(code)
 	_, _ = w.Write(body)
 	return nil
 }
@@ -81,34 +82,48 @@ func (p cachePolicy) apply(w http.ResponseWriter) {
 	w.Header().Set("Cache-Control", p.control)
 }
 
-// writeJSONOr writes v as JSON, falling back to a 500 error response on marshal failure.
-func writeJSONOr(w http.ResponseWriter, log *slog.Logger, id string, v any) {
-	if err := writeJSON(w, v); err != nil {
+// writeJSONOr writes v as JSON with status code, falling back to a 500 error
+// response on marshal failure.
+func writeJSONOr(w http.ResponseWriter, log *slog.Logger, id string, code int, v any) {
+	if err := writeJSON(w, code, v); err != nil {
 		log.Error("error writing json response", "error", err)
-		writeError(w, id, "Error", http.StatusInternalServerError)
+		writeError(w, id, "Error", "", http.StatusInternalServerError)
 	}
 }
 
 // errorResponse renders a failure. For an SVG request it returns a self-describing
 // error badge with HTTP 200 — so an <img> shows the error instead of a broken-image
 // icon — colored red for client errors (4xx) and grey for server/upstream (5xx).
-// Other formats get the JSON error with its status code. Errors are never cached.
+// A Prometheus-format request gets Prometheus' error envelope, and other formats the
+// JSON error, with its status code. Errors are never cached.
 func (h *Handler) errorResponse(w http.ResponseWriter, format, id, reason string, code int) {
+	h.coloredErrorResponse(w, format, id, reason, "", code)
+}
+
+// coloredErrorResponse is errorResponse with the message color set to color (the SVG
+// badge's, or the JSON error's color field); "" keeps the status-based default.
+func (h *Handler) coloredErrorResponse(w http.ResponseWriter, format, id, reason, color string, code int) {
+	if format == formatPrometheus {
+		writePrometheusError(w, reason, code)
+		return
+	}
 	if format != formatSVG {
-		writeError(w, id, reason, code)
+		writeError(w, id, reason, color, code)
 		return
 	}
 	w.Header().Set("Cache-Control", "no-store")
-	writeSVG(w, h.gen.renderError(id, reason, code))
+	writeSVG(w, h.gen.renderError(id, reason, color, code))
 }
 
-// writeError writes a shields.io-compatible error response with the given status code.
-// Errors are never cached, even if a caller set a Cache-Control header earlier.
-func writeError(w http.ResponseWriter, metric, reason string, code int) {
+// writeError writes a shields.io-compatible error response with the given status code
+// and message color ("" leaves it to shields.io). Errors are never cached, even if a
+// caller set a Cache-Control header earlier.
+func writeError(w http.ResponseWriter, metric, reason, color string, code int) {
 	body, err := json.Marshal(EndpointResponse{
 		SchemaVersion: 1,
 		Label:         metric,
 		Message:       reason,
+		Color:         color,
 		Error:         true,
 	})
 	if err != nil {
@@ -0,0 +1,164 @@
+package kromgo
+
+import (
+	"cmp"
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// otherLabel names the series a graph's series.other bucket sums the series over its
+// limit into. Like queryLabel it never reaches the JSON labels; the legend shows its
+// value.
+const otherLabel model.LabelName = "__other__"
+
+// seriesPipeline is a graph's resolved series transform (see config.SeriesTransform).
+// The zero value passes a matrix through unchanged.
+type seriesPipeline struct {
+	filter cel.Program // nil keeps every series
+	sort   string      // reducer to rank by; "" keeps the query's order
+	asc    bool
+	limit  int // 0 keeps every series
+	other  string
+}
+
+// resolveSeriesPipeline compiles a graph's series transform. A nil transform is the
+// zero (pass-through) pipeline.
+func resolveSeriesPipeline(st *config.SeriesTransform, id string, env *cel.Env) (seriesPipeline, error) {
+	if st == nil {
+		return seriesPipeline{}, nil
+	}
+	sp := seriesPipeline{sort: st.Sort, asc: st.Order == config.OrderAsc, limit: st.Limit, other: st.Other}
+	if st.Filter != "" {
+		prog, err := compileBoolExpr(env, id, "series filter", st.Filter)
+		if err != nil {
+			return seriesPipeline{}, err
+		}
+		sp.filter = prog
+	}
+	return sp, nil
+}
+
+// apply filters, sorts, and limits the matrix's series, summing those over the limit
+// into other series (one per query) when one is named. It runs before capSeries, so
+// a sorted and limited graph's cap keeps its top series rather than the first in
+// result order.
+func (sp seriesPipeline) apply(matrix model.Matrix, log *slog.Logger) model.Matrix {
+	if sp.filter == nil && sp.sort == "" && sp.limit == 0 {
+		return matrix
+	}
+	reducer := cmp.Or(sp.sort, config.ReduceLast)
+	type ranked struct {
+		stream *model.SampleStream
+		value  float64 // NaN for a series with no finite samples
+	}
+	series := make([]ranked, 0, len(matrix))
+	var filterErr error
+	now := time.Now()
+	for _, stream := range matrix {
+		value, _, ok := reduceSamples(stream.Values, reducer)
+		v := math.NaN()
+		if ok {
+			v = float64(value)
+		}
+		if sp.filter != nil {
+			if !ok {
+				continue
+			}
+			keep, err := evalBoolExpr(sp.filter, exprVars{result: v, labels: seriesLabels(stream.Metric), now: now})
+			if err != nil {
+				filterErr = cmp.Or(filterErr, err)
+				continue
+			}
+			if !keep {
+				continue
+			}
+		}
+		series = append(series, ranked{stream: stream, value: v})
+	}
+	if filterErr != nil {
+		log.Warn("series filter failed; dropping the series it failed on", "error", filterErr)
+	}
+	if sp.sort != "" {
+		slices.SortStableFunc(series, func(a, b ranked) int {
+			// Series with no finite samples rank last in either order.
+			switch an, bn := math.IsNaN(a.value), math.IsNaN(b.value); {
+			case an || bn:
+				return cmp.Compare(boolInt(an), boolInt(bn))
+			case sp.asc:
+				return cmp.Compare(a.value, b.value)
+			default:
+				return cmp.Compare(b.value, a.value)
+			}
+		})
+	}
+	out := make(model.Matrix, 0, len(series))
+	for _, r := range series {
+		out = append(out, r.stream)
+	}
+	if sp.limit == 0 || len(out) <= sp.limit {
+		return out
+	}
+	rest := out[sp.limit:]
+	out = out[:sp.limit:sp.limit]
+	if sp.other != "" {
+		out = append(out, otherSeries(rest, sp.other)...)
+	}
+	return out
+}
+
+// otherSeries sums the series over the limit into an other bucket per query, so a
+// multi-query graph never adds one query's values to another's, nor plots them on
+// another's axis. The buckets follow the order their queries first appear in.
+func otherSeries(rest model.Matrix, name string) model.Matrix {
+	var queries []model.LabelValue
+	byQuery := map[model.LabelValue]model.Matrix{}
+	for _, stream := range rest {
+		q := stream.Metric[queryLabel]
+		if _, ok := byQuery[q]; !ok {
+			queries = append(queries, q)
+		}
+		byQuery[q] = append(byQuery[q], stream)
+	}
+	out := make(model.Matrix, 0, len(queries))
+	for _, q := range queries {
+		metric := model.Metric{otherLabel: model.LabelValue(name)}
+		if q != "" {
+			metric[queryLabel] = q
+		}
+		out = append(out, sumSeries(byQuery[q], metric))
+	}
+	return out
+}
+
+// sumSeries adds the matrix's finite samples per timestamp into one series labelled
+// metric. A timestamp where no series has a finite sample is left out, drawn as a gap.
+func sumSeries(matrix model.Matrix, metric model.Metric) *model.SampleStream {
+	sums := map[model.Time]float64{}
+	for _, stream := range matrix {
+		for _, pt := range stream.Values {
+			if f := float64(pt.Value); !math.IsNaN(f) && !math.IsInf(f, 0) {
+				sums[pt.Timestamp] += f
+			}
+		}
+	}
+	values := make([]model.SamplePair, 0, len(sums))
+	for ts, sum := range sums {
+		values = append(values, model.SamplePair{Timestamp: ts, Value: model.SampleValue(sum)})
+	}
+	slices.SortFunc(values, func(a, b model.SamplePair) int { return cmp.Compare(a.Timestamp, b.Timestamp) })
+	return &model.SampleStream{Metric: metric, Values: values}
+}
+
+// boolInt is 1 for true and 0 for false, for ordering by a flag.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package kromgo
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// seriesColorSlots is how many theme colors hashed series are spread over: a theme's
+// palette, continued by the library's lightened/darkened variants past its end. A
+// fixed count keeps a series' slot the same across themes and result sizes; a graph
+// with more series than slots gives the rest the variants past them.
+const seriesColorSlots = 10
+
+// seriesColor pins the color of the series its matcher selects (a graph's seriesColors).
+type seriesColor struct {
+	match map[string]string
+	color charts.Color
+}
+
+// resolveSeriesColors parses a graph's seriesColors (matchers already validated by
+// config.Load), most specific matcher first so the first match wins. A color that
+// isn't a shields.io name or hex is an error rather than a silent green.
+func resolveSeriesColors(m map[string]string) ([]seriesColor, error) {
+	out := make([]seriesColor, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		match, err := config.ParseLabelMatcher(k)
+		if err != nil {
+			return nil, err
+		}
+		if !validColor(m[k]) {
+			return nil, fmt.Errorf("%q: unknown color %q (want a name or hex)", k, m[k])
+		}
+		out = append(out, seriesColor{match: match, color: charts.ColorFromHex(colorNameToHex(m[k]))})
+	}
+	slices.SortStableFunc(out, func(a, b seriesColor) int { return cmp.Compare(len(b.match), len(a.match)) })
+	return out, nil
+}
+
+// seriesPalette returns the theme with its series colors assigned to metrics, in
+// order: a pinned color from seriesColors, else a theme color picked by hashing the
+// series' labels. Hashed series claim slots in fingerprint order, stepping past taken
+// ones — through the seriesColorSlots, then the variants past them — so neither the
+// result order, the theme, nor the series count moves a series to another slot.
+func (p chartParams) seriesPalette(metrics []model.Metric) charts.ColorPalette {
+	theme := chartTheme(p.theme, p.themes)
+	if len(metrics) == 0 {
+		return theme
+	}
+	colors := make([]charts.Color, len(metrics))
+	var hashed []int
+	for i, metric := range metrics {
+		if c, ok := p.pinnedColor(metric); ok {
+			colors[i] = c
+		} else {
+			hashed = append(hashed, i)
+		}
+	}
+	taken := map[int]bool{}
+	slices.SortStableFunc(hashed, func(a, b int) int {
+		return cmp.Compare(metrics[a].Fingerprint(), metrics[b].Fingerprint())
+	})
+	for _, i := range hashed {
+		home := int(uint64(metrics[i].Fingerprint()) % seriesColorSlots)
+		slot := home
+		// The n-th step is the next slot round from home, in the n/seriesColorSlots-th
+		// set of variants.
+		for n := 1; taken[slot]; n++ {
+			slot = n/seriesColorSlots*seriesColorSlots + (home+n)%seriesColorSlots
+		}
+		taken[slot] = true
+		colors[i] = theme.GetSeriesColor(slot)
+	}
+	return theme.WithSeriesColors(colors)
+}
+
+// pinnedColor is the color of the first seriesColors matcher that selects metric.
+func (p chartParams) pinnedColor(metric model.Metric) (charts.Color, bool) {
+	for _, sc := range p.seriesColors {
+		if matchesLabels(metric, sc.match) {
+			return sc.color, true
+		}
+	}
+	return charts.Color{}, false
+}
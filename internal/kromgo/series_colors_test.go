@@ -0,0 +1,102 @@
+package kromgo
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"testing"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSeriesColors(t *testing.T) {
+	t.Parallel()
+	got, err := resolveSeriesColors(map[string]string{
+		"job=api":           "blue",
+		"job=api, code=500": "#ff0000",
+		`instance="node-1"`: "green",
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	// The most specific matcher is tried first; ties keep the sorted key order.
+	assert.Equal(t, map[string]string{"job": "api", "code": "500"}, got[0].match)
+	assert.Equal(t, charts.ColorFromHex("#ff0000"), got[0].color)
+	assert.Equal(t, map[string]string{"instance": "node-1"}, got[1].match)
+	assert.Equal(t, charts.ColorFromHex(colorNameToHex("blue")), got[2].color)
+
+	_, err = resolveSeriesColors(map[string]string{"nope": "red"})
+	assert.Error(t, err)
+	_, err = resolveSeriesColors(map[string]string{"job=api": "gren"})
+	assert.ErrorContains(t, err, `unknown color "gren"`, "a typo isn't silently green")
+}
+
+func TestSeriesPalette(t *testing.T) {
+	t.Parallel()
+	metrics := make([]model.Metric, 6)
+	for i := range metrics {
+		metrics[i] = model.Metric{"instance": model.LabelValue(fmt.Sprintf("node-%d", i))}
+	}
+	colorsOf := func(p chartParams, ms []model.Metric) map[string]charts.Color {
+		palette := p.seriesPalette(ms)
+		out := make(map[string]charts.Color, len(ms))
+		for i, m := range ms {
+			out[m.String()] = palette.GetSeriesColor(i)
+		}
+		return out
+	}
+
+	p := chartParams{theme: "dracula"}
+	got := colorsOf(p, metrics)
+	// Each series gets its own color, whatever order the results arrive in.
+	distinct := map[charts.Color]bool{}
+	for _, c := range got {
+		distinct[c] = true
+	}
+	assert.Len(t, distinct, len(metrics))
+	reversed := slices.Clone(metrics)
+	slices.Reverse(reversed)
+	assert.Equal(t, got, colorsOf(p, reversed))
+
+	// A pinned color wins, and doesn't move the hashed series.
+	p.seriesColors = []seriesColor{{match: map[string]string{"instance": "node-9"}, color: charts.ColorFromHex("#123456")}}
+	withPinned := colorsOf(p, append(slices.Clone(metrics), model.Metric{"instance": "node-9"}))
+	assert.Equal(t, charts.ColorFromHex("#123456"), withPinned[`{instance="node-9"}`])
+	for k, c := range got {
+		assert.Equal(t, c, withPinned[k], k)
+	}
+
+	// Past seriesColorSlots series, the slots stay hashed by the same modulus and the
+	// rest take the variants beyond: every series is distinct, and the first to claim
+	// keeps its home slot.
+	many := make([]model.Metric, 3*seriesColorSlots)
+	for i := range many {
+		many[i] = model.Metric{"instance": model.LabelValue(fmt.Sprintf("node-%d", i))}
+	}
+	p = chartParams{}
+	gotMany := colorsOf(p, many)
+	distinct = map[charts.Color]bool{}
+	for _, c := range gotMany {
+		distinct[c] = true
+	}
+	assert.Len(t, distinct, len(many))
+	first := slices.MinFunc(many, func(a, b model.Metric) int { return cmp.Compare(a.Fingerprint(), b.Fingerprint()) })
+	home := chartTheme("", nil).GetSeriesColor(int(uint64(first.Fingerprint()) % seriesColorSlots))
+	assert.Equal(t, home, gotMany[first.String()])
+	assert.Equal(t, home, colorsOf(p, []model.Metric{first})[first.String()])
+}
+
+func TestRenderChart_SeriesColors(t *testing.T) {
+	t.Parallel()
+	colors, err := resolveSeriesColors(map[string]string{"series=s1": "#123456"})
+	require.NoError(t, err)
+	data := makeMatrix([][]float64{{1, 2, 3}, {3, 2, 1}})
+	for _, chart := range []string{config.ChartLine, config.ChartBar, config.ChartPie} {
+		svg, err := renderChart(data, chartParams{width: 400, height: 150, legend: true, format: formatSVG, chart: chart, seriesColors: colors})
+		require.NoError(t, err)
+		assert.Contains(t, string(svg), "rgb(18,52,86)", "%s draws s1 in its pinned color", chart)
+	}
+}
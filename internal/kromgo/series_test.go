@@ -0,0 +1,93 @@
+package kromgo
+
+import (
+	"log/slog"
+	"math"
+	"testing"
+
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seriesNames is each series' "series" label, in order (the other bucket by its name).
+func seriesNames(matrix model.Matrix) []string {
+	names := make([]string, len(matrix))
+	for i, stream := range matrix {
+		names[i] = chartParams{}.seriesLabel(stream.Metric)
+	}
+	return names
+}
+
+func TestSeriesPipeline(t *testing.T) {
+	t.Parallel()
+	env, err := newCELEnv()
+	require.NoError(t, err)
+	// s0..s3 end at 4, 1, 3, 2; s4 has no finite samples.
+	data := func() model.Matrix {
+		return makeMatrix([][]float64{{9, 4}, {1, 1}, {3, 3}, {2, 2}, {math.NaN(), math.NaN()}})
+	}
+
+	cases := []struct {
+		name string
+		st   *config.SeriesTransform
+		want []string
+	}{
+		{"unset", nil, []string{"s0", "s1", "s2", "s3", "s4"}},
+		{"sort last desc", &config.SeriesTransform{Sort: config.ReduceLast}, []string{"s0", "s2", "s3", "s1", "s4"}},
+		{"sort asc", &config.SeriesTransform{Sort: config.ReduceLast, Order: config.OrderAsc}, []string{"s1", "s3", "s2", "s0", "s4"}},
+		{"sort max", &config.SeriesTransform{Sort: config.ReduceMax, Limit: 1}, []string{"s0"}},
+		{"top-k", &config.SeriesTransform{Sort: config.ReduceLast, Limit: 2}, []string{"s0", "s2"}},
+		{"other", &config.SeriesTransform{Sort: config.ReduceLast, Limit: 2, Other: "rest"}, []string{"s0", "s2", "rest"}},
+		{"under the limit", &config.SeriesTransform{Limit: 9, Other: "rest"}, []string{"s0", "s1", "s2", "s3", "s4"}},
+		{"filter", &config.SeriesTransform{Filter: `result >= 2 && labels.series != "s0"`}, []string{"s2", "s3"}},
+		{"filter by sort value", &config.SeriesTransform{Filter: "result > 5", Sort: config.ReduceMax}, []string{"s0"}},
+		{"filter error drops", &config.SeriesTransform{Filter: `labels.missing == "x"`}, []string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			sp, err := resolveSeriesPipeline(tc.st, "g", env)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, seriesNames(sp.apply(data(), slog.Default())))
+		})
+	}
+
+	// A filter must compile to a bool at resolve (startup).
+	_, err = resolveSeriesPipeline(&config.SeriesTransform{Filter: "labels.series"}, "g", env)
+	require.Error(t, err)
+}
+
+func TestSeriesPipeline_OtherPerQuery(t *testing.T) {
+	t.Parallel()
+	// temp's series end at 50 and 40, fan's at 900, 800, and 700.
+	matrix := makeMatrix([][]float64{{50}, {900}, {40}, {800}, {700}})
+	for i, q := range []model.LabelValue{"temp", "fan", "temp", "fan", "fan"} {
+		matrix[i].Metric[queryLabel] = q
+	}
+	sp := seriesPipeline{sort: config.ReduceLast, limit: 1, other: "rest"}
+
+	got := sp.apply(matrix, slog.Default())
+	require.Len(t, got, 3)
+	assert.Equal(t, model.LabelValue("fan"), got[0].Metric[queryLabel], "the top series")
+	// The rest are summed per query, each keeping its query (and so its axis).
+	assert.Equal(t, model.Metric{otherLabel: "rest", queryLabel: "fan"}, got[1].Metric)
+	assert.Equal(t, model.SampleValue(1500), got[1].Values[0].Value)
+	assert.Equal(t, model.Metric{otherLabel: "rest", queryLabel: "temp"}, got[2].Metric)
+	assert.Equal(t, model.SampleValue(90), got[2].Values[0].Value)
+	assert.Equal(t, "temp (rest)", chartParams{}.seriesLabel(got[2].Metric))
+}
+
+func TestSumSeries(t *testing.T) {
+	t.Parallel()
+	matrix := model.Matrix{
+		{Values: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}}},
+		{Values: []model.SamplePair{{Timestamp: 2, Value: 5}, {Timestamp: 3, Value: model.SampleValue(math.NaN())}}},
+	}
+	got := sumSeries(matrix, model.Metric{otherLabel: "other"})
+	assert.Equal(t, []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 7}}, got.Values,
+		"summed per timestamp; a timestamp with no finite sample is a gap")
+	assert.Equal(t, "other", chartParams{}.seriesLabel(got.Metric))
+	assert.Empty(t, seriesLabels(got.Metric), "the tag is not a label")
+}
@@ -0,0 +1,162 @@
+package kromgo
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// A sparkline is drawn straight onto a blank painter, without the chart library's
+// layout: no title, axes, legend, background, or padding beyond what keeps the line
+// and its dots inside the image.
+
+const (
+	defaultSparklineWidth  = 100
+	defaultSparklineHeight = 20
+	sparklineStrokeWidth   = 1.5
+	sparklineDotRadius     = 1.5
+	sparklineDashLength    = 3 // of a compare series' dashes and gaps
+	// sparklineInset keeps the stroke and dots at the extremes inside the image.
+	sparklineInset = 2
+)
+
+// sparklineParams are a graph's sparkline-mode settings (config.Sparkline).
+type sparklineParams struct {
+	width, height int
+	last          bool // mark each series' latest point
+	minMax        bool // mark each series' lowest and highest points
+}
+
+// resolveSparkline merges a graph's sparkline block over the default one.
+func resolveSparkline(s, def config.Sparkline) sparklineParams {
+	return sparklineParams{
+		width:  min(cmp.Or(s.Width, def.Width, defaultSparklineWidth), maxChartDimension),
+		height: min(cmp.Or(s.Height, def.Height, defaultSparklineHeight), maxChartDimension),
+		last:   firstSet(false, s.Last, def.Last),
+		minMax: firstSet(false, s.MinMax, def.MinMax),
+	}
+}
+
+// renderSparkline draws each series as a line in its series color, scaled to fill the
+// sparkline's size, over a transparent background, with its compare series dashed
+// behind it. A window with no samples is a blank image.
+func renderSparkline(matrix model.Matrix, p chartParams) ([]byte, error) {
+	p.width, p.height = p.sparkline.width, p.sparkline.height
+	painter := charts.NewPainter(charts.PainterOptions{
+		OutputFormat: p.format,
+		Width:        p.width,
+		Height:       p.height,
+		Font:         p.font,
+	})
+	matrix, previous := splitCompare(matrix)
+	overlay := p.compareOverlay(matrix, previous, false)
+	values, _, _, grid := p.timeSeries(matrix)
+	lo, hi := valueExtent(append(slices.Clip(values), overlay.rows()...), false)
+	if p.yMin != nil {
+		lo = *p.yMin
+	}
+	if p.yMax != nil {
+		hi = *p.yMax
+	}
+	if len(grid) == 0 || lo > hi {
+		return p.encode(painter)
+	}
+
+	null := charts.GetNullValue()
+	x := func(j int) int {
+		if len(grid) == 1 {
+			return p.width / 2
+		}
+		return sparklineInset + int(math.Round(float64(j)*float64(p.width-2*sparklineInset)/float64(len(grid)-1)))
+	}
+	y := func(v float64) int {
+		if hi == lo {
+			return p.height / 2
+		}
+		v = min(max(v, lo), hi) // a pinned range clips the line
+		return p.height - sparklineInset - int(math.Round((v-lo)/(hi-lo)*float64(p.height-2*sparklineInset)))
+	}
+	if len(grid) > 1 {
+		overlayX := make([]int, len(overlay.grid))
+		for j, ts := range overlay.grid {
+			overlayX[j] = sparklineInset + int(math.Round(gridIndex(grid, ts.Time())*float64(p.width-2*sparklineInset)/float64(len(grid)-1)))
+		}
+		for _, l := range overlay.lines {
+			painter.DashedLineStroke(comparePoints(l.values, overlayX, y), l.color,
+				sparklineStrokeWidth, []float64{sparklineDashLength, sparklineDashLength})
+		}
+	}
+	palette := p.seriesPalette(streamMetrics(matrix))
+	for i, row := range values {
+		color := palette.GetSeriesColor(i)
+		points := make([]charts.Point, len(row))
+		for j, v := range row {
+			points[j] = charts.Point{X: x(j), Y: math.MaxInt32} // a gap, to LineStroke
+			if v != null {
+				points[j].Y = y(v)
+			}
+		}
+		if p.fill {
+			fillRuns(painter, points, p.height, color.WithAlpha(fillOpacity))
+		}
+		painter.LineStroke(points, color, sparklineStrokeWidth)
+		for _, j := range p.sparklineDots(row) {
+			painter.Circle(sparklineDotRadius, points[j].X, points[j].Y, color, color, 0)
+		}
+	}
+	return p.encode(painter)
+}
+
+// fillRuns fills beneath each unbroken run of points down to the image's bottom edge.
+func fillRuns(painter *charts.Painter, points []charts.Point, bottom int, color charts.Color) {
+	var run []charts.Point
+	flush := func() {
+		if len(run) > 1 {
+			area := append(run, charts.Point{X: run[len(run)-1].X, Y: bottom}, charts.Point{X: run[0].X, Y: bottom})
+			painter.FillArea(area, color)
+		}
+		run = nil
+	}
+	for _, pt := range points {
+		if pt.Y == math.MaxInt32 {
+			flush()
+			continue
+		}
+		run = append(run, pt)
+	}
+	flush()
+}
+
+// sparklineDots is the indexes of a row's marked points: its latest, lowest, and
+// highest, as the params ask. A row with no values has none.
+func (p chartParams) sparklineDots(row []float64) []int {
+	null := charts.GetNullValue()
+	last, lowest, highest := -1, -1, -1
+	for j, v := range row {
+		if v == null {
+			continue
+		}
+		last = j
+		if lowest < 0 || v < row[lowest] {
+			lowest = j
+		}
+		if highest < 0 || v > row[highest] {
+			highest = j
+		}
+	}
+	if last < 0 {
+		return nil
+	}
+	var dots []int
+	if p.sparkline.minMax {
+		dots = append(dots, lowest, highest)
+	}
+	if p.sparkline.last {
+		dots = append(dots, last)
+	}
+	return dots
+}
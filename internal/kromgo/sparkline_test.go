@@ -0,0 +1,97 @@
+package kromgo
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSparkline(t *testing.T) {
+	t.Parallel()
+	yes, no := true, false
+	assert.Equal(t, sparklineParams{width: defaultSparklineWidth, height: defaultSparklineHeight},
+		resolveSparkline(config.Sparkline{}, config.Sparkline{}))
+	assert.Equal(t, sparklineParams{width: 120, height: 30, last: true},
+		resolveSparkline(config.Sparkline{Width: 120, MinMax: &no}, config.Sparkline{Height: 30, Last: &yes, MinMax: &yes}))
+	assert.Equal(t, maxChartDimension, resolveSparkline(config.Sparkline{Width: 99999}, config.Sparkline{}).width)
+}
+
+func TestSparklineDots(t *testing.T) {
+	t.Parallel()
+	null := charts.GetNullValue()
+	row := []float64{3, 1, 4, 1, 5, null}
+	for _, tc := range []struct {
+		name         string
+		last, minMax bool
+		want         []int
+	}{
+		{"none", false, false, nil},
+		{"last skips gaps", true, false, []int{4}},
+		{"first of equal lows", false, true, []int{1, 4}},
+		{"both", true, true, []int{1, 4, 4}},
+	} {
+		p := chartParams{sparkline: sparklineParams{last: tc.last, minMax: tc.minMax}}
+		assert.Equal(t, tc.want, p.sparklineDots(row), tc.name)
+	}
+	assert.Nil(t, chartParams{sparkline: sparklineParams{last: true}}.sparklineDots([]float64{null}))
+}
+
+func TestRenderSparkline(t *testing.T) {
+	t.Parallel()
+	p := chartParams{width: 600, height: 200, title: "CPU", legend: true, format: formatSVG,
+		sparkline: sparklineParams{width: 100, height: 20, last: true, minMax: true}}
+	svg, err := renderSparkline(makeMatrix([][]float64{{10, 25, 15, 40, 30}}), p)
+	require.NoError(t, err)
+	out := string(svg)
+	assert.True(t, strings.HasPrefix(out, `<svg width="100" height="20" `), "sized as a sparkline")
+	assert.NotContains(t, out, "<text", "no title, axes, or legend")
+	assert.NotContains(t, out, "<rect", "no background")
+	assert.Equal(t, 3, strings.Count(out, "<circle"), "min, max, and last dots")
+	assert.Contains(t, out, `d="M 2 18`, "the line starts inset at the lowest value")
+
+	p.fill = true
+	svg, err = renderSparkline(makeMatrix([][]float64{{10, math.NaN(), 15, 40}}), p)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(svg), "fill:rgba("), "only runs of two or more points are filled")
+
+	blank, err := renderSparkline(nil, p)
+	require.NoError(t, err)
+	assert.NotContains(t, string(blank), "<path", "no data draws nothing")
+}
+
+func TestRenderSparkline_PNG(t *testing.T) {
+	t.Parallel()
+	out, err := renderSparkline(makeMatrix([][]float64{{1, 3, 2}}),
+		chartParams{format: formatPNG, sparkline: sparklineParams{width: 100, height: 20}})
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, 100, img.Bounds().Dx())
+	assert.Equal(t, 20, img.Bounds().Dy())
+	_, _, _, a := img.At(50, 19).RGBA()
+	assert.Zero(t, a, "the background is transparent")
+}
+
+func TestRenderSparkline_Series(t *testing.T) {
+	t.Parallel()
+	matrix := model.Matrix{
+		{Metric: model.Metric{"instance": "a"}, Values: []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 60_000, Value: 2}}},
+		{Metric: model.Metric{"instance": "b"}, Values: []model.SamplePair{{Timestamp: 0, Value: 2}, {Timestamp: 60_000, Value: 1}}},
+	}
+	svg, err := renderSparkline(matrix, chartParams{format: formatSVG, sparkline: sparklineParams{width: 100, height: 20}})
+	require.NoError(t, err)
+	palette := chartParams{}.seriesPalette(streamMetrics(matrix))
+	for i := range matrix {
+		c := palette.GetSeriesColor(i)
+		assert.Contains(t, string(svg), fmt.Sprintf("stroke:rgb(%d,%d,%d)", c.R, c.G, c.B), "series %d in its theme color", i)
+	}
+}
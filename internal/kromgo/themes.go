@@ -1,14 +1,19 @@
 package kromgo
 
 import (
+	"fmt"
+	"maps"
+	"slices"
+
 	catppuccin "github.com/catppuccin/go"
 	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
 )
 
-// Graph themes come in two flavors: the go-analyze/charts built-ins (selected by
-// name) and kromgo's custom palettes below (Catppuccin via the official module,
-// plus a few popular editor schemes). chartTheme resolves either; validTheme
-// gates the config value at startup.
+// Graph themes come in three flavors: the go-analyze/charts built-ins (selected by
+// name), kromgo's custom palettes below (Catppuccin via the official module, plus a
+// few popular editor schemes), and those defined under the config's themes.
+// chartTheme resolves any of them; validTheme gates the config value at startup.
 
 // builtinThemes are the go-analyze/charts themes we expose by name.
 var builtinThemes = map[string]bool{
@@ -77,14 +82,55 @@ func catppuccinTheme(flavor string) charts.ColorPalette {
 	})
 }
 
-// validTheme reports whether name is a known built-in or custom theme.
-func validTheme(name string) bool {
-	return builtinThemes[name] || customThemes[name] != nil
+// themeSet is the config's themes by name, built once at startup.
+type themeSet map[string]charts.ColorPalette
+
+// resolveThemes builds the config's themes (fields already checked by config.Load)
+// through palette. A theme may not take a built-in or bundled theme's name, and its
+// colors must be shields.io names or hex.
+func resolveThemes(ts map[string]config.Theme) (themeSet, error) {
+	out := make(themeSet, len(ts))
+	for _, name := range slices.Sorted(maps.Keys(ts)) {
+		if validTheme(name, nil) {
+			return nil, fmt.Errorf("theme %q: already a built-in theme", name)
+		}
+		t := ts[name]
+		colors := map[string]string{"background": t.Background, "text": t.Text, "axis": t.Axis, "splitLine": t.SplitLine}
+		for i, c := range t.Series {
+			colors[fmt.Sprintf("series[%d]", i)] = c
+		}
+		for _, field := range slices.Sorted(maps.Keys(colors)) {
+			if !validColor(colors[field]) {
+				return nil, fmt.Errorf("theme %q %s: unknown color %q (want a name or hex)", name, field, colors[field])
+			}
+		}
+		series := make([]string, len(t.Series))
+		for i, c := range t.Series {
+			series[i] = colorNameToHex(c)
+		}
+		out[name] = palette(t.Dark, colorNameToHex(t.Background), colorNameToHex(t.Text),
+			colorNameToHex(t.Axis), colorNameToHex(t.SplitLine), series...)
+	}
+	return out, nil
+}
+
+// validColor reports whether c is a shields.io color name or a hex color.
+func validColor(c string) bool {
+	_, named := badgeColors[c]
+	return (named && c != "") || hexColorRe.MatchString(c)
 }
 
-// chartTheme resolves a theme name to a palette, falling back to the library
-// default for empty or unknown names.
-func chartTheme(name string) charts.ColorPalette {
+// validTheme reports whether name is a known built-in, custom, or config theme.
+func validTheme(name string, user themeSet) bool {
+	return builtinThemes[name] || customThemes[name] != nil || user[name] != nil
+}
+
+// chartTheme resolves a theme name to a palette — a config theme first — falling
+// back to the library default for empty or unknown names.
+func chartTheme(name string, user themeSet) charts.ColorPalette {
+	if t := user[name]; t != nil {
+		return t
+	}
 	if t := customThemes[name]; t != nil {
 		return t
 	}
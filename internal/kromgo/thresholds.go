@@ -0,0 +1,429 @@
+package kromgo
+
+import (
+	"cmp"
+	"errors"
+	"html"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// The chart library only draws mark lines at computed values (average/min/max/median)
+// and doesn't expose where it put the plot, so kromgo draws static thresholds itself:
+// it pins a round y range that keeps every threshold in view, renders a probe chart
+// with the same layout to find the plot's pixel box, then paints bands, dashed lines,
+// and labels onto the finished chart.
+
+const (
+	// thresholdBandOpacity is the alpha (0-255) for threshold bands — faint enough that
+	// the series beneath stay legible.
+	thresholdBandOpacity = 40
+	// defaultThresholdColor is used when a threshold sets no color.
+	defaultThresholdColor = "red"
+	thresholdFontSize     = 10
+)
+
+// errNoPlotArea is returned when the probe chart has no sentinel line to measure.
+var errNoPlotArea = errors.New("thresholds: could not locate the plot area")
+
+// threshold is a config.Threshold with its color resolved once at startup.
+type threshold struct {
+	value float64
+	color charts.Color
+	label string
+	band  string // config.BandAbove, config.BandBelow, or "" for a line only
+}
+
+// resolveThresholds converts a graph's thresholds, sorted by value so bands can stop
+// at their neighbor.
+func resolveThresholds(ts []config.Threshold) []threshold {
+	out := make([]threshold, 0, len(ts))
+	for _, t := range ts {
+		out = append(out, threshold{
+			value: *t.Value,
+			color: charts.ColorFromHex(colorNameToHex(cmp.Or(t.Color, defaultThresholdColor))),
+			label: t.Label,
+			band:  t.Band,
+		})
+	}
+	slices.SortStableFunc(out, func(a, b threshold) int { return cmp.Compare(a.value, b.value) })
+	return out
+}
+
+// plotsTime reports whether the params' chart type plots values over time against a
+// vertical y-axis.
+func (p chartParams) plotsTime() bool {
+	switch p.chart {
+	case "", config.ChartLine, config.ChartStackedArea, config.ChartBar:
+		return true
+	}
+	return false
+}
+
+// drawsThresholds reports whether the params' chart type plots values against a
+// vertical y-axis that thresholds can be drawn across. Thresholds are in the left
+// axis' units, so a matrix plotted entirely against the right axis has none, and are
+// placed by an SVG probe, so a PNG has none either.
+func (p chartParams) drawsThresholds(matrix model.Matrix) bool {
+	if len(p.thresholds) == 0 || !p.plotsTime() || p.format == formatPNG {
+		return false
+	}
+	return len(matrix) == 0 || slices.ContainsFunc(matrix, func(s *model.SampleStream) bool {
+		return !p.rightAxis(s.Metric)
+	})
+}
+
+// thresholdLayout pins the params' y range for thresholds (or a compare overlay) and
+// locates the plot they will be drawn in.
+func (p chartParams) thresholdLayout(matrix model.Matrix, data timeRows) (chartParams, plotArea, error) {
+	right, left := p.splitAxes(matrix, data.values)
+	p = p.withThresholdRange(left)
+	plot, err := locatePlot(p, *p.yMin, *p.yMax, data.values, right, data.names, data.haveNames, data.grid)
+	return p, plot, err
+}
+
+// timeLayout locates the plot of a chart whose y range is left to the library, for
+// the x-axis labels kromgo draws: the probe spans the left axis' data extent, so the
+// library fits it the same axis as the real chart. It returns errNoPlotArea when no
+// left-axis value is finite.
+func (p chartParams) timeLayout(matrix model.Matrix, data timeRows) (plotArea, error) {
+	right, left := p.splitAxes(matrix, data.values)
+	lo, hi := valueExtent(left, p.chart == config.ChartStackedArea)
+	if lo > hi {
+		return plotArea{}, errNoPlotArea
+	}
+	return locatePlot(p, lo, hi, data.values, right, data.names, data.haveNames, data.grid)
+}
+
+// splitAxes marks the chart rows plotted against the right axis, and returns the
+// rest.
+func (p chartParams) splitAxes(matrix model.Matrix, values [][]float64) (right []bool, left [][]float64) {
+	right = make([]bool, len(matrix))
+	left = make([][]float64, 0, len(values))
+	for i, stream := range matrix {
+		if right[i] = p.rightAxis(stream.Metric); !right[i] {
+			left = append(left, values[i])
+		}
+	}
+	return right, left
+}
+
+// withThresholdRange pins the y-axis to a round range covering the data, the compare
+// overlay, and every threshold, so the probe and the real chart share one known
+// scale. A bound the graph pins stays put (widened only if the data exceeds it, as
+// the library would), and thresholds outside it are clipped.
+func (p chartParams) withThresholdRange(values [][]float64) chartParams {
+	lo, hi := valueExtent(values, p.chart == config.ChartStackedArea)
+	overlayLo, overlayHi := valueExtent(p.overlay.rows(), false) // already stacked
+	lo, hi = min(lo, overlayLo), max(hi, overlayHi)
+	if p.yMin != nil {
+		lo = min(lo, *p.yMin)
+	}
+	if p.yMax != nil {
+		hi = max(hi, *p.yMax)
+	}
+	for _, t := range p.thresholds {
+		if p.yMin == nil {
+			lo = min(lo, t.value)
+		}
+		if p.yMax == nil {
+			hi = max(hi, t.value)
+		}
+	}
+	// About one y-axis label per 40px, as the library's own spacing works out.
+	intervals := min(max(p.height/40, 2), 10)
+	lo, hi, labels := niceRange(lo, hi, p.yMin != nil, p.yMax != nil, intervals)
+	p.yMin, p.yMax, p.yLabels = &lo, &hi, labels
+	return p
+}
+
+// valueExtent is the smallest and largest finite value in the chart rows. Stacked,
+// it is the range the library fits a stacked axis to: from the lowest single value
+// (less one when positive, so the lowest series keeps some height) up to the highest
+// running total. It is (+Inf, -Inf) when there are no finite values.
+func valueExtent(values [][]float64, stacked bool) (lo, hi float64) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	var totals []float64
+	for _, row := range values {
+		for j, v := range row {
+			if v == charts.GetNullValue() {
+				continue
+			}
+			if stacked {
+				for len(totals) <= j {
+					totals = append(totals, 0)
+				}
+				totals[j] += v
+				lo, hi = min(lo, v), max(hi, totals[j])
+				continue
+			}
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	if stacked && lo > 0 && lo <= hi {
+		lo--
+	}
+	return lo, hi
+}
+
+// niceSteps are the mantissas of round axis steps (1, 2, 2.5, 5 × 10^n).
+var niceSteps = []float64{1, 2, 2.5, 5}
+
+// niceRange widens [lo, hi] outward to multiples of the smallest round step that
+// spans it in at most maxIntervals intervals, leaving a fixed bound where it is. It
+// returns the new bounds and the y-axis label count (0 when both bounds are fixed,
+// leaving the count to the library).
+func niceRange(lo, hi float64, loFixed, hiFixed bool, maxIntervals int) (float64, float64, int) {
+	if loFixed && hiFixed {
+		return lo, hi, 0
+	}
+	if hi <= lo {
+		pad := max(math.Abs(lo)*0.1, 1)
+		if hiFixed {
+			lo = hi - pad
+		} else {
+			hi = lo + pad
+		}
+	}
+	span := hi - lo
+	exp := math.Floor(math.Log10(span / float64(maxIntervals)))
+	for {
+		for _, m := range niceSteps {
+			step := m * math.Pow(10, exp)
+			newLo, newHi := lo, hi
+			switch {
+			case loFixed:
+				newHi = lo + ceilSteps(span, step)*step
+			case hiFixed:
+				newLo = hi - ceilSteps(span, step)*step
+			default:
+				newLo = math.Floor(lo/step+1e-9) * step
+				newHi = math.Ceil(hi/step-1e-9) * step
+			}
+			if n := int(math.Round((newHi - newLo) / step)); n <= maxIntervals {
+				return newLo, newHi, n + 1
+			}
+		}
+		exp++
+	}
+}
+
+// ceilSteps is how many whole steps cover span, tolerating float rounding.
+func ceilSteps(span, step float64) float64 {
+	return math.Ceil(span/step - 1e-9)
+}
+
+// plotArea is the chart's plot box in pixels, the y-axis range it spans, and the rows
+// of the x-axis line and the topmost y-axis grid line.
+type plotArea struct {
+	left, right, top, bottom int
+	min, max                 float64
+	axis, axisTop            int
+}
+
+// y maps a value to its pixel row. A flat range (one value throughout) is its bottom.
+func (a plotArea) y(v float64) int {
+	if a.max == a.min {
+		return a.bottom
+	}
+	return a.bottom - int(math.Round((v-a.min)/(a.max-a.min)*float64(a.bottom-a.top)))
+}
+
+// probeColor marks the probe's measuring line; no theme uses it.
+var probeColor = charts.Color{R: 1, G: 2, B: 3, A: 255}
+
+// probeAxisColor marks the probe's x-axis line and ticks.
+var probeAxisColor = charts.Color{R: 1, G: 2, B: 4, A: 255}
+
+// probePathRe matches a stroked, unfilled SVG path in probeColor.
+var probePathRe = regexp.MustCompile(`<path d="([^"]*)" style="[^"]*stroke:rgb\(1,2,3\);fill:none"/>`)
+
+// probeGridColor marks the probe's y-axis grid lines.
+var probeGridColor = charts.Color{R: 1, G: 2, B: 5, A: 255}
+
+// probeAxisRe matches a stroked SVG path in probeAxisColor.
+var probeAxisRe = regexp.MustCompile(`<path d="([^"]*)" style="[^"]*stroke:rgb\(1,2,4\)[^"]*"/>`)
+
+// probeGridRe matches a stroked SVG path in probeGridColor.
+var probeGridRe = regexp.MustCompile(`<path d="([^"]*)" style="[^"]*stroke:rgb\(1,2,5\)[^"]*"/>`)
+
+// probePointRe matches one "M x y" / "L x y" point of an SVG path.
+var probePointRe = regexp.MustCompile(`[ML] (-?\d+) (-?\d+)`)
+
+// locatePlot finds the plot box by rendering the chart's layout — title, legend,
+// x labels, and the y-axis (plus any right axis, fitted to the same data) — as an SVG
+// whose only other visible series is a line in probeColor from hi down to lo,
+// spanning every x position, over an x-axis in probeAxisColor and grid lines in
+// probeGridColor. The line's first and last points are the plot's edges (and, for a
+// y-axis pinned to [lo, hi], its top and bottom); the highest grid line is the top of
+// the y-axis. Rendered only for graphs with thresholds, annotations, a compare
+// overlay, or kromgo-drawn x labels; right[i] marks the rows plotted against the
+// right axis.
+func locatePlot(p chartParams, lo, hi float64, values [][]float64, right []bool, names []string, haveNames bool, grid []model.Time) (plotArea, error) {
+	// At least three x positions, so the measuring line can't be mistaken for a
+	// two-point legend swatch; repeated times keep the x-axis the same height.
+	for len(grid) < 3 {
+		grid = append(grid, lastOr(grid, 0))
+	}
+	theme := chartTheme(p.theme, p.themes)
+	null := charts.GetNullValue()
+	probeRow := max(slices.Index(right, false), 0) // the first left-axis row measures
+	rows := make([][]float64, max(len(values), 1))
+	colors := make([]charts.Color, len(rows))
+	for i := range rows {
+		colors[i] = theme.GetSeriesColor(i)
+		if i < len(right) && right[i] {
+			rows[i] = values[i] // keeps the right axis' labels, and so its width, the same
+			continue
+		}
+		row := make([]float64, len(grid))
+		for j := range row {
+			switch {
+			case i != probeRow:
+				row[j] = null
+			case j == 0:
+				row[j] = hi
+			default:
+				row[j] = lo
+			}
+		}
+		rows[i] = row
+	}
+	colors[probeRow] = probeColor
+	opt := charts.NewLineChartOptionWithData(rows)
+	for i := range opt.SeriesList {
+		if i < len(right) && right[i] {
+			opt.SeriesList[i].YAxisIndex = 1
+		}
+	}
+	opt.Theme = theme.WithSeriesColors(colors).WithXAxisColor(probeAxisColor).WithAxisSplitLineColor(probeGridColor)
+	opt.XAxis = p.timeAxis(grid)
+	opt.XAxis.Theme = nil                     // an invisible axis would hide probeAxisColor
+	opt.XAxis.BoundaryGap = charts.Ptr(false) // first and last points on the plot's edges
+	opt.Title = p.chartTitle()
+	opt.Legend = p.chartLegend(names, haveNames)
+	// Match the real chart's legend swatch so the legend wraps the same way.
+	opt.Legend.Symbol = charts.SymbolCircle
+	if p.chart == config.ChartBar {
+		opt.Legend.Symbol = charts.SymbolSquare
+	}
+	opt.Symbol = charts.SymbolNone
+	opt.YAxis = p.valueAxes()
+
+	svg, err := renderProbe(p, formatSVG, func(probe *charts.Painter) error { return probe.LineChart(opt) })
+	if err != nil {
+		return plotArea{}, err
+	}
+	return probedPlot(svg, lo, hi, len(grid))
+}
+
+// renderProbe draws a probe chart on a painter of the params' size and font, in
+// format, and returns the encoded image.
+func renderProbe(p chartParams, format string, draw func(*charts.Painter) error) ([]byte, error) {
+	probe := charts.NewPainter(charts.PainterOptions{
+		OutputFormat: format,
+		Width:        p.width,
+		Height:       p.height,
+		Font:         p.font,
+	})
+	if err := draw(probe); err != nil {
+		return nil, err
+	}
+	return probe.Bytes()
+}
+
+// probedPlot reads the plot box off a locatePlot probe: the probeColor line of n
+// points, the probe x-axis, and the probe grid lines. It returns errNoPlotArea when
+// the SVG has no such line, as when a library upgrade changes how paths are written.
+func probedPlot(svg []byte, lo, hi float64, n int) (plotArea, error) {
+	for _, m := range probePathRe.FindAllSubmatch(svg, -1) {
+		pts := probePointRe.FindAllSubmatch(m[1], -1)
+		if len(pts) != n {
+			continue
+		}
+		coord := func(i, j int) int { n, _ := strconv.Atoi(string(pts[i][j])); return n }
+		plot := plotArea{
+			left: coord(0, 1), right: coord(len(pts)-1, 1),
+			top: coord(0, 2), bottom: coord(1, 2),
+			min: lo, max: hi,
+			axis: coord(1, 2), axisTop: coord(0, 2),
+		}
+		// The x-axis line is the probe axis' one horizontal path; the rest are ticks.
+		for _, m := range probeAxisRe.FindAllSubmatch(svg, -1) {
+			if pts := probePointRe.FindAllSubmatch(m[1], -1); len(pts) == 2 && string(pts[0][2]) == string(pts[1][2]) {
+				plot.axis, _ = strconv.Atoi(string(pts[0][2]))
+				break
+			}
+		}
+		for _, m := range probeGridRe.FindAllSubmatch(svg, -1) {
+			if pts := probePointRe.FindAllSubmatch(m[1], -1); len(pts) > 0 {
+				y, _ := strconv.Atoi(string(pts[0][2]))
+				plot.axisTop = min(plot.axisTop, y)
+			}
+		}
+		return plot, nil
+	}
+	return plotArea{}, errNoPlotArea
+}
+
+// lastOr returns the last element of s, or fallback when s is empty.
+func lastOr[T any](s []T, fallback T) T {
+	if len(s) == 0 {
+		return fallback
+	}
+	return s[len(s)-1]
+}
+
+// drawThresholds paints the params' thresholds onto a rendered chart: bands first,
+// then dashed lines and labels over them. Thresholds outside the plot are skipped and
+// bands are clipped to it.
+func drawThresholds(painter *charts.Painter, plot plotArea, p chartParams) {
+	clamp := func(y int) int { return min(max(y, plot.top), plot.bottom) }
+	for i, t := range p.thresholds {
+		var edge int
+		switch t.band {
+		case config.BandAbove:
+			edge = plot.top
+			if i+1 < len(p.thresholds) {
+				edge = clamp(plot.y(p.thresholds[i+1].value))
+			}
+		case config.BandBelow:
+			edge = plot.bottom
+			if i > 0 {
+				edge = clamp(plot.y(p.thresholds[i-1].value))
+			}
+		default:
+			continue
+		}
+		if y := clamp(plot.y(t.value)); y != edge {
+			fill := t.color.WithAlpha(thresholdBandOpacity)
+			painter.FilledRect(plot.left, min(y, edge), plot.right, max(y, edge), fill, fill, 0)
+		}
+	}
+	for _, t := range p.thresholds {
+		if t.value < plot.min || t.value > plot.max {
+			continue
+		}
+		y := plot.y(t.value)
+		painter.DashedLineStroke([]charts.Point{{X: plot.left, Y: y}, {X: plot.right, Y: y}},
+			t.color, 1.5, []float64{6, 3})
+		if t.label == "" {
+			continue
+		}
+		label := t.label
+		if p.format != formatPNG {
+			label = html.EscapeString(label) // the library writes SVG text unescaped
+		}
+		painter.Text(label, plot.left+4, y-4, 0, charts.FontStyle{
+			Font:      p.font,
+			FontSize:  thresholdFontSize,
+			FontColor: t.color,
+		})
+	}
+}
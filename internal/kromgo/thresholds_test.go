@@ -0,0 +1,209 @@
+package kromgo
+
+import (
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"testing"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNiceRange(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name             string
+		lo, hi           float64
+		loFixed, hiFixed bool
+		wantLo, wantHi   float64
+		wantLabels       int
+	}{
+		{"round outward", 3, 95, false, false, 0, 100, 6},
+		{"fractional", 0.12, 0.87, false, false, 0, 1, 6},
+		{"negative", -12, 30, false, false, -20, 30, 6},
+		{"fixed min", 0, 95, true, false, 0, 100, 6},
+		{"fixed max", 7, 100, false, true, 0, 100, 6},
+		{"both fixed", 3, 97, true, true, 3, 97, 0},
+		{"flat", 50, 50, false, false, 50, 55, 6},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			lo, hi, labels := niceRange(tc.lo, tc.hi, tc.loFixed, tc.hiFixed, 5)
+			assert.InDelta(t, tc.wantLo, lo, 1e-9)
+			assert.InDelta(t, tc.wantHi, hi, 1e-9)
+			assert.Equal(t, tc.wantLabels, labels)
+		})
+	}
+}
+
+func TestValueExtent(t *testing.T) {
+	t.Parallel()
+	rows := [][]float64{{1, 4, charts.GetNullValue()}, {2, -3, 5}}
+	lo, hi := valueExtent(rows, false)
+	assert.InDelta(t, -3, lo, 0)
+	assert.InDelta(t, 5, hi, 0)
+
+	// Stacked, the running totals are plotted: 1+2, 4-3, then 5; the axis reaches down
+	// to the lowest single value, less one when it's positive.
+	lo, hi = valueExtent(rows, true)
+	assert.InDelta(t, -3, lo, 0)
+	assert.InDelta(t, 5, hi, 0)
+	lo, hi = valueExtent([][]float64{{50, 60}, {50, 52}}, true)
+	assert.InDelta(t, 49, lo, 0)
+	assert.InDelta(t, 112, hi, 0)
+}
+
+// TestValueExtent_StackedAxis pins the library behavior valueExtent matches (and the
+// probe chart relies on): a stacked chart's y-axis is the one a plain chart spanning
+// the extent gets, its bottom fitted to the series' single values, not their totals.
+func TestValueExtent_StackedAxis(t *testing.T) {
+	t.Parallel()
+	yLabels := func(rows [][]float64, stacked bool) []string {
+		opt := charts.NewLineChartOptionWithData(rows)
+		opt.StackSeries = charts.Ptr(stacked)
+		painter := charts.NewPainter(charts.PainterOptions{OutputFormat: formatSVG, Width: 400, Height: 200})
+		require.NoError(t, painter.LineChart(opt))
+		svg, err := painter.Bytes()
+		require.NoError(t, err)
+		var labels []string
+		for _, m := range regexp.MustCompile(`<text[^>]*>([^<]*)</text>`).FindAllSubmatch(svg, -1) {
+			labels = append(labels, string(m[1]))
+		}
+		return labels
+	}
+	for _, rows := range [][][]float64{{{50, 60}, {50, 52}}, {{3, 4}, {2, 2}}, {{1, 4}, {2, -3}}, {{0.5, 0.8}, {0.2, 0.4}}} {
+		lo, hi := valueExtent(rows, true)
+		assert.Equal(t, yLabels([][]float64{{hi, lo}}, false), yLabels(rows, true), "%v", rows)
+	}
+}
+
+func TestResolveThresholds(t *testing.T) {
+	t.Parallel()
+	got := resolveThresholds([]config.Threshold{
+		{Value: new(95.0)},
+		{Value: new(80.0), Color: "#00ff00", Band: config.BandAbove},
+	})
+	require.Len(t, got, 2)
+	// Sorted by value; an unset color is red.
+	assert.InDelta(t, 80, got[0].value, 0)
+	assert.Equal(t, uint8(0xff), got[0].color.G)
+	assert.Equal(t, uint8(0xe0), got[1].color.R)
+}
+
+func TestRenderChart_Thresholds(t *testing.T) {
+	t.Parallel()
+	data := makeMatrix([][]float64{{10, 25, 15, 40, 30}})
+	thresholds := resolveThresholds([]config.Threshold{
+		{Value: new(80.0), Color: "orange", Label: "warn", Band: config.BandAbove},
+		{Value: new(95.0), Label: "a & b"},
+	})
+
+	for _, chart := range []string{config.ChartLine, config.ChartStackedArea, config.ChartBar} {
+		t.Run(chart, func(t *testing.T) {
+			t.Parallel()
+			svg, err := renderChart(data, chartParams{width: 600, height: 200, format: formatSVG, chart: chart, thresholds: thresholds})
+			require.NoError(t, err)
+			out := string(svg)
+			// The axis widens to a round 100 so both thresholds, far above the data, show.
+			assert.Contains(t, out, ">100</text>")
+			assert.Contains(t, out, ">warn</text>")
+			assert.Contains(t, out, ">a &amp; b</text>", "labels are escaped in SVG")
+			assert.Regexp(t, `stroke-dasharray="[^"]*" d="[^"]*" style="stroke-width:1.5;stroke:rgb\(254,125,55\)`, out, "warn line in orange, dashed")
+			assert.Contains(t, out, "fill:rgba(254,125,55,0.2)", "band above warn in translucent orange")
+		})
+	}
+
+	// Categorical types have no y-axis to draw them across.
+	pie, err := renderChart(data, chartParams{width: 600, height: 200, format: formatSVG, chart: config.ChartPie, thresholds: thresholds})
+	require.NoError(t, err)
+	assert.NotContains(t, string(pie), ">warn</text>")
+
+	png, err := renderChart(data, chartParams{width: 600, height: 200, format: formatPNG, thresholds: thresholds})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), png[:4])
+}
+
+func TestLocatePlot(t *testing.T) {
+	t.Parallel()
+	lo, hi := 0.0, 100.0
+	p := chartParams{width: 600, height: 200, format: formatSVG, yMin: &lo, yMax: &hi, yLabels: 6}
+	plot, err := locatePlot(p, lo, hi, nil, nil, nil, false, []model.Time{0, 60_000})
+	require.NoError(t, err)
+	assert.Less(t, plot.left, plot.right)
+	assert.Less(t, plot.top, plot.bottom)
+	assert.Equal(t, plot.bottom, plot.axis, "the x-axis runs along the plot's bottom")
+	assert.Equal(t, plot.top, plot.axisTop, "the top grid line is the pinned axis' top")
+	assert.Equal(t, plot.top, plot.y(100))
+	assert.Equal(t, plot.bottom, plot.y(0))
+	assert.Equal(t, (plot.top+plot.bottom)/2, plot.y(50))
+}
+
+func TestProbedPlot_Unrecognized(t *testing.T) {
+	t.Parallel()
+	_, err := probedPlot([]byte(`<svg><path d="M 1 2\nL 3 4" style="stroke:rgb(1,2,3);fill:none"/></svg>`), 0, 1, 3)
+	assert.ErrorIs(t, err, errNoPlotArea, "a line of the wrong length isn't the probe")
+}
+
+// TestLocatePlot_LibraryContract pins locatePlot to the chart library it scrapes: the
+// probe must find the same plot the library draws a real chart's series in. A
+// library upgrade that moves the layout or rewrites its SVG paths fails here (and the
+// version check below) rather than misplacing thresholds in production.
+func TestLocatePlot_LibraryContract(t *testing.T) {
+	t.Parallel()
+	info, ok := debug.ReadBuildInfo()
+	require.True(t, ok)
+	i := slices.IndexFunc(info.Deps, func(m *debug.Module) bool { return m.Path == "github.com/go-analyze/charts" })
+	require.NotEqual(t, -1, i)
+	assert.Equal(t, "v0.5.27", info.Deps[i].Version,
+		"the plot probes scrape this version's SVG: re-check them against the new layout, then bump this pin")
+
+	lo, hi := 0.0, 100.0
+	rows := [][]float64{{0, 100, 50, 25}, {10, 20, 30, 40}}
+	matrix := makeMatrix(rows)
+	lineRe := regexp.MustCompile(`<path d="([^"]*)" style="stroke-width:2;stroke:[^;]*;fill:none"/>`)
+	for _, p := range []chartParams{
+		{width: 600, height: 200},
+		{width: 600, height: 300, title: "Requests", legend: true, alignTicks: true},
+		{width: 400, height: 200, legend: true, theme: "dark", alignTicks: true},
+	} {
+		p.format, p.yMin, p.yMax, p.yLabels = formatSVG, &lo, &hi, 6
+		data := p.chartRows(matrix)
+		if p.alignTicks {
+			p.xTicks = p.timeTicks(data.grid)
+			require.NotEmpty(t, p.xTicks)
+		}
+		plot, err := locatePlot(p, lo, hi, data.values, make([]bool, len(data.values)), data.names, data.haveNames, data.grid)
+		require.NoError(t, err)
+
+		painter := charts.NewPainter(charts.PainterOptions{OutputFormat: formatSVG, Width: p.width, Height: p.height})
+		require.NoError(t, painter.LineChart(lineChartOption(matrix, data, p)))
+		svg, err := painter.Bytes()
+		require.NoError(t, err)
+		var lines [][]charts.Point
+		for _, m := range lineRe.FindAllSubmatch(svg, -1) {
+			var line []charts.Point
+			for _, pt := range probePointRe.FindAllSubmatch(m[1], -1) {
+				x, _ := strconv.Atoi(string(pt[1]))
+				y, _ := strconv.Atoi(string(pt[2]))
+				line = append(line, charts.Point{X: x, Y: y})
+			}
+			if len(line) == len(data.grid) { // not a legend swatch
+				lines = append(lines, line)
+			}
+		}
+		require.Len(t, lines, len(rows), "one line per series")
+		for i, line := range lines {
+			assert.Equal(t, plot.left, line[0].X, "first point on the plot's left edge")
+			assert.Equal(t, plot.right, line[len(line)-1].X, "last point on its right edge")
+			for j, pt := range line {
+				assert.InDelta(t, plot.y(rows[i][j]), pt.Y, 1, "series %d point %d", i, j)
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package kromgo
+
+import (
+	"cmp"
+	"html"
+	"math"
+	"slices"
+	"time"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// The chart library spaces x-axis labels evenly by index from wherever the window
+// starts, so they read 10:03, 10:17, 10:31. kromgo instead picks the round
+// boundaries — minutes, hours, days, weeks, months, or years in the graph's time
+// zone — that fit the window and width, blanks the library's labels, and draws its
+// own ticks and labels there, in the plot locatePlot measures.
+
+// timeUnit is a calendar boundary x-axis labels fall on.
+type timeUnit int
+
+const (
+	unitMinute timeUnit = iota
+	unitHour
+	unitDay
+	unitWeek
+	unitMonth
+	unitYear
+)
+
+// timeLayouts are Go time layouts per timeUnit; an empty one uses the default.
+type timeLayouts [unitYear + 1]string
+
+// defaultTimeLayouts are the layouts a graph's timeFormats leave unset.
+var defaultTimeLayouts = timeLayouts{
+	unitMinute: "15:04",
+	unitHour:   "15:04",
+	unitDay:    "01/02",
+	unitWeek:   "01/02",
+	unitMonth:  "Jan",
+	unitYear:   "2006",
+}
+
+// resolveTimeLayouts merges a graph's timeFormats over the defaults' field by field.
+func resolveTimeLayouts(g, def config.TimeFormats) timeLayouts {
+	return timeLayouts{
+		unitMinute: cmp.Or(g.Minute, def.Minute),
+		unitHour:   cmp.Or(g.Hour, def.Hour),
+		unitDay:    cmp.Or(g.Day, def.Day),
+		unitWeek:   cmp.Or(g.Week, def.Week),
+		unitMonth:  cmp.Or(g.Month, def.Month),
+		unitYear:   cmp.Or(g.Year, def.Year),
+	}
+}
+
+// labelLayout is the params' label layout for a boundary of unit u.
+func (p chartParams) labelLayout(u timeUnit) string {
+	return cmp.Or(p.timeLayouts[u], defaultTimeLayouts[u])
+}
+
+// timeZone is the location x-axis times are shown in: the graph's timezone, or UTC.
+func (p chartParams) timeZone() *time.Location {
+	if p.location == nil {
+		return time.UTC
+	}
+	return p.location
+}
+
+// maxTimeLabels caps the x-axis labels by width, about one per 110px.
+func (p chartParams) maxTimeLabels() int {
+	return max(p.width/110, 2)
+}
+
+// tickInterval is a label spacing: every n units, on multiples of n.
+type tickInterval struct {
+	unit   timeUnit
+	n      int
+	approx time.Duration // nominal length, to skip intervals far too fine for a window
+}
+
+// tickIntervals are the spacings tried, finest first. Each n divides its unit's
+// parent (60 minutes, 24 hours, 12 months), so boundaries repeat across parents.
+var tickIntervals = []tickInterval{
+	{unitMinute, 1, time.Minute},
+	{unitMinute, 2, 2 * time.Minute},
+	{unitMinute, 5, 5 * time.Minute},
+	{unitMinute, 10, 10 * time.Minute},
+	{unitMinute, 15, 15 * time.Minute},
+	{unitMinute, 30, 30 * time.Minute},
+	{unitHour, 1, time.Hour},
+	{unitHour, 2, 2 * time.Hour},
+	{unitHour, 3, 3 * time.Hour},
+	{unitHour, 6, 6 * time.Hour},
+	{unitHour, 12, 12 * time.Hour},
+	{unitDay, 1, 24 * time.Hour},
+	{unitWeek, 1, 7 * 24 * time.Hour},
+	{unitMonth, 1, 30 * 24 * time.Hour},
+	{unitMonth, 3, 91 * 24 * time.Hour},
+	{unitYear, 1, 365 * 24 * time.Hour},
+}
+
+// floor is the interval's last boundary at or before t, in t's location.
+func (iv tickInterval) floor(t time.Time) time.Time {
+	y, mo, d := t.Date()
+	loc := t.Location()
+	switch iv.unit {
+	case unitMinute:
+		return time.Date(y, mo, d, t.Hour(), t.Minute()/iv.n*iv.n, 0, 0, loc)
+	case unitHour:
+		return time.Date(y, mo, d, t.Hour()/iv.n*iv.n, 0, 0, 0, loc)
+	case unitDay:
+		return time.Date(y, mo, d, 0, 0, 0, 0, loc)
+	case unitWeek:
+		return time.Date(y, mo, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc) // back to Monday
+	case unitMonth:
+		return time.Date(y, mo-(mo-1)%time.Month(iv.n), 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
+	}
+}
+
+// next is the boundary after boundary b.
+func (iv tickInterval) next(b time.Time) time.Time {
+	y, mo, d := b.Date()
+	loc := b.Location()
+	switch iv.unit {
+	case unitDay:
+		return time.Date(y, mo, d+1, 0, 0, 0, 0, loc)
+	case unitWeek:
+		return time.Date(y, mo, d+7, 0, 0, 0, 0, loc)
+	case unitMonth:
+		return time.Date(y, mo+time.Month(iv.n), 1, 0, 0, 0, 0, loc)
+	case unitYear:
+		return time.Date(y+iv.n, time.January, 1, 0, 0, 0, 0, loc)
+	}
+	// Minutes and hours step in absolute time, re-aligned only when a daylight-saving
+	// shift moves them off the boundary: wall-clock arithmetic is ambiguous across
+	// one, and would skip (or repeat) the repeated hour.
+	t := b.Add(iv.approx)
+	if f := iv.floor(t); !iv.onBoundary(t) && f.After(b) {
+		return f
+	}
+	return t
+}
+
+// onBoundary reports whether t, within the day, is one of a minute or hour
+// interval's boundaries.
+func (iv tickInterval) onBoundary(t time.Time) bool {
+	if t.Second() != 0 || t.Nanosecond() != 0 {
+		return false
+	}
+	if iv.unit == unitMinute {
+		return t.Minute()%iv.n == 0
+	}
+	return t.Minute() == 0 && t.Hour()%iv.n == 0
+}
+
+// boundaries lists the interval's boundaries in [first, last].
+func (iv tickInterval) boundaries(first, last time.Time) []time.Time {
+	var out []time.Time
+	for t := iv.floor(first); !t.After(last); t = iv.next(t) {
+		if !t.Before(first) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// pickInterval is the finest interval with at most maxTicks boundaries in [first,
+// last], or the coarsest when none fits.
+func pickInterval(first, last time.Time, maxTicks int) tickInterval {
+	span := last.Sub(first)
+	for _, iv := range tickIntervals {
+		if span/iv.approx > time.Duration(maxTicks) {
+			continue
+		}
+		if len(iv.boundaries(first, last)) <= maxTicks {
+			return iv
+		}
+	}
+	return tickIntervals[len(tickIntervals)-1]
+}
+
+// boundaryUnit is the largest unit t is a boundary of, but at least least: midnight
+// is a day boundary, the 1st a month's, and Monday a week's.
+func boundaryUnit(t time.Time, least timeUnit) timeUnit {
+	u := unitMinute
+	if t.Minute() == 0 {
+		u = unitHour
+		if t.Hour() == 0 {
+			switch {
+			case t.Day() == 1 && t.Month() == time.January:
+				u = unitYear
+			case t.Day() == 1:
+				u = unitMonth
+			case t.Weekday() == time.Monday:
+				u = unitWeek
+			default:
+				u = unitDay
+			}
+		}
+	}
+	return max(u, least)
+}
+
+// timeTick is an x-axis label kromgo draws, at a fraction of the plot's width.
+type timeTick struct {
+	at    float64
+	label string
+}
+
+// timeTicks places x-axis labels on the round boundaries within the grid, each in
+// the layout of the largest boundary it falls on. It returns nil, leaving the labels
+// to the library, for a grid of under three points (too few for locatePlot's probe)
+// or one no boundary falls within.
+func (p chartParams) timeTicks(grid []model.Time) []timeTick {
+	if len(grid) < 3 {
+		return nil
+	}
+	loc := p.timeZone()
+	first, last := grid[0].Time().In(loc), grid[len(grid)-1].Time().In(loc)
+	iv := pickInterval(first, last, p.maxTimeLabels())
+	var ticks []timeTick
+	for _, t := range iv.boundaries(first, last) {
+		ticks = append(ticks, timeTick{at: p.plotFraction(grid, t), label: t.Format(p.labelLayout(boundaryUnit(t, iv.unit)))})
+	}
+	return ticks
+}
+
+// plotFraction is t's fraction of the plot's width, for a grid of at least two times.
+func (p chartParams) plotFraction(grid []model.Time, t time.Time) float64 {
+	if p.chart == config.ChartBar {
+		// Bars are centered in equal slots, so the grid's ends are half a slot in.
+		return (gridIndex(grid, t) + 0.5) / float64(len(grid))
+	}
+	return gridIndex(grid, t) / float64(len(grid)-1)
+}
+
+// gridIndex is t's fractional index on the grid, interpolated between the points
+// either side (the grid may be uneven, downsampled or without a step).
+func gridIndex(grid []model.Time, t time.Time) float64 {
+	ts := model.TimeFromUnixNano(t.UnixNano())
+	j, _ := slices.BinarySearch(grid, ts)
+	switch {
+	case j == 0:
+		return 0
+	case j == len(grid):
+		return float64(len(grid) - 1)
+	}
+	lo, hi := grid[j-1], grid[j]
+	return float64(j-1) + float64(ts-lo)/float64(hi-lo)
+}
+
+// timeAxisLabels formats one x-axis label per grid time for the library to sample,
+// when kromgo doesn't place them itself: all in the layout of the spacing the
+// window's own labels would use.
+func (p chartParams) timeAxisLabels(grid []model.Time) []string {
+	if len(grid) == 0 {
+		return nil
+	}
+	loc := p.timeZone()
+	first, last := grid[0].Time().In(loc), grid[len(grid)-1].Time().In(loc)
+	layout := p.labelLayout(pickInterval(first, last, p.maxTimeLabels()).unit)
+	labels := make([]string, len(grid))
+	for i, ts := range grid {
+		labels[i] = ts.Time().In(loc).Format(layout)
+	}
+	return labels
+}
+
+// timeAxis is the x-axis over the grid. With ticks kromgo draws, it's an invisible
+// axis of blank labels that keeps the layout's height. Otherwise it's one label per
+// grid time, capped by width: one label per sample collides, so the library samples
+// an evenly-spaced, non-overlapping subset. Either way the gap is pinned so the
+// points sit where plotFraction places what kromgo draws: lines edge to edge, bars
+// centered in their slots.
+func (p chartParams) timeAxis(grid []model.Time) charts.XAxisOption {
+	gap := charts.Ptr(p.chart == config.ChartBar)
+	if len(p.xTicks) > 0 {
+		labels := make([]string, len(grid))
+		for i := range labels {
+			labels[i] = " "
+		}
+		return charts.XAxisOption{
+			Labels:      labels,
+			LabelCount:  2,
+			Theme:       chartTheme(p.theme, p.themes).WithXAxisColor(charts.ColorTransparent),
+			BoundaryGap: gap,
+		}
+	}
+	labels := p.timeAxisLabels(grid)
+	axis := charts.XAxisOption{Labels: labels, BoundaryGap: gap}
+	if n := len(labels); n > 0 {
+		axis.LabelCount = min(p.maxTimeLabels(), n)
+	}
+	return axis
+}
+
+const (
+	// timeTickLength and timeLabelGap match the library's axis: a 5px tick and labels
+	// set 2px below it.
+	timeTickLength = 5
+	timeLabelGap   = 2
+	// timeLabelFontSize is the library's axis label size.
+	timeLabelFontSize = 12
+	// timeLabelSpacing is the least room between two labels; a label closer to the
+	// one before it is skipped.
+	timeLabelSpacing = 8
+)
+
+// drawTimeTicks draws the x-axis the library left invisible — its line along the
+// plot's bottom, and the params' ticks and labels below it — in the theme's axis
+// colors. A label is kept inside the image, and skipped when it would crowd the one
+// before it.
+func drawTimeTicks(painter *charts.Painter, plot plotArea, p chartParams) {
+	theme := chartTheme(p.theme, p.themes)
+	style := charts.FontStyle{Font: p.font, FontSize: timeLabelFontSize, FontColor: theme.GetXAxisTextColor()}
+	stroke := theme.GetXAxisStrokeColor()
+	painter.LineStroke([]charts.Point{{X: plot.left, Y: plot.axis}, {X: plot.right, Y: plot.axis}}, stroke, 1)
+	prevEnd := math.MinInt
+	for _, t := range p.xTicks {
+		x := plot.left + int(math.Round(t.at*float64(plot.right-plot.left)))
+		painter.LineStroke([]charts.Point{{X: x, Y: plot.axis}, {X: x, Y: plot.axis + timeTickLength}}, stroke, 1)
+		box := painter.MeasureText(t.label, 0, style)
+		left := min(max(x-box.Width()/2, 0), p.width-box.Width())
+		if left < prevEnd+timeLabelSpacing {
+			continue
+		}
+		label := t.label
+		if p.format != formatPNG {
+			label = html.EscapeString(label) // the library writes SVG text unescaped
+		}
+		painter.Text(label, left, plot.axis+timeTickLength+timeLabelGap+box.Height(), 0, style)
+		prevEnd = left + box.Width()
+	}
+}
@@ -0,0 +1,133 @@
+package kromgo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stepGrid is n times step apart from start.
+func stepGrid(start time.Time, step time.Duration, n int) []model.Time {
+	grid := make([]model.Time, n)
+	for i := range grid {
+		grid[i] = model.TimeFromUnixNano(start.Add(time.Duration(i) * step).UnixNano())
+	}
+	return grid
+}
+
+func tickLabels(ticks []timeTick) []string {
+	out := make([]string, len(ticks))
+	for i, t := range ticks {
+		out[i] = t.label
+	}
+	return out
+}
+
+func TestTimeTicks(t *testing.T) {
+	t.Parallel()
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// 08:07:30 to 14:07:30 UTC: 10:07:30 to 16:07:30 in Berlin.
+	grid := stepGrid(time.Date(2026, 6, 1, 8, 7, 30, 0, time.UTC), time.Minute, 361)
+
+	ticks := chartParams{width: 600, location: berlin}.timeTicks(grid)
+	assert.Equal(t, []string{"12:00", "14:00", "16:00"}, tickLabels(ticks), "on round hours in the graph's zone")
+	assert.InDelta(t, 112.5/360, ticks[0].at, 1e-9)
+
+	utc := chartParams{width: 600}.timeTicks(grid)
+	assert.Equal(t, []string{"10:00", "12:00", "14:00"}, tickLabels(utc), "UTC by default")
+
+	wide := chartParams{width: 1200, location: berlin, chart: config.ChartBar}.timeTicks(grid)
+	assert.Equal(t, []string{"11:00", "12:00", "13:00", "14:00", "15:00", "16:00"}, tickLabels(wide), "finer ticks fit")
+	assert.InDelta(t, (52.5+0.5)/361, wide[0].at, 1e-9, "bars are centered in their slots")
+}
+
+func TestTimeTicks_Boundaries(t *testing.T) {
+	t.Parallel()
+	layouts := timeLayouts{unitDay: "Mon 2", unitMonth: "January"}
+
+	// Across midnight, the day boundary shows the date.
+	night := stepGrid(time.Date(2026, 10, 19, 21, 30, 0, 0, time.UTC), time.Minute, 7*60)
+	assert.Equal(t, []string{"22:00", "Tue 20", "02:00", "04:00"},
+		tickLabels(chartParams{width: 600, timeLayouts: layouts}.timeTicks(night)))
+
+	// A month's ticks are Mondays, and one on the 1st shows the month.
+	month := stepGrid(time.Date(2026, 5, 20, 0, 0, 0, 0, time.UTC), time.Hour, 24*30)
+	assert.Equal(t, []string{"05/25", "June", "06/08", "06/15"},
+		tickLabels(chartParams{width: 600, timeLayouts: layouts}.timeTicks(month)))
+
+	assert.Nil(t, chartParams{width: 600}.timeTicks(night[:2]), "too few points to measure")
+	seconds := stepGrid(time.Date(2026, 10, 19, 21, 30, 5, 0, time.UTC), 10*time.Second, 5)
+	assert.Nil(t, chartParams{width: 600}.timeTicks(seconds), "no boundary in the window")
+}
+
+func TestTickInterval_DaylightSaving(t *testing.T) {
+	t.Parallel()
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// Clocks go back from 03:00 CEST to 02:00 CET on 2026-10-25; 02:00 happens twice.
+	first := time.Date(2026, 10, 25, 0, 0, 0, 0, berlin)
+	hours := tickInterval{unitHour, 1, time.Hour}.boundaries(first, first.Add(6*time.Hour))
+	require.Len(t, hours, 7)
+	for i := 1; i < len(hours); i++ {
+		assert.Equal(t, time.Hour, hours[i].Sub(hours[i-1]), "hourly in absolute time")
+	}
+	days := tickInterval{unitDay, 1, 24 * time.Hour}.boundaries(first, first.Add(48*time.Hour))
+	assert.Len(t, days, 2, "the 25-hour day still has one midnight after it")
+}
+
+func TestGridIndex(t *testing.T) {
+	t.Parallel()
+	grid := []model.Time{0, 60_000, 180_000}
+	at := func(ms int64) time.Time { return time.UnixMilli(ms) }
+	assert.InDelta(t, 0, gridIndex(grid, at(-1)), 0)
+	assert.InDelta(t, 0.5, gridIndex(grid, at(30_000)), 1e-9)
+	assert.InDelta(t, 1.5, gridIndex(grid, at(120_000)), 1e-9, "interpolated across an uneven gap")
+	assert.InDelta(t, 2, gridIndex(grid, at(999_999)), 0)
+}
+
+func TestTimeAxisLabels(t *testing.T) {
+	t.Parallel()
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	grid := stepGrid(time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), 12*time.Hour, 6)
+	assert.Equal(t, []string{"10/18", "10/18", "10/19", "10/19", "10/20", "10/20"},
+		chartParams{width: 300}.timeAxisLabels(grid))
+	assert.Equal(t, []string{"18 Oct", "18 Oct", "19 Oct", "19 Oct", "20 Oct", "20 Oct"},
+		chartParams{width: 300, location: tokyo, timeLayouts: timeLayouts{unitDay: "2 Jan"}}.timeAxisLabels(grid))
+}
+
+func TestRenderChart_TimeTicks(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 10, 18, 9, 50, 0, 0, time.UTC)
+	matrix := model.Matrix{{Metric: model.Metric{"job": "api"}}}
+	for i := range 61 {
+		matrix[0].Values = append(matrix[0].Values, model.SamplePair{
+			Timestamp: model.TimeFromUnixNano(start.Add(time.Duration(i) * time.Minute).UnixNano()),
+			Value:     model.SampleValue(i % 7),
+		})
+	}
+	p := chartParams{width: 600, height: 200, format: formatSVG, start: start, end: start.Add(time.Hour), step: time.Minute}
+	svg, err := renderChart(matrix, p)
+	require.NoError(t, err)
+	assert.Contains(t, string(svg), ">09:50</text>", "opt-in: the library's labels by default")
+
+	p.alignTicks = true
+	for _, chart := range []string{config.ChartLine, config.ChartBar} {
+		p.chart = chart
+		svg, err := renderChart(matrix, p)
+		require.NoError(t, err)
+		assert.Contains(t, string(svg), ">10:00</text>", chart)
+		assert.Contains(t, string(svg), ">10:45</text>", chart)
+		assert.NotContains(t, string(svg), ">09:50</text>", "%s: labels sit on round times", chart)
+	}
+
+	p.timeLayouts = timeLayouts{unitMinute: "<15:04>"}
+	svg, err = renderChart(matrix, p)
+	require.NoError(t, err)
+	assert.Contains(t, string(svg), ">&lt;10:15&gt;</text>", "labels are escaped")
+}
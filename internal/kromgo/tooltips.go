@@ -0,0 +1,127 @@
+package kromgo
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"slices"
+
+	charts "github.com/go-analyze/charts"
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// A graph with tooltips marks each point of its line or stacked-area SVG with an
+// invisible dot whose <title> names the point's series, time, and value; hovering
+// shows the title, and an inline stylesheet reveals the dot, in its series' color.
+// Neither needs script, so they work under the CSP in an SVG opened directly or
+// embedded with <object> (an <img> embed doesn't take the pointer). The dots are
+// placed in the plot locatePlot measures, like the compare overlay; PNGs have none.
+
+const (
+	tooltipRadius      = 4
+	tooltipStrokeWidth = 2
+	// tooltipTimeLayout formats a tooltip's time, in the graph's time zone.
+	tooltipTimeLayout = "2006-01-02 15:04 MST"
+)
+
+// tooltipStyle hides the dots until hovered. A transparent dot still takes the
+// pointer, where a display:none one wouldn't.
+const tooltipStyle = `<style>.kromgo-tip{opacity:0}.kromgo-tip:hover{opacity:1}</style>`
+
+// drawsTooltips reports whether the params' chart gets tooltips: an SVG line or
+// stacked-area chart with a series on the left axis (the dots are placed by its
+// scale).
+func (p chartParams) drawsTooltips(matrix model.Matrix) bool {
+	if !p.tooltips || p.format == formatPNG {
+		return false
+	}
+	switch p.chart {
+	case "", config.ChartLine, config.ChartStackedArea:
+	default:
+		return false
+	}
+	return slices.ContainsFunc(matrix, func(s *model.SampleStream) bool {
+		return !p.rightAxis(s.Metric)
+	})
+}
+
+// chartTooltips is the tooltips' markup for the matrix's chart: tooltipStyle and a
+// dot per drawn point of each left-axis series. A stacked point sits on its running
+// total but is titled with its own value. It returns nil when there is no point to
+// mark.
+func (p chartParams) chartTooltips(matrix model.Matrix, data timeRows, plot plotArea) []byte {
+	values, grid := data.values, data.grid
+	if len(grid) < 2 {
+		return nil
+	}
+	right, _ := p.splitAxes(matrix, values)
+	null := charts.GetNullValue()
+	heights := values
+	if p.chart == config.ChartStackedArea {
+		heights = make([][]float64, len(values))
+		for i, row := range values {
+			heights[i] = slices.Clone(row)
+			if right[i] {
+				for j := range heights[i] {
+					heights[i][j] = null // the right axis' series aren't in the left's stack
+				}
+			}
+		}
+		stackRows(heights)
+	}
+	x := make([]int, len(grid))
+	for j, ts := range grid {
+		x[j] = plot.left + int(math.Round(p.plotFraction(grid, ts.Time())*float64(plot.right-plot.left)))
+	}
+	palette := p.seriesPalette(streamMetrics(matrix))
+	stroke := chartTheme(p.theme, p.themes).GetBackgroundColor()
+	var b bytes.Buffer
+	for i, stream := range matrix {
+		if right[i] {
+			continue
+		}
+		name := p.seriesLabel(stream.Metric)
+		format := p.seriesFormatter(stream.Metric)
+		if format == nil {
+			format = defaultAxisFormatter
+		}
+		color := palette.GetSeriesColor(i)
+		for j, v := range values[i] {
+			if v == null {
+				continue
+			}
+			fmt.Fprintf(&b, `<circle class="kromgo-tip" cx="%d" cy="%d" r="%d" style="fill:%s;stroke:%s;stroke-width:%d"><title>%s</title></circle>`,
+				x[j], plot.y(heights[i][j]), tooltipRadius, color.String(), stroke.String(), tooltipStrokeWidth,
+				html.EscapeString(p.tooltipText(name, grid[j], format(v))))
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return append([]byte(tooltipStyle), b.Bytes()...)
+}
+
+// tooltipText is a point's title: its time, then its series' name and value ("api:
+// 42%"), or the value alone for an unnamed series.
+func (p chartParams) tooltipText(name string, ts model.Time, value string) string {
+	at := ts.Time().In(p.timeZone()).Format(tooltipTimeLayout)
+	if name == "" {
+		return at + "\n" + value
+	}
+	return at + "\n" + name + ": " + value
+}
+
+// withTooltips adds the tooltips' markup to an encoded SVG, as the last of its
+// content so the dots are above everything the chart drew.
+func withTooltips(svg, tips []byte) []byte {
+	i := bytes.LastIndex(svg, []byte("</svg>"))
+	if i < 0 || len(tips) == 0 {
+		return svg
+	}
+	out := make([]byte, 0, len(svg)+len(tips))
+	out = append(out, svg[:i]...)
+	out = append(out, tips...)
+	return append(out, svg[i:]...)
+}
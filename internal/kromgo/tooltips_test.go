@@ -0,0 +1,94 @@
+package kromgo
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/home-operations/kromgo/internal/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChartTooltips(t *testing.T) {
+	t.Parallel()
+	matrix := makeMatrix([][]float64{{1, 2, math.NaN()}, {4, 4, 4}, {100, 100, 100}})
+	matrix[0].Metric = model.Metric{"series": "<b>"}
+	matrix[2].Metric = model.Metric{queryLabel: "fan"}
+	percent := func(v float64) string { return model.SampleValue(v).String() + "%" }
+	p := chartParams{valueFormatter: percent, rightQueries: map[string]bool{"fan": true}}
+	plot := plotArea{left: 10, right: 110, top: 0, bottom: 100, min: 0, max: 10}
+
+	svg := string(p.chartTooltips(matrix, p.chartRows(matrix), plot))
+	assert.True(t, strings.HasPrefix(svg, tooltipStyle))
+	assert.Equal(t, 5, strings.Count(svg, `class="kromgo-tip"`), "a dot per finite left-axis point")
+	assert.Contains(t, svg, `cx="10" cy="90"`)
+	assert.Contains(t, svg, `cx="60" cy="80"`)
+	assert.Contains(t, svg, `cx="110" cy="60"`)
+	assert.Contains(t, svg, "<title>1970-01-01 00:01 UTC\n&lt;b&gt;: 2%</title>", "escaped, in the axis' format")
+	assert.NotContains(t, svg, "fan", "the right axis' series has none")
+
+	// A stacked point sits on its running total, titled with its own value.
+	p.chart = config.ChartStackedArea
+	svg = string(p.chartTooltips(matrix, p.chartRows(matrix), plot))
+	assert.Contains(t, svg, `cx="60" cy="40"`)
+	assert.Contains(t, svg, "s1: 4%</title>")
+
+	one := makeMatrix([][]float64{{1}})
+	assert.Nil(t, p.chartTooltips(one, p.chartRows(one), plot), "one point has no x range")
+}
+
+func TestChartParams_TooltipText(t *testing.T) {
+	t.Parallel()
+	p := chartParams{location: time.FixedZone("CEST", 2*60*60)}
+	assert.Equal(t, "1970-01-01 02:01 CEST\napi: 42", p.tooltipText("api", 60*1000, "42"))
+	assert.Equal(t, "1970-01-01 02:01 CEST\n42", p.tooltipText("", 60*1000, "42"))
+}
+
+func TestRenderChart_Tooltips(t *testing.T) {
+	t.Parallel()
+	matrix := makeMatrix([][]float64{{10, 25, 15, 40, 30}, {5, 5, 5, 5, 5}})
+	base := chartParams{width: 400, height: 150, legend: true, format: formatSVG}
+
+	off, err := renderChart(matrix, base)
+	require.NoError(t, err)
+	assert.NotContains(t, string(off), "kromgo-tip", "opt-in")
+
+	base.tooltips = true
+	for _, chart := range []string{config.ChartLine, config.ChartStackedArea} {
+		p := base
+		p.chart = chart
+		svg, err := renderChart(matrix, p)
+		require.NoError(t, err)
+		assert.Equal(t, 10, strings.Count(string(svg), `class="kromgo-tip"`), chart)
+		assert.True(t, strings.HasSuffix(string(svg), "</svg>"), chart)
+	}
+
+	// Both color schemes' renderings carry their own.
+	p := base
+	p.themeDark = "dark"
+	svg, err := renderSchemes(p, func(p chartParams) ([]byte, error) { return renderChart(matrix, p) })
+	require.NoError(t, err)
+	assert.Equal(t, 20, strings.Count(string(svg), `class="kromgo-tip"`))
+
+	// Bars, the categorical types, and PNGs have none.
+	for _, chart := range []string{config.ChartBar, config.ChartPie} {
+		p := base
+		p.chart = chart
+		svg, err := renderChart(matrix, p)
+		require.NoError(t, err)
+		assert.NotContains(t, string(svg), "kromgo-tip", chart)
+	}
+	p = base
+	p.format = formatPNG
+	assert.False(t, p.drawsTooltips(matrix))
+}
+
+func TestWithTooltips(t *testing.T) {
+	t.Parallel()
+	svg := []byte(`<svg><path/></svg>`)
+	assert.Equal(t, `<svg><path/><circle/></svg>`, string(withTooltips(svg, []byte(`<circle/>`))))
+	assert.Equal(t, string(svg), string(withTooltips(svg, nil)))
+}
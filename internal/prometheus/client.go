@@ -4,8 +4,10 @@ package prometheus
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
@@ -28,14 +30,16 @@ func New(address string, timeout time.Duration) (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("creating prometheus client: %w", err)
 	}
-	return &Client{api: v1.NewAPI(c), timeout: timeout}, nil
+	return &Client{api: v1.NewAPI(recordingClient{c}), timeout: timeout}, nil
 }
 
-// Query runs an instant query at t, logging any warnings.
+// Query runs an instant query at t, logging any warnings. The result may be any of
+// the four PromQL types: a vector, scalar, string, or (for a range selector or
+// subquery) matrix.
 func (c *Client) Query(ctx context.Context, query string, t time.Time) (model.Value, error) {
 	ctx, cancel := c.withTimeout(ctx)
 	defer cancel()
-	value, warnings, err := c.api.Query(ctx, query, t)
+	value, warnings, err := c.query(ctx, query, t)
 	logWarnings(ctx, query, warnings)
 	if err != nil {
 		return nil, fmt.Errorf("prometheus query: %w", err)
@@ -43,6 +47,54 @@ func (c *Client) Query(ctx context.Context, query string, t time.Time) (model.Va
 	return value, nil
 }
 
+// bodyKey is the context key under which query hands recordingClient a *[]byte to
+// record the response body in.
+type bodyKey struct{}
+
+// recordingClient is the transport beneath v1.API. When the context carries a
+// *[]byte under bodyKey, it records the last response body there.
+type recordingClient struct {
+	api.Client
+}
+
+func (c recordingClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	resp, body, err := c.Client.Do(ctx, req)
+	if slot, ok := ctx.Value(bodyKey{}).(*[]byte); ok {
+		*slot = body
+	}
+	return resp, body, err
+}
+
+// query runs /api/v1/query through v1.API, which handles the envelope, its errors,
+// and the fallback to GET when POST is refused. Its decoder rejects the "string"
+// result type, which a badge can legitimately display, so such a result is decoded
+// from the recorded body instead.
+func (c *Client) query(ctx context.Context, query string, t time.Time) (model.Value, v1.Warnings, error) {
+	var body []byte
+	value, warnings, err := c.api.Query(context.WithValue(ctx, bodyKey{}, &body), query, t)
+	if err != nil {
+		if s, ok := stringResult(body); ok {
+			return s, warnings, nil
+		}
+	}
+	return value, warnings, err
+}
+
+// stringResult decodes a successful query response whose result is a string.
+func stringResult(body []byte) (*model.String, bool) {
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType model.ValueType `json:"resultType"`
+			Result     *model.String   `json:"result"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(body, &resp) != nil || resp.Status != "success" || resp.Data.ResultType != model.ValString || resp.Data.Result == nil {
+		return nil, false
+	}
+	return resp.Data.Result, true
+}
+
 // QueryRange runs a range query over r, logging any warnings.
 func (c *Client) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, error) {
 	ctx, cancel := c.withTimeout(ctx)
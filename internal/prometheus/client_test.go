@@ -58,6 +58,71 @@ func TestQueryRange_Matrix(t *testing.T) {
 	assert.Len(t, m[0].Values, 3)
 }
 
+func TestQuery_ScalarAndString(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Unix()
+
+	srv := promtest.Result(t, "scalar", []any{now, "42"})
+	c, err := New(srv.URL, 0)
+	require.NoError(t, err)
+	value, err := c.Query(context.Background(), "scalar(up)", time.Now())
+	require.NoError(t, err)
+	sc, ok := value.(*model.Scalar)
+	require.True(t, ok)
+	assert.InDelta(t, 42, float64(sc.Value), 0)
+
+	// v1.API can't decode a string result; Query must.
+	srv = promtest.Result(t, "string", []any{now, "hello"})
+	c, err = New(srv.URL, 0)
+	require.NoError(t, err)
+	value, err = c.Query(context.Background(), `"hello"`, time.Now())
+	require.NoError(t, err)
+	str, ok := value.(*model.String)
+	require.True(t, ok)
+	assert.Equal(t, "hello", str.Value)
+}
+
+func TestQuery_GetFallback(t *testing.T) {
+	t.Parallel()
+	prom := promtest.Result(t, "string", []any{time.Now().Unix(), "hello"})
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == http.MethodPost {
+			http.Error(w, "POST not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		prom.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, 0)
+	require.NoError(t, err)
+	value, err := c.Query(context.Background(), `"hello"`, time.Now())
+	require.NoError(t, err, "a proxy refusing POST is retried as GET")
+	assert.Equal(t, []string{http.MethodPost, http.MethodGet}, methods)
+	str, ok := value.(*model.String)
+	require.True(t, ok)
+	assert.Equal(t, "hello", str.Value)
+}
+
+func TestQuery_APIError(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, 0)
+	require.NoError(t, err)
+
+	_, err = c.Query(context.Background(), "up{", time.Now())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parse error")
+}
+
 func TestQuery_ServerError(t *testing.T) {
 	t.Parallel()
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
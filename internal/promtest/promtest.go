@@ -62,6 +62,19 @@ func Server(t testing.TB, vector []Sample, matrix []float64) *httptest.Server {
 	return srv
 }
 
+// Result returns an httptest.Server that answers both query endpoints with a fixed
+// result of the given type ("scalar", "string", "vector", or "matrix"), for
+// exercising the result types Server doesn't produce. It is closed automatically
+// when the test finishes.
+func Result(t testing.TB, resultType string, result any) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeResult(w, resultType, result)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
 func writeResult(w http.ResponseWriter, resultType string, result any) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]any{
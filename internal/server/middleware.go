@@ -40,8 +40,9 @@ func injectLogger(next http.Handler) http.Handler {
 
 // secureHeaders sets defensive response headers. nosniff stops MIME confusion; the
 // CSP neutralizes any markup that slips into an SVG (responses carry no scripts and
-// only inline style attributes), so a metric label can't execute as script even if
-// the SVG is opened as a top-level document.
+// only inline styles — attributes, and the <style> switching an SVG's light and dark
+// colors), so a metric label can't execute as script even if the SVG is opened as a
+// top-level document.
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		h := w.Header()